@@ -0,0 +1,159 @@
+package certificate_searcher
+
+import "sort"
+
+// A pattern occurrence, ending just before text[End]
+type AhoCorasickMatch struct {
+	Pattern int
+	End     int
+}
+
+/*
+Byte-level Aho-Corasick automaton over a fixed pattern set. After construction the trie is
+flattened into sorted edge arrays, so it stays compact for large pattern lists and is
+read-only, safe to share between goroutines without locking.
+*/
+type AhoCorasick struct {
+	Patterns []string
+	// edges of state s are edgeBytes/edgeTargets[edgeStart[s]:edgeStart[s+1]], sorted by byte
+	edgeStart   []int32
+	edgeBytes   []byte
+	edgeTargets []int32
+	fail        []int32
+	// pattern ending at the state, or -1
+	output []int32
+	// nearest state on the failure chain with an output, or -1
+	outputLink []int32
+}
+
+func NewAhoCorasick(patterns []string) *AhoCorasick {
+	ac := &AhoCorasick{Patterns: patterns}
+
+	// build the trie with maps, then flatten it
+	trie := []map[byte]int32{make(map[byte]int32)}
+	output := []int32{-1}
+	for idx, pattern := range patterns {
+		state := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			next, present := trie[state][pattern[i]]
+			if !present {
+				next = int32(len(trie))
+				trie = append(trie, make(map[byte]int32))
+				output = append(output, -1)
+				trie[state][pattern[i]] = next
+			}
+			state = next
+		}
+		if output[state] < 0 {
+			output[state] = int32(idx)
+		}
+	}
+
+	ac.output = output
+	ac.edgeStart = make([]int32, len(trie)+1)
+	for state, edges := range trie {
+		keys := make([]byte, 0, len(edges))
+		for b := range edges {
+			keys = append(keys, b)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		ac.edgeStart[state] = int32(len(ac.edgeBytes))
+		for _, b := range keys {
+			ac.edgeBytes = append(ac.edgeBytes, b)
+			ac.edgeTargets = append(ac.edgeTargets, edges[b])
+		}
+	}
+	ac.edgeStart[len(trie)] = int32(len(ac.edgeBytes))
+
+	// failure links, breadth first so a state's fail target is finished before the state
+	ac.fail = make([]int32, len(trie))
+	ac.outputLink = make([]int32, len(trie))
+	ac.outputLink[0] = -1
+	queue := make([]int32, 0, len(trie))
+	for e := ac.edgeStart[0]; e < ac.edgeStart[1]; e++ {
+		child := ac.edgeTargets[e]
+		ac.fail[child] = 0
+		ac.outputLink[child] = -1
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for e := ac.edgeStart[state]; e < ac.edgeStart[state+1]; e++ {
+			child := ac.edgeTargets[e]
+			fallback := ac.fail[state]
+			for {
+				if next, ok := ac.transition(fallback, ac.edgeBytes[e]); ok {
+					ac.fail[child] = next
+					break
+				}
+				if fallback == 0 {
+					ac.fail[child] = 0
+					break
+				}
+				fallback = ac.fail[fallback]
+			}
+
+			if ac.output[ac.fail[child]] >= 0 {
+				ac.outputLink[child] = ac.fail[child]
+			} else {
+				ac.outputLink[child] = ac.outputLink[ac.fail[child]]
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	return ac
+}
+
+func (ac *AhoCorasick) transition(state int32, b byte) (int32, bool) {
+	lo, hi := ac.edgeStart[state], ac.edgeStart[state+1]
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		switch {
+		case ac.edgeBytes[mid] == b:
+			return ac.edgeTargets[mid], true
+		case ac.edgeBytes[mid] < b:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+func (ac *AhoCorasick) States() int {
+	return len(ac.fail)
+}
+
+// Every occurrence of every pattern in text, including overlapping ones, ordered by end position
+func (ac *AhoCorasick) FindAll(text string) []AhoCorasickMatch {
+	matches := make([]AhoCorasickMatch, 0)
+
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		for {
+			if next, ok := ac.transition(state, text[i]); ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = ac.fail[state]
+		}
+
+		for s := state; s >= 0; s = ac.outputLink[s] {
+			if ac.output[s] >= 0 {
+				matches = append(matches, AhoCorasickMatch{Pattern: int(ac.output[s]), End: i + 1})
+			}
+			if s == 0 {
+				break
+			}
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,74 @@
+package certificate_searcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every occurrence of every pattern by scanning text once per pattern, ordered like FindAll
+func naiveFindAll(patterns []string, text string) []AhoCorasickMatch {
+	matches := make([]AhoCorasickMatch, 0)
+	for end := 1; end <= len(text); end++ {
+		// FindAll reports the longest pattern ending at a position first
+		for length := end; length > 0; length-- {
+			for idx, pattern := range patterns {
+				if len(pattern) == length && strings.HasSuffix(text[:end], pattern) {
+					matches = append(matches, AhoCorasickMatch{Pattern: idx, End: end})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func TestAhoCorasickFindAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		want     []AhoCorasickMatch
+	}{
+		{"no patterns", []string{}, "paypal", []AhoCorasickMatch{}},
+		{"no match", []string{"paypal"}, "example-login.com", []AhoCorasickMatch{}},
+		{"embedded target", []string{"paypal"}, "secure-paypal-login.com", []AhoCorasickMatch{{0, 13}}},
+		{"repeated", []string{"ab"}, "ababab", []AhoCorasickMatch{{0, 2}, {0, 4}, {0, 6}}},
+		{"overlapping", []string{"aa"}, "aaaa", []AhoCorasickMatch{{0, 2}, {0, 3}, {0, 4}}},
+		{"suffix patterns", []string{"he", "she", "his", "hers"}, "ushers",
+			[]AhoCorasickMatch{{1, 4}, {0, 4}, {3, 6}}},
+		{"pattern inside another", []string{"google", "goo", "oog"}, "mygoogle.com",
+			[]AhoCorasickMatch{{1, 5}, {2, 6}, {0, 8}}},
+		{"failure past a partial match", []string{"apple", "pleas"}, "applpleasapple",
+			[]AhoCorasickMatch{{1, 9}, {0, 14}}},
+		{"whole text", []string{"apple.com"}, "apple.com", []AhoCorasickMatch{{0, 9}}},
+		{"non-ASCII bytes", []string{"pаypal"}, "xn--secure-pаypal", []AhoCorasickMatch{{0, 18}}},
+	}
+
+	for _, test := range tests {
+		ac := NewAhoCorasick(test.patterns)
+		if got := ac.FindAll(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: FindAll(%q) = %v, want %v", test.name, test.text, got, test.want)
+		}
+	}
+}
+
+func TestAhoCorasickMatchesNaiveSearch(t *testing.T) {
+	patterns := []string{"google", "youtube", "face", "facebook", "book", "oo", "o", "apple", "pay", "paypal", "pal", "amazon", "zon"}
+	texts := []string{
+		"",
+		"google.com",
+		"facebook-google-login.com",
+		"my-paypal-amazon-apple-secure.net",
+		"oooooooo",
+		"youtubefacebookpaypalpalpay",
+		"gogle.com",
+	}
+
+	ac := NewAhoCorasick(patterns)
+	for _, text := range texts {
+		if got, want := ac.FindAll(text), naiveFindAll(patterns, text); !reflect.DeepEqual(got, want) {
+			t.Errorf("FindAll(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package certificate_searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/teamnsrg/zcrypto/x509"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A certificate a brand is known to hold: its Subject O, optionally only from one issuer (CN or O)
+type TrustedCertificate struct {
+	SubjectOrganization string `json:"subject_organization"`
+	Issuer              string `json:"issuer,omitempty"`
+}
+
+func (t TrustedCertificate) matches(cert *x509.Certificate) bool {
+	if !containsFold(cert.Subject.Organization, t.SubjectOrganization) {
+		return false
+	}
+	if t.Issuer == "" {
+		return true
+	}
+	return strings.EqualFold(cert.Issuer.CommonName, t.Issuer) || containsFold(cert.Issuer.Organization, t.Issuer)
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Names a brand owns. A label matching one of BaseDomains is suppressed when the labeled name is in
+OwnedDomains, falls under one of OwnedRegistrableDomains (eTLD+1s), or sits on a certificate
+matching TrustedCertificates.
+*/
+type BrandAllowlist struct {
+	Brand                   string               `json:"brand"`
+	BaseDomains             []string             `json:"base_domains"`
+	OwnedDomains            []string             `json:"owned_domains,omitempty"`
+	OwnedRegistrableDomains []string             `json:"owned_registrable_domains,omitempty"`
+	TrustedCertificates     []TrustedCertificate `json:"trusted_certificates,omitempty"`
+	ownedDomains            map[string]struct{}
+	ownedRegistrableDomains map[string]struct{}
+}
+
+func (b *BrandAllowlist) Owns(name string, cert *x509.Certificate) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	if _, present := b.ownedDomains[name]; present {
+		return true
+	}
+
+	if eTLDplus1, err := EffectiveTLDPlusOne(name); err == nil {
+		if _, present := b.ownedRegistrableDomains[eTLDplus1]; present {
+			return true
+		}
+	}
+
+	if cert != nil {
+		for _, trusted := range b.TrustedCertificates {
+			if trusted.matches(cert) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type Allowlist struct {
+	Brands       []*BrandAllowlist
+	brandsByBase map[string][]*BrandAllowlist
+	// brand -> label -> number of suppressed (name, base domain) matches
+	suppressedCounts map[string]map[DomainLabel]uint64
+	mux              sync.Mutex
+}
+
+func NewAllowlist(brands []*BrandAllowlist) *Allowlist {
+	a := &Allowlist{
+		Brands:           brands,
+		brandsByBase:     make(map[string][]*BrandAllowlist),
+		suppressedCounts: make(map[string]map[DomainLabel]uint64),
+	}
+
+	for _, brand := range brands {
+		brand.ownedDomains = make(map[string]struct{})
+		brand.ownedRegistrableDomains = make(map[string]struct{})
+		for _, domain := range brand.OwnedDomains {
+			brand.ownedDomains[strings.ToLower(domain)] = struct{}{}
+		}
+		for _, domain := range brand.OwnedRegistrableDomains {
+			brand.ownedRegistrableDomains[strings.ToLower(domain)] = struct{}{}
+		}
+
+		for _, baseDomain := range brand.BaseDomains {
+			baseDomain = strings.ToLower(baseDomain)
+			a.brandsByBase[baseDomain] = append(a.brandsByBase[baseDomain], brand)
+		}
+	}
+
+	return a
+}
+
+func LoadAllowlist(path string) (*Allowlist, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var brands []*BrandAllowlist
+	if err := json.Unmarshal(data, &brands); err != nil {
+		return nil, err
+	}
+
+	return NewAllowlist(brands), nil
+}
+
+// Returns the brand owning name among those protecting baseDomain, or nil
+func (a *Allowlist) OwningBrand(name string, baseDomain string, cert *x509.Certificate) *BrandAllowlist {
+	for _, brand := range a.brandsByBase[baseDomain] {
+		if brand.Owns(name, cert) {
+			return brand
+		}
+	}
+	return nil
+}
+
+/*
+Splits labels for name into the base domains kept and those suppressed because the brand behind
+the base domain owns name. Labels without base domains (blocklists) are always kept.
+*/
+func (a *Allowlist) Filter(name string, labels map[DomainLabel][]string, cert *x509.Certificate) (kept map[DomainLabel][]string, suppressed map[DomainLabel][]string) {
+	kept = make(map[DomainLabel][]string)
+	suppressed = make(map[DomainLabel][]string)
+
+	for label, baseDomains := range labels {
+		if len(baseDomains) == 0 {
+			kept[label] = baseDomains
+			continue
+		}
+
+		for _, baseDomain := range baseDomains {
+			brand := a.OwningBrand(name, baseDomain, cert)
+			if brand == nil {
+				kept[label] = append(kept[label], baseDomain)
+				continue
+			}
+
+			suppressed[label] = append(suppressed[label], baseDomain)
+			a.countSuppressed(brand.Brand, label)
+		}
+	}
+
+	return kept, suppressed
+}
+
+func (a *Allowlist) countSuppressed(brand string, label DomainLabel) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if _, present := a.suppressedCounts[brand]; !present {
+		a.suppressedCounts[brand] = make(map[DomainLabel]uint64)
+	}
+	a.suppressedCounts[brand][label] += 1
+}
+
+// Suppressed match counts per brand and label, for auditing the allowlist
+func (a *Allowlist) SuppressedCounts() map[string]map[string]uint64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	counts := make(map[string]map[string]uint64)
+	for brand, labelCounts := range a.suppressedCounts {
+		counts[brand] = make(map[string]uint64)
+		for label, count := range labelCounts {
+			counts[brand][label.String()] = count
+		}
+	}
+	return counts
+}
+
+func (a *Allowlist) SuppressedCountsString() string {
+	var str strings.Builder
+	counts := a.SuppressedCounts()
+
+	brands := make([]string, 0, len(counts))
+	for brand := range counts {
+		brands = append(brands, brand)
+	}
+	sort.Strings(brands)
+
+	for _, brand := range brands {
+		labels := make([]string, 0, len(counts[brand]))
+		for label := range counts[brand] {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			str.WriteString(fmt.Sprintf("%s,%s,%d\n", brand, label, counts[brand][label]))
+		}
+	}
+
+	return str.String()
+}
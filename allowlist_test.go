@@ -0,0 +1,164 @@
+package certificate_searcher
+
+import (
+	"github.com/teamnsrg/zcrypto/x509"
+	"github.com/teamnsrg/zcrypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testAllowlist() *Allowlist {
+	return NewAllowlist([]*BrandAllowlist{
+		{
+			Brand:                   "Google",
+			BaseDomains:             []string{"google.com", "youtube.com"},
+			OwnedDomains:            []string{"Googel.com"},
+			OwnedRegistrableDomains: []string{"google.it", "google.co.uk"},
+			TrustedCertificates:     []TrustedCertificate{{SubjectOrganization: "Google LLC", Issuer: "GTS CA 1C3"}},
+		},
+		{
+			Brand:               "PayPal",
+			BaseDomains:         []string{"paypal.com"},
+			TrustedCertificates: []TrustedCertificate{{SubjectOrganization: "PayPal, Inc."}},
+		},
+	})
+}
+
+func TestAllowlistFilter(t *testing.T) {
+	googleCert := &x509.Certificate{
+		Subject: pkix.Name{Organization: []string{"Google LLC"}},
+		Issuer:  pkix.Name{CommonName: "GTS CA 1C3", Organization: []string{"Google Trust Services LLC"}},
+	}
+	otherIssuerCert := &x509.Certificate{
+		Subject: pkix.Name{Organization: []string{"Google LLC"}},
+		Issuer:  pkix.Name{CommonName: "R3", Organization: []string{"Let's Encrypt"}},
+	}
+	paypalCert := &x509.Certificate{Subject: pkix.Name{Organization: []string{"paypal, inc."}}}
+
+	tests := []struct {
+		desc, name     string
+		labels         map[DomainLabel][]string
+		cert           *x509.Certificate
+		wantKept       map[DomainLabel][]string
+		wantSuppressed map[DomainLabel][]string
+	}{
+		{
+			"owned domain", "googel.com",
+			map[DomainLabel][]string{TYPOSQUATTING_CHAR_PERMUTATION: {"google.com"}},
+			nil,
+			map[DomainLabel][]string{},
+			map[DomainLabel][]string{TYPOSQUATTING_CHAR_PERMUTATION: {"google.com"}},
+		},
+		{
+			"owned eTLD+1, wildcard and subdomains included", "*.mail.google.it",
+			map[DomainLabel][]string{WRONGTLD: {"google.com", "paypal.com"}},
+			nil,
+			map[DomainLabel][]string{WRONGTLD: {"paypal.com"}},
+			map[DomainLabel][]string{WRONGTLD: {"google.com"}},
+		},
+		{
+			"only the brand of the matched base domain", "paypal.com.google.co.uk",
+			map[DomainLabel][]string{TARGET_EMBEDDING: {"paypal.com"}},
+			nil,
+			map[DomainLabel][]string{TARGET_EMBEDDING: {"paypal.com"}},
+			map[DomainLabel][]string{},
+		},
+		{
+			"trusted certificate from its issuer", "youtube-login.com",
+			map[DomainLabel][]string{COMBOSQUATTING: {"youtube.com"}},
+			googleCert,
+			map[DomainLabel][]string{},
+			map[DomainLabel][]string{COMBOSQUATTING: {"youtube.com"}},
+		},
+		{
+			"trusted organization from another issuer", "youtube-login.com",
+			map[DomainLabel][]string{COMBOSQUATTING: {"youtube.com"}},
+			otherIssuerCert,
+			map[DomainLabel][]string{COMBOSQUATTING: {"youtube.com"}},
+			map[DomainLabel][]string{},
+		},
+		{
+			"trusted organization from any issuer", "paypal-login.com",
+			map[DomainLabel][]string{COMBOSQUATTING: {"paypal.com"}},
+			paypalCert,
+			map[DomainLabel][]string{},
+			map[DomainLabel][]string{COMBOSQUATTING: {"paypal.com"}},
+		},
+		{
+			"blocklist labels are always kept", "evil.net",
+			map[DomainLabel][]string{PHISHTANK: nil, SSL_BLACKLIST: {}},
+			googleCert,
+			map[DomainLabel][]string{PHISHTANK: nil, SSL_BLACKLIST: {}},
+			map[DomainLabel][]string{},
+		},
+	}
+
+	for _, test := range tests {
+		allowlist := testAllowlist()
+		kept, suppressed := allowlist.Filter(test.name, test.labels, test.cert)
+		if !reflect.DeepEqual(kept, test.wantKept) {
+			t.Errorf("%s: kept %v, want %v", test.desc, kept, test.wantKept)
+		}
+		if !reflect.DeepEqual(suppressed, test.wantSuppressed) {
+			t.Errorf("%s: suppressed %v, want %v", test.desc, suppressed, test.wantSuppressed)
+		}
+	}
+}
+
+func TestAllowlistSuppressedCounts(t *testing.T) {
+	allowlist := testAllowlist()
+	allowlist.Filter("googel.com", map[DomainLabel][]string{TYPOSQUATTING_CHAR_PERMUTATION: {"google.com"}, EDIT_DISTANCE: {"google.com", "paypal.com"}}, nil)
+	allowlist.Filter("google.it", map[DomainLabel][]string{WRONGTLD: {"google.com"}}, nil)
+	allowlist.Filter("google.it", map[DomainLabel][]string{WRONGTLD: {"google.com"}}, nil)
+	allowlist.Filter("paypal.net", map[DomainLabel][]string{WRONGTLD: {"paypal.com"}}, nil)
+
+	want := map[string]map[string]uint64{
+		"Google": {"TYPOSQUATTING_CHAR_PERMUTATION": 1, "EDIT_DISTANCE": 1, "WRONGTLD": 2},
+	}
+	if got := allowlist.SuppressedCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SuppressedCounts() = %v, want %v", got, want)
+	}
+
+	wantString := "Google,EDIT_DISTANCE,1\nGoogle,TYPOSQUATTING_CHAR_PERMUTATION,1\nGoogle,WRONGTLD,2\n"
+	if got := allowlist.SuppressedCountsString(); got != wantString {
+		t.Errorf("SuppressedCountsString() = %q, want %q", got, wantString)
+	}
+}
+
+func TestLoadAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allowlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "allowlist.json")
+	contents := `[{"brand": "Google", "base_domains": ["google.com"], "owned_registrable_domains": ["google.it"]}]`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	allowlist, err := LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("LoadAllowlist: %s", err)
+	}
+	if brand := allowlist.OwningBrand("www.google.it", "google.com", nil); brand == nil || brand.Brand != "Google" {
+		t.Errorf("OwningBrand(www.google.it, google.com) = %v, want Google", brand)
+	}
+	if brand := allowlist.OwningBrand("www.google.it", "paypal.com", nil); brand != nil {
+		t.Errorf("OwningBrand(www.google.it, paypal.com) = %v, want nil", brand)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"brand": "Google"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAllowlist(path); err == nil {
+		t.Error("LoadAllowlist of an object succeeded, want an error")
+	}
+	if _, err := LoadAllowlist(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadAllowlist of a missing file succeeded, want an error")
+	}
+}
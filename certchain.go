@@ -3,12 +3,18 @@ package certificate_searcher
 import "github.com/teamnsrg/zcrypto/x509"
 
 type LabeledCertChain struct {
-AbuseDomains    map[string]LabelsSources `json:"abuse_domains"`
-Leaf            *x509.Certificate           `json:"leaf,omitempty"`
-LeafParent      *x509.Certificate           `json:"leaf_parent,omitempty"`
-Root            *x509.Certificate           `json:"root,omitempty"`
-ChainDepth      int                         `json:"chain_depth,omitempty"`
-ValidationLevel string                      `json:"validation_level,omitempty"`
-LeafValidLength int                         `json:"leaf_valid_len,omitempty"`
-MatchedDomains  string                      `json:"matched_domains,omitempty"`
-}
\ No newline at end of file
+	AbuseDomains map[string]LabelsSources `json:"abuse_domains"`
+	// Labels from CertificateLabelers, which apply to the chain rather than a single name
+	CertificateLabels LabelsSources            `json:"certificate_labels,omitempty"`
+	Leaf              *x509.Certificate        `json:"leaf,omitempty"`
+	LeafParent        *x509.Certificate        `json:"leaf_parent,omitempty"`
+	Root              *x509.Certificate        `json:"root,omitempty"`
+	ChainDepth        int                      `json:"chain_depth,omitempty"`
+	ValidationLevel   string                   `json:"validation_level,omitempty"`
+	LeafValidLength   int                      `json:"leaf_valid_len,omitempty"`
+	MatchedDomains    string                   `json:"matched_domains,omitempty"`
+	MatchDetails      map[string]LabelsDetails `json:"match_details,omitempty"`
+	// Matches dropped because the allowlist says the brand owns the name
+	SuppressedDomains map[string]LabelsSources `json:"suppressed_domains,omitempty"`
+	RiskScore         *RiskScore               `json:"risk_score,omitempty"`
+}
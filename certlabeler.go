@@ -0,0 +1,79 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/teamnsrg/zcrypto/x509"
+	"os"
+	"strings"
+)
+
+/*
+Labels a whole certificate chain (leaf first) rather than a single name, for detections on the
+issuer, key, validity, SAN count or extensions. nameLabels holds what the domain labelers found
+for each name on the leaf, and is empty when none of them matched. With names-only parsing the
+chain only carries names and raw bytes.
+*/
+type CertificateLabeler interface {
+	LabelCertificate(chain []*x509.Certificate, nameLabels map[string]LabelsSources) map[DomainLabel][]string
+}
+
+func sha1Fingerprint(cert *x509.Certificate) string {
+	fingerprint := sha1.Sum(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+/*
+Labels chains containing a certificate on the abuse.ch SSL Blacklist (https://sslbl.abuse.ch),
+reporting the fingerprint and listing reason as the source.
+*/
+type SSLBlacklistLabeler struct {
+	// SHA1 fingerprint -> listing reason
+	BlacklistedFingerprints map[string]string
+}
+
+/*
+Reads the SSLBL CSV export (Listingdate,SHA1,Listingreason), skipping # comments. Files with a
+bare SHA1 fingerprint per line are accepted as well.
+*/
+func NewSSLBlacklistLabeler(feedPath string) *SSLBlacklistLabeler {
+	file, err := os.Open(feedPath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	sbl := &SSLBlacklistLabeler{
+		BlacklistedFingerprints: make(map[string]string),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) == 1 {
+			sbl.BlacklistedFingerprints[strings.ToLower(fields[0])] = ""
+		} else if len(fields) >= 3 {
+			sbl.BlacklistedFingerprints[strings.ToLower(strings.TrimSpace(fields[1]))] = strings.TrimSpace(strings.Join(fields[2:], ","))
+		}
+	}
+
+	return sbl
+}
+
+func (s *SSLBlacklistLabeler) LabelCertificate(chain []*x509.Certificate, nameLabels map[string]LabelsSources) map[DomainLabel][]string {
+	certLabels := make(map[DomainLabel][]string)
+
+	for _, cert := range chain {
+		fingerprint := sha1Fingerprint(cert)
+		if reason, present := s.BlacklistedFingerprints[fingerprint]; present {
+			certLabels[SSL_BLACKLIST] = append(certLabels[SSL_BLACKLIST], fingerprint+":"+reason)
+		}
+	}
+
+	return certLabels
+}
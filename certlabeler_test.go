@@ -0,0 +1,156 @@
+package certificate_searcher
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/teamnsrg/zcrypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSSLBlacklistLabeler(t *testing.T) {
+	leaf := &x509.Certificate{Raw: []byte("leaf")}
+	issuer := &x509.Certificate{Raw: []byte("issuer")}
+	root := &x509.Certificate{Raw: []byte("root")}
+
+	dir, err := ioutil.TempDir("", "sslbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sslblacklist.csv")
+	contents := "# abuse.ch SSLBL SSL Certificate Blacklist (CSV)\n" +
+		"# Listingdate,SHA1,Listingreason\n" +
+		"2020-05-26 10:00:00," + sha1Fingerprint(leaf) + ",Dridex C&C\n" +
+		"\n" +
+		"2020-05-20 08:00:00, " + sha1Fingerprint(issuer) + " ,Malware C&C, Gozi\n" +
+		// bare fingerprints, in upper case
+		"6E5AB5E5A4D1C7A0D1B2B3C4D5E6F708192A3B4C\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	labeler := NewSSLBlacklistLabeler(path)
+	want := map[string]string{
+		sha1Fingerprint(leaf):                      "Dridex C&C",
+		sha1Fingerprint(issuer):                    "Malware C&C, Gozi",
+		"6e5ab5e5a4d1c7a0d1b2b3c4d5e6f708192a3b4c": "",
+	}
+	if !reflect.DeepEqual(labeler.BlacklistedFingerprints, want) {
+		t.Errorf("BlacklistedFingerprints = %v, want %v", labeler.BlacklistedFingerprints, want)
+	}
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		want  map[DomainLabel][]string
+	}{
+		{"listed leaf", []*x509.Certificate{leaf}, map[DomainLabel][]string{SSL_BLACKLIST: {sha1Fingerprint(leaf) + ":Dridex C&C"}}},
+		{"listed issuer", []*x509.Certificate{root, issuer}, map[DomainLabel][]string{SSL_BLACKLIST: {sha1Fingerprint(issuer) + ":Malware C&C, Gozi"}}},
+		{"whole chain", []*x509.Certificate{leaf, issuer, root}, map[DomainLabel][]string{SSL_BLACKLIST: {
+			sha1Fingerprint(leaf) + ":Dridex C&C",
+			sha1Fingerprint(issuer) + ":Malware C&C, Gozi",
+		}}},
+		{"not listed", []*x509.Certificate{root}, map[DomainLabel][]string{}},
+	}
+
+	for _, test := range tests {
+		if got := labeler.LabelCertificate(test.chain, nil); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: LabelCertificate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Labels every chain with the names the domain labelers matched on it
+type nameLabelsLabeler struct {
+	mux  sync.Mutex
+	seen []map[string]LabelsSources
+}
+
+func (n *nameLabelsLabeler) LabelCertificate(chain []*x509.Certificate, nameLabels map[string]LabelsSources) map[DomainLabel][]string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.seen = append(n.seen, nameLabels)
+
+	certLabels := make(map[DomainLabel][]string)
+	if len(chain) == 2 {
+		certLabels[COMBOSQUATTING] = []string{"two certificates"}
+	}
+	return certLabels
+}
+
+func TestSearcherCertificateLabelers(t *testing.T) {
+	leaf := testCertificate(t, "example.com")
+	labeledLeaf := testCertificate(t, "google.com.evil.net")
+	leafDER, _ := base64.StdEncoding.DecodeString(leaf)
+
+	dir, err := ioutil.TempDir("", "sslbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sslblacklist.csv")
+	if err := ioutil.WriteFile(path, []byte(sha1Fingerprint(&x509.Certificate{Raw: leafDER})+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	baseDomains := []string{"google.com"}
+	chainLabeler := &nameLabelsLabeler{}
+	var mux sync.Mutex
+	results := make(map[string]*LabeledCertChain)
+	searcher := NewSearcher(SearcherOptions{
+		Labelers:            []DomainLabeler{NewTargetEmbeddingLabeler(&baseDomains)},
+		CertificateLabelers: []CertificateLabeler{NewSSLBlacklistLabeler(path), chainLabeler},
+		OnResult: func(result *SearchResult) {
+			mux.Lock()
+			defer mux.Unlock()
+			results[result.Record.Origin] = result.Labeled
+		},
+	})
+
+	source := recordsSource{
+		// only a certificate labeler matches
+		{Origin: "blacklisted", Chain: []string{leaf}},
+		{Origin: "embedding", Chain: []string{labeledLeaf}},
+		{Origin: "both", Chain: []string{labeledLeaf, leaf}},
+		{Origin: "neither", Chain: []string{testCertificate(t, "example.org")}},
+	}
+	if err := searcher.Run(context.Background(), source, &originsSink{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprintSource := sha1Fingerprint(&x509.Certificate{Raw: leafDER}) + ":"
+	want := map[string]LabelsSources{
+		"blacklisted": {SSL_BLACKLIST: {fingerprintSource}},
+		"embedding":   nil,
+		"both":        {SSL_BLACKLIST: {fingerprintSource}, COMBOSQUATTING: {"two certificates"}},
+	}
+	if len(results) != len(want) {
+		t.Errorf("labeled %d chains, want %d", len(results), len(want))
+	}
+	for origin, wantLabels := range want {
+		labeled, present := results[origin]
+		if !present {
+			t.Errorf("%s: not labeled", origin)
+			continue
+		}
+		if !reflect.DeepEqual(labeled.CertificateLabels, wantLabels) {
+			t.Errorf("%s: certificate labels = %v, want %v", origin, labeled.CertificateLabels, wantLabels)
+		}
+	}
+
+	// certificate labelers see every chain, with what the domain labelers matched on it
+	if len(chainLabeler.seen) != len(source) {
+		t.Errorf("certificate labeler saw %d chains, want %d", len(chainLabeler.seen), len(source))
+	}
+	for _, nameLabels := range chainLabeler.seen {
+		if labels, present := nameLabels["google.com.evil.net"]; present && !reflect.DeepEqual(labels, LabelsSources{TARGET_EMBEDDING: {"google.com"}}) {
+			t.Errorf("certificate labeler saw %v for google.com.evil.net", labels)
+		}
+	}
+}
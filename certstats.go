@@ -3,60 +3,58 @@ package certificate_searcher
 import (
 	"encoding/hex"
 	"fmt"
-	"github.com/cespare/xxhash"
-	"github.com/steakknife/bloomfilter"
-	"log"
 	"strings"
 	"time"
 )
 
 type CertStats struct {
-	NoCTFingerprints        *bloomfilter.Filter
+	// Certificates already counted, by TBSNoCT fingerprint; with a persistent store, across runs
+	NoCTFingerprints        DedupStore
 	ParentSPKISubjectCounts map[string]uint64
+	// Distinct-count sketches of the certificates counted per parent, for merging with other runs
+	ParentSPKISubjectSketches map[string]*HyperLogLog
+	// Certificates counted under any parent, and their sketch
+	Certificates uint64
+	Sketch       *HyperLogLog
 }
 
-const maxElements uint64 = 10000000000
-const probCollision float64 = 0.000000001
-
-func NewCertStats() *CertStats {
-	bf, err := bloomfilter.NewOptimal(maxElements, probCollision)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func NewCertStats(fingerprints DedupStore) *CertStats {
 	return &CertStats{
-		NoCTFingerprints:        bf,
-		ParentSPKISubjectCounts: make(map[string]uint64),
+		NoCTFingerprints:          fingerprints,
+		ParentSPKISubjectCounts:   make(map[string]uint64),
+		ParentSPKISubjectSketches: make(map[string]*HyperLogLog),
+		Sketch:                    NewHyperLogLog(),
 	}
 }
 
-// Adds the parent/child if not currently in the Bloom filter, returns whether added or not (already seen)
-func (c *CertStats) AddParentChild(parentSPKI []byte, childTBSNoCT []byte) bool {
+// Adds the parent/child if not already in the dedup store, returns whether added or not (already seen)
+func (c *CertStats) AddParentChild(parentSPKI []byte, childTBSNoCT []byte) (bool, error) {
 	parentSPKIStr := hex.EncodeToString(parentSPKI)
 	if _, present := c.ParentSPKISubjectCounts[parentSPKIStr]; !present {
 		c.ParentSPKISubjectCounts[parentSPKIStr] = 0
 	}
 
-	hash := xxhash.New()
-	hash.Write(childTBSNoCT)
-
-	if c.NoCTFingerprints.Contains(hash) {
-		return false
+	added, err := c.NoCTFingerprints.Add(childTBSNoCT)
+	if err != nil || !added {
+		return false, err
 	}
 
-	c.NoCTFingerprints.Add(hash)
 	c.ParentSPKISubjectCounts[parentSPKIStr] += 1
-	return true
+	// parents whose certificates were all counted before get no sketch
+	sketch, present := c.ParentSPKISubjectSketches[parentSPKIStr]
+	if !present {
+		sketch = NewHyperLogLog()
+		c.ParentSPKISubjectSketches[parentSPKIStr] = sketch
+	}
+	sketch.Add(childTBSNoCT)
+	c.Certificates += 1
+	c.Sketch.Add(childTBSNoCT)
+	return true, nil
 }
 
 func (c CertStats) String() string {
 	var str strings.Builder
-	total := uint64(0)
-	for _, certificateCount := range c.ParentSPKISubjectCounts {
-		total += certificateCount
-	}
-
-	str.WriteString(fmt.Sprintf("%d total parent SPKI subjects, %d total certificates (TBSNoCT)\n", len(c.ParentSPKISubjectCounts), total))
+	str.WriteString(fmt.Sprintf("%d total parent SPKI subjects, %d total certificates (TBSNoCT)\n", len(c.ParentSPKISubjectCounts), c.Certificates))
 
 	for spkiSubject, certificateCount := range c.ParentSPKISubjectCounts {
 		str.WriteString(fmt.Sprintf("%s,%d\n", spkiSubject, certificateCount))
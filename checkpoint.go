@@ -0,0 +1,57 @@
+package certificate_searcher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How far a search got, written when it stops so an interrupted run can be resumed
+type Checkpoint struct {
+	// Records of Path up to and including Line have been processed
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	// Whether the search ran through its whole input
+	Completed bool        `json:"completed"`
+	Updated   time.Time   `json:"updated"`
+	Stats     SearchStats `json:"stats"`
+}
+
+// Writes the checkpoint to a temporary file and renames it over path, so path is never half written
+func WriteCheckpoint(path string, checkpoint *Checkpoint) error {
+	checkpointJSON, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(append(checkpointJSON, '\n')); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	checkpointJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(checkpointJSON, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
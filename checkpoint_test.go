@@ -0,0 +1,66 @@
+package certificate_searcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "run.checkpoint")
+	checkpoints := []*Checkpoint{
+		{Path: "/data/ct/2020-05-01.csv", Line: 1234, Updated: time.Date(2020, 5, 26, 12, 0, 0, 0, time.UTC)},
+		{Path: "/data/ct/2020-05-02.csv", Line: 99, Completed: true, Updated: time.Date(2020, 5, 27, 8, 30, 0, 0, time.UTC),
+			Stats: SearchStats{Records: 100, Labeled: 3, Written: 2, Errors: 1}},
+	}
+
+	// each write replaces the previous checkpoint whole
+	for _, checkpoint := range checkpoints {
+		if err := WriteCheckpoint(path, checkpoint); err != nil {
+			t.Fatalf("WriteCheckpoint: %s", err)
+		}
+		loaded, err := LoadCheckpoint(path)
+		if err != nil {
+			t.Fatalf("LoadCheckpoint: %s", err)
+		}
+		if !reflect.DeepEqual(loaded, checkpoint) {
+			t.Errorf("LoadCheckpoint = %+v, want %+v", loaded, checkpoint)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in checkpoint directory, want only the checkpoint", len(entries))
+	}
+}
+
+func TestLoadCheckpointErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	garbled := filepath.Join(dir, "garbled")
+	if err := ioutil.WriteFile(garbled, []byte("{\"path\": "), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing"), garbled} {
+		if _, err := LoadCheckpoint(path); err == nil {
+			t.Errorf("LoadCheckpoint(%s) succeeded, want an error", path)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"github.com/teamnsrg/certificate-searcher/cmd/internal/cli"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+)
+
+var log *zap.SugaredLogger
+
+// Command line flags
+var (
+	outputFilepath      = flag.String("o", "mutations.idx", "Output file for the mutation index")
+	configFilepath      = flag.String("config", "", "YAML or .json file selecting labelers and their parameters; explicit flags override it")
+	domainFilepath      = flag.String("domains", "", ".txt file with base domain names for name-similarity labeling")
+	keyboardLayoutNames = flag.String("keyboard-layouts", "qwerty", "Comma-separated keyboard layouts for typo generation (qwerty, qwertz, azerty, dvorak, phone)")
+	pslFilepath         = flag.String("psl-file", "", "Public Suffix List file to use instead of the embedded snapshot")
+	logFlags            = cli.RegisterLogFlags()
+	usage               = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: %s <flags>\n", os.Args[0], os.Args[0])
+		fmt.Fprint(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+)
+
+func applyFlagOverrides(config *cs.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "domains":
+			config.BaseDomainFile = *domainFilepath
+		case "keyboard-layouts":
+			config.Labelers.TypoSquatting.KeyboardLayouts = make([]string, 0)
+			for _, name := range strings.Split(*keyboardLayoutNames, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					config.Labelers.TypoSquatting.KeyboardLayouts = append(config.Labelers.TypoSquatting.KeyboardLayouts, name)
+				}
+			}
+		case "psl-file":
+			config.PSLFile = *pslFilepath
+		}
+	})
+}
+
+/*
+Generates the mutations of the typosquatting, homograph and bitsquatting labelers once
+and writes them to an index file, which certificate-searcher and domain-mutator map with -index
+instead of regenerating them on every start.
+*/
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	logger, err := logFlags.NewLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up logging: %s\n", err.Error())
+		os.Exit(1)
+	}
+	log = logger
+
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	config, err := cli.LoadConfig(*configFilepath, applyFlagOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	baseDomains, err := cli.LoadBaseDomains(log, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cli.LoadPSL(log, config); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("generating mutations")
+	_, mutationIndex, err := config.BuildMutationLabelers(&baseDomains, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("built %.1f MiB mutation index, holding at most %.1f MiB while building",
+		float64(mutationIndex.SizeBytes())/(1<<20), float64(mutationIndex.PeakBuildBytes)/(1<<20))
+
+	inputs := config.MutationIndexInputs(baseDomains)
+	if err := cs.WriteMutationIndexFile(*outputFilepath, mutationIndex, inputs); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("wrote %d mutations and %d postings for %d base domains to %s (base domains sha256 %s)",
+		mutationIndex.Len(), mutationIndex.PostingCount(), len(mutationIndex.BaseDomains), *outputFilepath, inputs.BaseDomainsSHA256)
+}
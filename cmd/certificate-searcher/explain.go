@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"golang.org/x/net/idna"
+	"os"
+	"strings"
+)
+
+// Hostnames as labelers see them in certificates: lower case, IDNs in punycode
+func normalizeHostname(hostname string) string {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if asciiHostname, err := idna.ToASCII(hostname); err == nil {
+		return asciiHostname
+	}
+	return hostname
+}
+
+/*
+Runs every configured labeler on each hostname and prints its matches with the operations that
+produce the name from the matched base domain, including matches the allowlist suppresses.
+*/
+func explainHostnames(hostnames []string) error {
+	if err := loadConfiguration(); err != nil {
+		return err
+	}
+	domainLabelers, mutationIndex, err := buildDomainLabelers()
+	if err != nil {
+		return err
+	}
+	defer mutationIndex.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+
+	for _, hostname := range hostnames {
+		name := normalizeHostname(hostname)
+		if unicodeName, isIDN := cs.UnicodeHostname(name); isIDN {
+			fmt.Fprintf(w, "%s (%s)\n", name, unicodeName)
+		} else {
+			fmt.Fprintf(w, "%s\n", name)
+		}
+
+		explanations := cs.Explain(name, domainLabelers, allowlist)
+		if len(explanations) == 0 {
+			fmt.Fprintf(w, "  no matches\n")
+		}
+
+		for _, explanation := range explanations {
+			fmt.Fprintf(w, "  %s", explanation.Label.String())
+			if explanation.Source != "" {
+				fmt.Fprintf(w, " %s", explanation.Source)
+			}
+			if explanation.SuppressedBy != "" {
+				fmt.Fprintf(w, " [suppressed by allowlist of %s]", explanation.SuppressedBy)
+			}
+			fmt.Fprintf(w, "\n")
+
+			for _, op := range explanation.Operations {
+				fmt.Fprintf(w, "    %s\n", op.String())
+			}
+
+			if detail := explanation.Detail; detail != nil {
+				if detail.Reason != "" {
+					fmt.Fprintf(w, "    %s: %s (skeleton %s)\n", detail.Reason, detail.ConfusableLabel, detail.Skeleton)
+				}
+				if len(detail.KeyboardLayouts) > 0 {
+					fmt.Fprintf(w, "    keyboard layouts: %s\n", strings.Join(detail.KeyboardLayouts, ", "))
+				}
+				if detail.EditDistance > 0 {
+					fmt.Fprintf(w, "    edit distance: %d\n", detail.EditDistance)
+				}
+				if detail.Likelihood > 0 {
+					fmt.Fprintf(w, "    likelihood: %.4g\n", detail.Likelihood)
+				}
+			}
+		}
+	}
+
+	return w.Flush()
+}
@@ -2,37 +2,28 @@ package main
 
 import (
 	"bufio"
-	"encoding/base64"
-	"encoding/csv"
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/pkg/profile"
 	cs "github.com/teamnsrg/certificate-searcher"
-	"github.com/teamnsrg/zcrypto/x509"
+	"github.com/teamnsrg/certificate-searcher/cmd/internal/cli"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 var log *zap.SugaredLogger
 
-func initLogger() {
-	atom := zap.NewAtomicLevelAt(zap.InfoLevel)
-	logger := zap.New(zapcore.NewCore(
-		zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
-		zapcore.Lock(os.Stdout),
-		atom), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
-	defer logger.Sync()
-	log = logger.Sugar()
-}
-
 func pathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -45,14 +36,13 @@ func pathExists(path string) (bool, error) {
 	return true, err
 }
 
-func verifyPathExists(path string) {
-	if ok, err := pathExists(path); err != nil || !ok {
-		log.Errorf("Invalid input file/directory: %s\n", path)
-		if err != nil {
-			log.Errorf("%s\n", err.Error())
-		}
-		os.Exit(1)
+func verifyPathExists(path string) error {
+	if ok, err := pathExists(path); err != nil {
+		return fmt.Errorf("invalid input file/directory %s: %s", path, err.Error())
+	} else if !ok {
+		return fmt.Errorf("invalid input file/directory %s", path)
 	}
+	return nil
 }
 
 func isDirectory(path string) (bool, error) {
@@ -96,219 +86,107 @@ func getFilesForPath(path string, startAt string) (filepaths []string, err error
 	return
 }
 
-func readCSVFiles(filepaths []string, dataRows chan []string, wg *sync.WaitGroup) {
-	for _, filepath := range filepaths {
-		log.Infof("reading file %s", filepath)
-		f, err := os.Open(filepath)
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-
-		reader := csv.NewReader(f)
-
-		var record []string
-		for record, err = reader.Read(); err == nil; record, err = reader.Read() {
-			sliceCopy := make([]string, len(record))
-			copy(sliceCopy, record)
-			dataRows <- sliceCopy
-		}
-		if err != io.EOF {
-			log.Error(err)
-		}
-
-		f.Close()
-	}
-	wg.Done()
+// Collects the CertInfos of a stats-only search, writing each new certificate's validity start as it arrives
+type statsSink struct {
+	certStats         *cs.CertStats
+	startValidityFile *os.File
+	dateWriter        *bufio.Writer
+	mux               sync.Mutex
 }
 
-func decodeAndParseChain(encodedCertChain []string, parser *x509.CertParser, onlyParseName bool) ([]*x509.Certificate, error) {
-	certChain := make([]*x509.Certificate, 0)
-	for _, encodedCert := range encodedCertChain {
-		certBytes, err := base64.StdEncoding.DecodeString(encodedCert)
-		if err != nil {
-			return nil, err
-		}
-
-		var cert *x509.Certificate
-		if onlyParseName {
-			cert, err = cs.ParseCertificateNamesOnly(certBytes)
-		} else {
-			cert, err = parser.ParseCertificate(certBytes)
-		}
-
-		if err != nil {
-			log.Errorf("Unable to parse certificate %s due to %s", encodedCert, err)
-			return nil, err
-		}
-		certChain = append(certChain, cert)
-	}
-
-	return certChain, nil
-}
+// A resumed sink appends to the validity start dates of the interrupted run
+func newStatsSink(startValidityFilename string, fingerprints cs.DedupStore, resumed bool) (*statsSink, error) {
+	sink := &statsSink{certStats: cs.NewCertStats(fingerprints)}
 
-func extractFeaturesToJSON(chain []*x509.Certificate, labels map[string]cs.LabelsSources) (*cs.LabeledCertChain, error) {
-	var leaf, leafParent *x509.Certificate
-	if len(chain) == 0 {
-		return nil, errors.New("Empty chain")
+	var err error
+	if startValidityFilename == "-" {
+		sink.startValidityFile = os.Stdout
+	} else if len(startValidityFilename) > 0 && resumed {
+		sink.startValidityFile, err = os.OpenFile(startValidityFilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	} else if len(startValidityFilename) > 0 {
+		sink.startValidityFile, err = os.Create(startValidityFilename)
 	}
-
-	leaf = chain[0]
-	if len(chain) > 1 {
-		leafParent = chain[1]
+	if err != nil {
+		return nil, err
 	}
-
-	certChain := &cs.LabeledCertChain{
-		AbuseDomains: labels,
-		Leaf:         leaf,
-		LeafParent:   leafParent,
-		Root:         chain[len(chain)-1],
-		ChainDepth:   len(chain),
+	if sink.startValidityFile != nil {
+		sink.dateWriter = bufio.NewWriter(sink.startValidityFile)
 	}
 
-	return certChain, nil
+	return sink, nil
 }
 
-func prettyParseCertificate(encodedCertChain []string, parser *x509.CertParser, labels map[string]cs.LabelsSources) string {
-	certChain, err := decodeAndParseChain(encodedCertChain, parser, false)
-	processedChain, err := extractFeaturesToJSON(certChain, labels)
-	if err != nil {
-		log.Error(err)
-		return ""
+func (s *statsSink) Write(result *cs.SearchResult) error {
+	certInfo := result.Info
+	if certInfo == nil {
+		return nil
 	}
 
-	jsonBytes, err := json.Marshal(processedChain)
-	if err != nil {
-		log.Error(err)
-		return ""
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	added, err := s.certStats.AddParentChild(certInfo.ParentSPKISubject, certInfo.TBSNoCTFingerprint)
+	if added && s.dateWriter != nil {
+		s.dateWriter.WriteString(fmt.Sprintf("%d,%s\n", certInfo.ValidityStart.Unix(), certInfo.ValidationLevel))
 	}
-
-	return string(jsonBytes)
+	return err
 }
 
-func processCertificates(dataRows chan []string, outputStrings chan string, certInfos chan *cs.CertInfo, labelers []cs.DomainLabeler, onlyParseNames bool, statsOnly bool, wg *sync.WaitGroup) {
-	const CERT_INDEX int = 2
-	const CHAIN_INDEX int = 4
-	const CHAIN_DELIMETER string = "|"
-
-	parser := x509.NewCertParser()
-	labelers = append(labelers, cs.NewTargetEmbeddingLabeler(&baseDomains))
-
-	for row := range dataRows {
-		certB64 := row[CERT_INDEX]
-		chainB64 := strings.Split(strings.TrimSpace(row[CHAIN_INDEX]), CHAIN_DELIMETER)
-
-		if row[CHAIN_INDEX] == "" {
-			chainB64 = []string{certB64}
-		} else if chainB64[0] != certB64 {
-			chainB64 = append([]string{certB64}, chainB64...)
-		}
-
-		certChain, err := decodeAndParseChain(chainB64, parser, onlyParseNames)
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-
-		leafCert := certChain[0]
-
-		if statsOnly {
-			if len(certChain) >= 2 {
-				parentCert := certChain[1]
-				certInfos <- cs.NewCertInfo(leafCert.ValidationLevel.String(), leafCert.NotBefore, leafCert.FingerprintNoCT, parentCert.SPKISubjectFingerprint)
-			} else {
-				certInfos <- cs.NewCertInfo(leafCert.ValidationLevel.String(), leafCert.NotBefore, leafCert.FingerprintNoCT, []byte("No parent"))
-			}
+// Flushes the validity start dates, saves the dedup store and writes the collected statistics to
+// statsFilename and, if set, a mergeable snapshot of them to snapshotFilename. Every step is
+// attempted; the first failure is returned and the others are logged.
+func (s *statsSink) Close(statsFilename string, snapshotFilename string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var closeErr error
+	fail := func(err error) {
+		if closeErr == nil {
+			closeErr = err
 		} else {
-			maldomainLabels := make(map[string]cs.LabelsSources)
-			for _, name := range append([]string{leafCert.Subject.CommonName}, leafCert.DNSNames...) {
-
-				for _, labeler := range labelers {
-					labels := labeler.LabelDomain(name)
-					if len(labels) > 0 {
-						if _, present := maldomainLabels[name]; !present {
-							maldomainLabels[name] = make(cs.LabelsSources)
-						}
-
-						for label, originDomains := range labels {
-							maldomainLabels[name][label] = originDomains
-						}
-					}
-				}
-			}
-			if len(maldomainLabels) > 0 {
-				outputStrings <- prettyParseCertificate(chainB64, parser, maldomainLabels)
-			}
+			log.Error(err)
 		}
 	}
 
-	wg.Done()
-}
-
-func writeOutput(outputStrings chan string, outputFilename string, wg *sync.WaitGroup) {
-	var outputFile *os.File
-	var err error
-
-	if outputFilename == "-" {
-		outputFile = os.Stdout
-	} else if len(outputFilename) > 0 {
-		outputFile, err = os.Create(outputFilename)
-		if err != nil {
-			log.Fatal(err)
+	if s.dateWriter != nil {
+		if err := s.dateWriter.Flush(); err != nil {
+			fail(fmt.Errorf("unable to write validity start dates: %s", err.Error()))
 		}
-	}
-
-	w := bufio.NewWriterSize(outputFile, 4096*1000)
-
-	for output := range outputStrings {
-		w.WriteString(output + "\n")
-	}
-	w.Flush()
-
-	outputFile.Close()
-	wg.Done()
-}
-
-func collectStatistics(certInfos chan *cs.CertInfo, statsFilename string, startValidityFilename string, wg *sync.WaitGroup) {
-	var statsFile, startValidityFile *os.File
-	var err error
-
-	if startValidityFilename == "-" {
-		startValidityFile = os.Stdout
-	} else if len(startValidityFilename) > 0 {
-		startValidityFile, err = os.Create(startValidityFilename)
-		if err != nil {
-			log.Fatal(err)
+		// stdout stays open for the rest of the run
+		if s.startValidityFile != os.Stdout {
+			if err := s.startValidityFile.Close(); err != nil {
+				fail(err)
+			}
 		}
 	}
-
-	dateWriter := bufio.NewWriter(startValidityFile)
-
-	certStats := cs.NewCertStats()
-
-	for certInfo := range certInfos {
-		if added := certStats.AddParentChild(certInfo.ParentSPKISubject, certInfo.TBSNoCTFingerprint); added {
-			dateWriter.WriteString(fmt.Sprintf("%d,%s\n", certInfo.ValidityStart.Unix(), certInfo.ValidationLevel))
+	if err := s.certStats.NoCTFingerprints.Close(); err != nil {
+		fail(fmt.Errorf("unable to save dedup store: %s", err.Error()))
+	}
+	if snapshotFilename != "" {
+		if err := cs.WriteStatsSnapshot(snapshotFilename, s.certStats.Snapshot()); err != nil {
+			fail(fmt.Errorf("unable to write stats snapshot %s: %s", snapshotFilename, err.Error()))
 		}
 	}
 
-	dateWriter.Flush()
-	startValidityFile.Close()
-
 	if statsFilename == "-" {
-		statsFile = os.Stdout
+		if _, err := os.Stdout.WriteString(s.certStats.String()); err != nil {
+			fail(err)
+		}
 	} else if len(statsFilename) > 0 {
-		statsFile, err = os.Create(statsFilename)
-		if err != nil {
-			log.Fatal(err)
+		if err := ioutil.WriteFile(statsFilename, []byte(s.certStats.String()), 0644); err != nil {
+			fail(err)
 		}
 	}
 
-	w := bufio.NewWriter(statsFile)
-	w.WriteString(certStats.String())
-	w.Flush()
-	statsFile.Close()
-	wg.Done()
+	return closeErr
+}
+
+// Warns once a bloom dedup store nears the certificates it holds at the -dedup-fp-rate
+func warnBloomCapacity(bloom *cs.BloomDedupStore) {
+	capacity := bloom.Capacity(*dedupFPRate)
+	if float64(bloom.Len()) >= 0.9*float64(capacity) {
+		log.Warnf("bloom dedup store holds %d certificates, nearly the %d it can hold at a false-positive rate of %g (now %.2g); start a larger one with -dedup-capacity",
+			bloom.Len(), capacity, *dedupFPRate, bloom.FalsePositiveRate())
+	}
 }
 
 // Command line flags
@@ -322,24 +200,164 @@ var (
 	cpuProfile            = flag.Bool("cpu-profile", false, "Run cpu profiling")
 	namesOnly             = flag.Bool("names-only", false, "only parse names from cert (faster)")
 	domainFilepath        = flag.String("domains", "", ".txt file with base domain names for name-similarity labeling")
+	maxEditDistance       = flag.Int("max-edit-distance", 2, "Maximum Damerau-Levenshtein distance for edit-distance labeling")
+	keyboardLayoutNames   = flag.String("keyboard-layouts", "qwerty", "Comma-separated keyboard layouts for typo generation (qwerty, qwertz, azerty, dvorak, phone)")
+	pslFilepath           = flag.String("psl-file", "", "Public Suffix List file to use instead of the embedded snapshot")
+	wrongTLDPrivate       = flag.Bool("wrongtld-private", false, "Also swap in PSL private-domain suffixes (github.io) for wrong-TLD labeling")
+	allowlistFilepath     = flag.String("allowlist", "", ".json file with brand-owned domains and certificate orgs whose matches are suppressed")
+	configFilepath        = flag.String("config", "", "YAML or .json file selecting labelers and their parameters; explicit flags override it")
+	minScore              = flag.Float64("min-score", 0, "Only output certificates with a risk score of at least this (0-100)")
+	allowlistReport       = flag.String("allowlist-report", "", "CSV file to write suppressed match counts per brand and label to")
+	indexFilepath         = flag.String("index", "", "Mutation index file from build-index, used instead of generating mutations when its inputs still match")
+	checkpointFilepath    = flag.String("checkpoint", "", "File to record how far the run got when it finishes or is interrupted")
+	resume                = flag.Bool("resume", false, "Continue after the position in -checkpoint, appending to -o and -quarantine; stats mode also needs -dedup-file and -stats-snapshot")
+	statsSnapshotFilepath = flag.String("stats-snapshot", "", "File to write mergeable statistics to in stats mode, for combining shards with stats-merge")
+	dedupStoreKind        = flag.String("dedup-store", cs.BLOOM_DEDUP_STORE, "How stats mode remembers counted certificates: bloom (approximate, in memory) or bolt (exact, on disk)")
+	dedupFilepath         = flag.String("dedup-file", "", "File the dedup store is loaded from and saved to, so later runs only count certificates not seen before")
+	dedupCapacity         = flag.Uint64("dedup-capacity", cs.DefaultDedupCapacity, "Certificates a new bloom dedup store is sized for")
+	dedupFPRate           = flag.Float64("dedup-fp-rate", cs.DefaultDedupFalsePositiveRate, "False-positive rate of a new bloom dedup store at capacity")
+	progressInterval      = flag.Duration("progress-interval", time.Minute, "How often to log progress, 0 to disable")
+	metricsAddr           = flag.String("metrics-addr", "", "Address such as localhost:9100 to serve Prometheus metrics on at /metrics")
+	quarantineFilepath    = flag.String("quarantine", "", "JSON lines file to write rows that cannot be parsed to, with their location and error category")
+	logFlags              = cli.RegisterLogFlags()
 	usage                 = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s: %s <flags> <input-file-or-dir>\n", os.Args[0], os.Args[0])
-		fmt.Print("Flags:\n")
+		fmt.Fprintf(os.Stderr, "       %s explain <flags> <hostname>...\n", os.Args[0])
+		fmt.Fprint(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
 )
 
+var config *cs.Config
 var baseDomains []string
+var allowlist *cs.Allowlist
+
+// Flags given explicitly on the command line take precedence over the config file
+func applyFlagOverrides(config *cs.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "domains":
+			config.BaseDomainFile = *domainFilepath
+		case "max-edit-distance":
+			config.Labelers.EditDistance.MaxDistance = *maxEditDistance
+		case "keyboard-layouts":
+			config.Labelers.TypoSquatting.KeyboardLayouts = make([]string, 0)
+			for _, name := range strings.Split(*keyboardLayoutNames, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					config.Labelers.TypoSquatting.KeyboardLayouts = append(config.Labelers.TypoSquatting.KeyboardLayouts, name)
+				}
+			}
+		case "psl-file":
+			config.PSLFile = *pslFilepath
+		case "wrongtld-private":
+			config.Labelers.WrongTLD.IncludePrivate = *wrongTLDPrivate
+		case "allowlist":
+			config.AllowlistFile = *allowlistFilepath
+		case "min-score":
+			config.MinScore = *minScore
+		}
+	})
+}
+
+// Loads the configuration, base domains, PSL and allowlist into the globals
+func loadConfiguration() error {
+	var err error
+	if config, err = cli.LoadConfig(*configFilepath, applyFlagOverrides); err != nil {
+		return err
+	}
+	log.Infof("effective configuration:\n%s", config.String())
+
+	if baseDomains, err = cli.LoadBaseDomains(log, config); err != nil {
+		return err
+	}
+	if err = cli.LoadPSL(log, config); err != nil {
+		return err
+	}
+	allowlist, err = cli.LoadAllowlist(log, config)
+	return err
+}
+
+// Builds the configured domain labelers, over the mapped -index file when it is still valid
+func buildDomainLabelers() ([]cs.DomainLabeler, *cs.MutationIndex, error) {
+	log.Info("building domain labelers")
+
+	loadedIndex := cli.OpenMutationIndex(log, config, *indexFilepath, baseDomains)
+	domainLabelers, mutationIndex, err := config.BuildLabelers(&baseDomains, loadedIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	cli.LogMutationIndex(log, mutationIndex)
+
+	return domainLabelers, mutationIndex, nil
+}
+
+// Returned by run when a signal stopped the search, after the output and checkpoint are written
+type interruptedError struct {
+	sig os.Signal
+}
+
+func (e *interruptedError) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.sig)
+}
+
+// Returned by run when the search or writing its output failed, after the failure was logged
+var errRunFailed = errors.New("search did not complete")
+
+// Exit status of a run stopped by a signal, following the shell convention
+func interruptedStatus(sig os.Signal) int {
+	if signum, ok := sig.(syscall.Signal); ok {
+		return 128 + int(signum)
+	}
+	return 1
+}
 
 func main() {
-	initLogger()
+	err := run()
+	if err == nil {
+		return
+	}
+
+	var interrupted *interruptedError
+	if errors.As(err, &interrupted) {
+		os.Exit(interruptedStatus(interrupted.sig))
+	}
+	if log != nil {
+		log.Error(err)
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(1)
+}
 
+// Searches the input and returns only once output, quarantine and checkpoint are written, so
+// deferred cleanup runs on every path
+func run() error {
 	flag.Usage = usage
+
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		flag.CommandLine.Parse(os.Args[2:])
+		logger, err := logFlags.NewLogger()
+		if err != nil {
+			return fmt.Errorf("unable to set up logging: %s", err.Error())
+		}
+		log = logger
+		if flag.NArg() == 0 {
+			flag.Usage()
+			return errors.New("explain needs at least one hostname")
+		}
+		return explainHostnames(flag.Args())
+	}
+
 	flag.Parse()
+	logger, err := logFlags.NewLogger()
+	if err != nil {
+		return fmt.Errorf("unable to set up logging: %s", err.Error())
+	}
+	log = logger
 
 	if flag.NArg() != 1 {
 		flag.Usage()
-		os.Exit(1)
+		return errors.New("expected one input file or directory")
 	}
 
 	if *cpuProfile {
@@ -349,96 +367,283 @@ func main() {
 		defer profile.Start(profile.MemProfile, profile.ProfilePath("."), profile.NoShutdownHook).Stop()
 	}
 
-	defaultDomains := []string{
-		"google.com",
-		"youtube.com",
-		"tmall.com",
-		"facebook.com",
-		"baidu.com",
-		"apple.com",
+	var checkpoint *cs.Checkpoint
+	if *resume {
+		if *checkpointFilepath == "" {
+			return errors.New("-resume needs -checkpoint")
+		}
+		if checkpoint, err = cs.LoadCheckpoint(*checkpointFilepath); err != nil {
+			return fmt.Errorf("unable to load checkpoint %s: %s", *checkpointFilepath, err.Error())
+		}
+		if checkpoint.Completed {
+			log.Infof("checkpoint %s records a completed run, nothing to resume", *checkpointFilepath)
+			return nil
+		}
+		log.Infof("resuming after %s:%d", checkpoint.Path, checkpoint.Line)
 	}
 
-	if *domainFilepath == "" {
-		log.Infof("No base domain file specified, using default list of %d domains", len(defaultDomains))
-		baseDomains = defaultDomains
-	} else {
-		f, err := os.Open(*domainFilepath)
+	if err := loadConfiguration(); err != nil {
+		return err
+	}
+
+	statsOnly := *statsFilepath != "" || *statsSnapshotFilepath != ""
+	// the statistics file is rewritten in full at the end, so a resumed stats run has to start from
+	// the counts and dedup store the interrupted run saved
+	if checkpoint != nil && statsOnly {
+		if *dedupFilepath == "" {
+			return errors.New("-resume in stats mode needs the -dedup-file of the interrupted run")
+		}
+		if *statsSnapshotFilepath == "" {
+			return errors.New("-resume in stats mode needs the -stats-snapshot of the interrupted run")
+		}
+	}
+
+	inputPath := flag.Arg(0)
+	if err := verifyPathExists(inputPath); err != nil {
+		return err
+	}
+
+	startAtFile := *startAt
+	if checkpoint != nil {
+		startAtFile = filepath.Base(checkpoint.Path)
+	}
+	filepaths, err := getFilesForPath(inputPath, startAtFile)
+	if err != nil {
+		return fmt.Errorf("unable to get files for path %s: %s", inputPath, err.Error())
+	}
+
+	domainLabelers, mutationIndex, err := buildDomainLabelers()
+	if err != nil {
+		return err
+	}
+	defer mutationIndex.Close()
+	source := cs.NewCSVFileSource(filepaths)
+	if checkpoint != nil && len(filepaths) > 0 && filepaths[0] == checkpoint.Path {
+		source.StartLine = checkpoint.Line
+	}
+	source.OnOpen = func(path string) {
+		log.Infof("reading file %s", path)
+	}
+	source.OnError = func(path string, err error) {
+		log.Errorf("%s: %s", path, err.Error())
+	}
+
+	// failures are always counted per category, and written out with -quarantine
+	var quarantineFile *os.File
+	if *quarantineFilepath != "" {
+		if checkpoint != nil {
+			quarantineFile, err = os.OpenFile(*quarantineFilepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		} else {
+			quarantineFile, err = os.Create(*quarantineFilepath)
+		}
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("unable to open quarantine file %s: %s", *quarantineFilepath, err.Error())
 		}
-		defer f.Close()
+	}
+	var quarantine *cs.QuarantineSink
+	if quarantineFile != nil {
+		quarantine = cs.NewQuarantineSink(quarantineFile)
+	} else {
+		quarantine = cs.NewQuarantineSink(nil)
+	}
 
-		baseDomains = make([]string, 0)
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			rawDomain := strings.TrimSpace(scanner.Text())
-			sanitizedDomain := strings.ToLower(rawDomain)
-			baseDomains = append(baseDomains, sanitizedDomain)
+	// everything that can fail is opened before the search starts, so once it has started the
+	// output, quarantine and checkpoint are always written
+	sink, closeSink, err := openSink(checkpoint, statsOnly)
+	if err != nil {
+		quarantine.Close()
+		return err
+	}
 
-			if rawDomain != sanitizedDomain {
-				log.Warnf("domain %s was sanitized to %s", rawDomain, sanitizedDomain)
+	progress := newProgressReporter(source)
+	searcher := cs.NewSearcher(cs.SearcherOptions{
+		Labelers:            domainLabelers,
+		CertificateLabelers: config.BuildCertificateLabelers(),
+		Allowlist:           allowlist,
+		RiskScorer:          cs.NewRiskScorer(&baseDomains),
+		MinScore:            config.MinScore,
+		Workers:             *workerCount,
+		NamesOnly:           *namesOnly,
+		StatsOnly:           statsOnly,
+		OnResult:            progress.observeResult,
+		OnStage:             progress.observeStage,
+		OnLabeler:           progress.observeLabeler,
+		OnError: func(record *cs.CertificateRecord, err error) {
+			progress.observeError(err)
+			// quarantined rows are only summarized, anything else is still logged
+			if quarantineFile == nil || cs.CategorizeError(err) == cs.OTHER_ERROR {
+				log.Errorf("%s:%d: %s", record.Origin, record.Line, err.Error())
 			}
+		},
+		ErrorSink: quarantine,
+	})
+	progress.searcher = searcher
+	if *metricsAddr != "" {
+		progress.serveMetrics(*metricsAddr)
+	}
+	defer progress.close()
+
+	// the first SIGINT/SIGTERM stops reading and lets the run wind down, a second one kills it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
 		}
+		signal.Stop(signals)
+		log.Warnf("received %s, finishing in-flight certificates and flushing output", sig)
+		interrupted <- sig
+		cancel()
+	}()
+	if *progressInterval > 0 {
+		go progress.logEvery(ctx, *progressInterval)
 	}
 
-	statsOnly := *statsFilepath != ""
+	runErr := searcher.Run(ctx, source, sink)
+	signal.Stop(signals)
+	close(signals)
 
-	inputPath := flag.Arg(0)
-	verifyPathExists(inputPath)
+	var sig os.Signal
+	select {
+	case sig = <-interrupted:
+	default:
+	}
+	if runErr != nil && sig == nil {
+		log.Error(runErr)
+	}
+	// output that could not be written leaves the run incomplete
+	if err := closeSink(); err != nil {
+		log.Error(err)
+		if runErr == nil {
+			runErr = err
+		}
+	}
 
-	filepaths, err := getFilesForPath(inputPath, *startAt)
-	if err != nil {
-		log.Fatalf("Unable to get files for path %s", inputPath)
+	searchStats := searcher.Stats()
+	if sig != nil {
+		log.Warnf("interrupted: searched %d certificates: %d labeled, %d written, %d errors; statistics are partial",
+			searchStats.Records, searchStats.Labeled, searchStats.Written, searchStats.Errors)
+	} else {
+		log.Infof("searched %d certificates: %d labeled, %d written, %d errors",
+			searchStats.Records, searchStats.Labeled, searchStats.Written, searchStats.Errors)
 	}
 
-	log.Info("building domain labelers")
+	if err := quarantine.Close(); err != nil {
+		log.Errorf("Unable to write quarantine file %s: %s", *quarantineFilepath, err.Error())
+	}
+	if searchStats.Errors > 0 {
+		log.Warnf("unprocessable records per error category (category,count):\n%s", quarantine.SummaryString())
+	}
+
+	if *checkpointFilepath != "" {
+		path, line := source.Position()
+		if path == "" && checkpoint != nil {
+			path, line = checkpoint.Path, checkpoint.Line
+		}
+		final := &cs.Checkpoint{
+			Path:      path,
+			Line:      line,
+			Completed: runErr == nil && sig == nil,
+			Updated:   time.Now().UTC(),
+			Stats:     searchStats,
+		}
+		if err := cs.WriteCheckpoint(*checkpointFilepath, final); err != nil {
+			log.Errorf("Unable to write checkpoint %s: %s", *checkpointFilepath, err.Error())
+		} else {
+			log.Infof("wrote checkpoint %s at %s:%d", *checkpointFilepath, final.Path, final.Line)
+		}
+	}
 
-	domainLabelers := []cs.DomainLabeler{
-		cs.NewTypoSquattingLabeler(&baseDomains),
-		//cs.NewTargetEmbeddingLabeler(&baseDomains), added in each goroutine
-		cs.NewHomoGraphLabeler(&baseDomains),
-		cs.NewBitSquattingLabeler(&baseDomains),
-		cs.NewWrongTLDLabeler(&baseDomains),
-		cs.NewPhishTankLabeler(),
-		cs.NewOpenPhishLabeler(),
-		cs.NewSafeBrowsingLabeler(),
-	}
-
-	dataRows := make(chan []string, 100)
-	readWG := &sync.WaitGroup{}
-	readWG.Add(1)
-	go readCSVFiles(filepaths, dataRows, readWG)
-
-	certInfos := make(chan *cs.CertInfo, 100)
-	outputStrings := make(chan string, 100)
-	workerWG := &sync.WaitGroup{}
-	for i := 0; i < *workerCount; i++ {
-		workerWG.Add(1)
-
-		if statsOnly {
-			go processCertificates(dataRows, outputStrings, certInfos, domainLabelers, *namesOnly, statsOnly, workerWG)
+	if allowlist != nil {
+		log.Infof("allowlist suppressed matches (brand,label,count):\n%s", allowlist.SuppressedCountsString())
+		if *allowlistReport != "" {
+			if err := ioutil.WriteFile(*allowlistReport, []byte(allowlist.SuppressedCountsString()), 0644); err != nil {
+				log.Errorf("Unable to write allowlist report to %s: %s", *allowlistReport, err.Error())
+			}
+		}
+	}
+
+	if sig != nil {
+		return &interruptedError{sig: sig}
+	}
+	if runErr != nil {
+		return errRunFailed
+	}
+	return nil
+}
+
+// Hides the Close of the writer it wraps, so closing a sink leaves stdout open
+type nopCloseWriter struct {
+	io.Writer
+}
+
+/*
+Opens the sink results go to: the statistics collector in stats mode, starting from the counts and
+dedup store of the interrupted run when resuming, otherwise JSON lines on -o. closeSink flushes and
+closes it, and in stats mode writes the statistics.
+*/
+func openSink(checkpoint *cs.Checkpoint, statsOnly bool) (sink cs.Sink, closeSink func() error, err error) {
+	if !statsOnly {
+		var output io.Writer
+		if *outputFilepath == "-" {
+			output = nopCloseWriter{os.Stdout}
+		} else if checkpoint != nil {
+			// a resumed run adds to the output of the interrupted one
+			output, err = os.OpenFile(*outputFilepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		} else {
-			go processCertificates(dataRows, outputStrings, nil, domainLabelers, *namesOnly, statsOnly, workerWG)
+			output, err = os.Create(*outputFilepath)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		jsonSink := cs.NewJSONLinesSink(output)
+		return jsonSink, jsonSink.Close, nil
+	}
+
+	var snapshot *cs.StatsSnapshot
+	if checkpoint != nil {
+		if snapshot, err = cs.LoadStatsSnapshot(*statsSnapshotFilepath); err != nil {
+			return nil, nil, fmt.Errorf("unable to load stats snapshot of the interrupted run: %s", err.Error())
 		}
 	}
 
-	statsWG := &sync.WaitGroup{}
-	if statsOnly {
-		statsWG.Add(1)
-		go collectStatistics(certInfos, *statsFilepath, *startValidityFilepath, statsWG)
+	fingerprints, err := cs.OpenDedupStore(*dedupStoreKind, *dedupFilepath, *dedupCapacity, *dedupFPRate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open dedup store: %s", err.Error())
+	}
+	if *dedupFilepath != "" {
+		log.Infof("only counting certificates not already in %s dedup store %s", *dedupStoreKind, *dedupFilepath)
+	}
+	bloom, isBloom := fingerprints.(*cs.BloomDedupStore)
+	if isBloom && bloom.Loaded {
+		log.Infof("loaded bloom filter of %d bits and %d hash functions holding %d certificates, false-positive rate %.2g; -dedup-capacity and -dedup-fp-rate only size new filters",
+			bloom.Filter.M(), bloom.Filter.K(), bloom.Len(), bloom.FalsePositiveRate())
+	}
+	if isBloom {
+		warnBloomCapacity(bloom)
 	}
 
-	writeWG := &sync.WaitGroup{}
-	writeWG.Add(1)
-	go writeOutput(outputStrings, *outputFilepath, writeWG)
+	statsSink, err := newStatsSink(*startValidityFilepath, fingerprints, checkpoint != nil)
+	if err != nil {
+		fingerprints.Close()
+		return nil, nil, err
+	}
+	if snapshot != nil {
+		statsSink.certStats.Restore(snapshot)
+		log.Infof("continuing the counts of %d parents from %s", len(snapshot.Parents), *statsSnapshotFilepath)
+	}
 
-	readWG.Wait()
-	close(dataRows)
-	workerWG.Wait()
-	if statsOnly {
-		close(certInfos)
-		statsWG.Wait()
+	closeSink = func() error {
+		err := statsSink.Close(*statsFilepath, *statsSnapshotFilepath)
+		if isBloom {
+			warnBloomCapacity(bloom)
+		}
+		return err
 	}
-	close(outputStrings)
-	writeWG.Wait()
+	return statsSink, closeSink, nil
 }
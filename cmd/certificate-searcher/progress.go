@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Tracks how far a search has got, logging progress every interval and, when metricsAddr is set,
+serving counters and stage and labeler latency histograms on /metrics for Prometheus.
+*/
+type progressReporter struct {
+	searcher *cs.Searcher
+	source   *cs.CSVFileSource
+	start    time.Time
+
+	labelCounts map[string]uint64
+	mux         sync.Mutex
+
+	registry       *prometheus.Registry
+	server         *http.Server
+	stageSeconds   *prometheus.HistogramVec
+	labelerSeconds *prometheus.HistogramVec
+	labelMatches   *prometheus.CounterVec
+	recordErrors   *prometheus.CounterVec
+}
+
+func newProgressReporter(source *cs.CSVFileSource) *progressReporter {
+	p := &progressReporter{
+		source:      source,
+		start:       time.Now(),
+		labelCounts: make(map[string]uint64),
+		registry:    prometheus.NewRegistry(),
+		stageSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "certificate_searcher_stage_seconds",
+			Help:    "Time a certificate record spent in each pipeline stage.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 12),
+		}, []string{"stage"}),
+		labelerSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "certificate_searcher_labeler_seconds",
+			Help:    "Time a domain labeler took to label one name.",
+			Buckets: prometheus.ExponentialBuckets(1e-7, 4, 12),
+		}, []string{"labeler"}),
+		labelMatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "certificate_searcher_label_matches_total",
+			Help: "Certificates given each label.",
+		}, []string{"label"}),
+		recordErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "certificate_searcher_record_errors_total",
+			Help: "Records that could not be processed, by error category.",
+		}, []string{"category"}),
+	}
+	p.registry.MustRegister(p.stageSeconds, p.labelerSeconds, p.labelMatches, p.recordErrors)
+
+	searchStat := func(name, help string, value func(stats cs.SearchStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			if p.searcher == nil {
+				return 0
+			}
+			return float64(value(p.searcher.Stats()))
+		})
+	}
+	p.registry.MustRegister(
+		searchStat("certificate_searcher_records_total", "Certificate records searched.",
+			func(stats cs.SearchStats) uint64 { return stats.Records }),
+		searchStat("certificate_searcher_errors_total", "Records that could not be processed or written.",
+			func(stats cs.SearchStats) uint64 { return stats.Errors }),
+		searchStat("certificate_searcher_labeled_total", "Certificates given at least one label.",
+			func(stats cs.SearchStats) uint64 { return stats.Labeled }),
+		searchStat("certificate_searcher_written_total", "Results written to the output.",
+			func(stats cs.SearchStats) uint64 { return stats.Written }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "certificate_searcher_read_bytes_total",
+			Help: "Bytes read from input files.",
+		}, func() float64 { return float64(source.Progress().BytesRead) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "certificate_searcher_input_bytes",
+			Help: "Combined size of all input files.",
+		}, func() float64 { return float64(source.Progress().BytesTotal) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "certificate_searcher_files_done_total",
+			Help: "Input files read to the end.",
+		}, func() float64 { return float64(source.Progress().FilesDone) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "certificate_searcher_input_files",
+			Help: "Input files to read.",
+		}, func() float64 { return float64(source.Progress().FilesTotal) }),
+	)
+
+	return p
+}
+
+func (p *progressReporter) observeStage(stage cs.SearchStage, elapsed time.Duration) {
+	p.stageSeconds.WithLabelValues(string(stage)).Observe(elapsed.Seconds())
+}
+
+func (p *progressReporter) observeLabeler(labeler string, elapsed time.Duration) {
+	p.labelerSeconds.WithLabelValues(labeler).Observe(elapsed.Seconds())
+}
+
+func (p *progressReporter) observeError(err error) {
+	p.recordErrors.WithLabelValues(string(cs.CategorizeError(err))).Inc()
+}
+
+// Counts each label once per certificate
+func (p *progressReporter) observeResult(result *cs.SearchResult) {
+	if result.Labeled == nil {
+		return
+	}
+
+	labels := make(map[string]struct{})
+	for _, labelsSources := range result.Labeled.AbuseDomains {
+		for label := range labelsSources {
+			labels[label.String()] = struct{}{}
+		}
+	}
+	for label := range result.Labeled.CertificateLabels {
+		labels[label.String()] = struct{}{}
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for label := range labels {
+		p.labelCounts[label] += 1
+		p.labelMatches.WithLabelValues(label).Inc()
+	}
+}
+
+// Serves /metrics on addr until close is called
+func (p *progressReporter) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}))
+	p.server = &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Infof("serving metrics on http://%s/metrics", addr)
+		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Unable to serve metrics on %s: %s", addr, err.Error())
+		}
+	}()
+}
+
+// Logs progress every interval until ctx is done
+func (p *progressReporter) logEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastStats cs.SearchStats
+	var lastBytes uint64
+	last := p.start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats, sourceProgress := p.searcher.Stats(), p.source.Progress()
+			elapsed := now.Sub(last).Seconds()
+
+			eta := "unknown"
+			if rate := float64(sourceProgress.BytesRead) / now.Sub(p.start).Seconds(); rate > 0 && sourceProgress.BytesTotal >= sourceProgress.BytesRead {
+				remaining := time.Duration(float64(sourceProgress.BytesTotal-sourceProgress.BytesRead) / rate * float64(time.Second))
+				eta = remaining.Round(time.Second).String()
+			}
+
+			log.Infof("progress: files %d/%d, %d records (%.0f/s), %.1f MiB/s, ETA %s, %d errors, %d labeled, matches: %s",
+				sourceProgress.FilesDone, sourceProgress.FilesTotal,
+				stats.Records, float64(stats.Records-lastStats.Records)/elapsed,
+				float64(sourceProgress.BytesRead-lastBytes)/elapsed/(1<<20),
+				eta, stats.Errors, stats.Labeled, p.labelCountsString())
+
+			lastStats, lastBytes, last = stats, sourceProgress.BytesRead, now
+		}
+	}
+}
+
+// Label matches so far as LABEL=count, most frequent first
+func (p *progressReporter) labelCountsString() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if len(p.labelCounts) == 0 {
+		return "none"
+	}
+	labels := make([]string, 0, len(p.labelCounts))
+	for label := range p.labelCounts {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if p.labelCounts[labels[i]] != p.labelCounts[labels[j]] {
+			return p.labelCounts[labels[i]] > p.labelCounts[labels[j]]
+		}
+		return labels[i] < labels[j]
+	})
+
+	counts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		counts = append(counts, fmt.Sprintf("%s=%d", label, p.labelCounts[label]))
+	}
+	return strings.Join(counts, ", ")
+}
+
+func (p *progressReporter) close() {
+	if p.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.server.Shutdown(ctx)
+	}
+}
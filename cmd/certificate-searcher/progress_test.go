@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"strings"
+	"testing"
+	"time"
+)
+
+func labeledResult(domainLabels map[string]cs.LabelsSources, certLabels cs.LabelsSources) *cs.SearchResult {
+	return &cs.SearchResult{
+		Record:  &cs.CertificateRecord{},
+		Labeled: &cs.LabeledCertChain{AbuseDomains: domainLabels, CertificateLabels: certLabels},
+	}
+}
+
+// Value of the metric with the given label value, or of the unlabeled metric when labelValue is empty
+func metricValue(t *testing.T, p *progressReporter, name, labelValue string) float64 {
+	families, err := p.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if labelValue != "" && (len(metric.GetLabel()) == 0 || metric.GetLabel()[0].GetValue() != labelValue) {
+				continue
+			}
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			if metric.GetGauge() != nil {
+				return metric.GetGauge().GetValue()
+			}
+			return float64(metric.GetHistogram().GetSampleCount())
+		}
+	}
+	t.Fatalf("no metric %s{%s}", name, labelValue)
+	return 0
+}
+
+func TestProgressReporterLabelCounts(t *testing.T) {
+	p := newProgressReporter(&cs.CSVFileSource{})
+	if counts := p.labelCountsString(); counts != "none" {
+		t.Errorf("labelCountsString() = %s, want none", counts)
+	}
+
+	// both names carry TARGET_EMBEDDING, which counts once for the certificate
+	p.observeResult(labeledResult(map[string]cs.LabelsSources{
+		"google.com.evil.net": {cs.TARGET_EMBEDDING: {"google.com"}},
+		"paypal.com.evil.net": {cs.TARGET_EMBEDDING: {"paypal.com"}, cs.COMBOSQUATTING: {"paypal.com"}},
+	}, cs.LabelsSources{cs.SSL_BLACKLIST: {"fingerprint:"}}))
+	p.observeResult(labeledResult(map[string]cs.LabelsSources{
+		"gogle.com": {cs.TYPOSQUATTING_CHAR_OMISSION: {"google.com"}},
+	}, nil))
+	p.observeResult(labeledResult(map[string]cs.LabelsSources{
+		"secure-google.com": {cs.COMBOSQUATTING: {"google.com"}},
+	}, nil))
+	// unlabeled results, as with StatsOnly, are ignored
+	p.observeResult(&cs.SearchResult{Record: &cs.CertificateRecord{}})
+
+	want := "COMBOSQUATTING=2, SSL_BLACKLIST=1, TARGET_EMBEDDING=1, TYPOSQUATTING_CHAR_OMISSION=1"
+	if counts := p.labelCountsString(); counts != want {
+		t.Errorf("labelCountsString() = %s, want %s", counts, want)
+	}
+	if matches := metricValue(t, p, "certificate_searcher_label_matches_total", "COMBOSQUATTING"); matches != 2 {
+		t.Errorf("label matches for COMBOSQUATTING = %v, want 2", matches)
+	}
+}
+
+func TestProgressReporterMetrics(t *testing.T) {
+	source := &cs.CSVFileSource{Paths: []string{"a.csv", "b.csv"}}
+	p := newProgressReporter(source)
+
+	// search counters read 0 until the searcher is set
+	if records := metricValue(t, p, "certificate_searcher_records_total", ""); records != 0 {
+		t.Errorf("records before the searcher is set = %v, want 0", records)
+	}
+	p.searcher = cs.NewSearcher(cs.SearcherOptions{})
+
+	p.observeStage(cs.DECODE_STAGE, time.Millisecond)
+	p.observeStage(cs.DECODE_STAGE, time.Millisecond)
+	p.observeLabeler("TypoSquattingLabeler", time.Microsecond)
+	p.observeError(&cs.ChainError{Category: cs.TRUNCATED, Err: errors.New("empty certificate")})
+	p.observeError(errors.New("labeler failed"))
+
+	tests := []struct {
+		name       string
+		labelValue string
+		want       float64
+	}{
+		{"certificate_searcher_records_total", "", 0},
+		{"certificate_searcher_input_files", "", 2},
+		{"certificate_searcher_stage_seconds", string(cs.DECODE_STAGE), 2},
+		{"certificate_searcher_labeler_seconds", "TypoSquattingLabeler", 1},
+		{"certificate_searcher_record_errors_total", string(cs.TRUNCATED), 1},
+		{"certificate_searcher_record_errors_total", string(cs.OTHER_ERROR), 1},
+	}
+	for _, test := range tests {
+		if got := metricValue(t, p, test.name, test.labelValue); got != test.want {
+			t.Errorf("%s{%s} = %v, want %v", test.name, test.labelValue, got, test.want)
+		}
+	}
+}
+
+func TestProgressReporterLogEvery(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	defer func(previous *zap.SugaredLogger) { log = previous }(log)
+	log = zap.New(core).Sugar()
+
+	p := newProgressReporter(&cs.CSVFileSource{Paths: []string{"a.csv"}})
+	p.searcher = cs.NewSearcher(cs.SearcherOptions{})
+	p.observeResult(labeledResult(map[string]cs.LabelsSources{
+		"google.com.evil.net": {cs.TARGET_EMBEDDING: {"google.com"}},
+	}, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.logEvery(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if logs.Len() == 0 {
+		t.Fatal("logEvery logged no progress")
+	}
+	message := logs.All()[0].Message
+	for _, want := range []string{"files 0/1", "0 records", "ETA unknown", "0 labeled", "matches: TARGET_EMBEDDING=1"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("progress %q does not mention %q", message, want)
+		}
+	}
+}
@@ -6,9 +6,8 @@ import (
 	"fmt"
 	"github.com/pkg/profile"
 	cs "github.com/teamnsrg/certificate-searcher"
+	"github.com/teamnsrg/certificate-searcher/cmd/internal/cli"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-	"golang.org/x/net/publicsuffix"
 	"io/ioutil"
 	"os"
 	"sort"
@@ -17,16 +16,6 @@ import (
 
 var log *zap.SugaredLogger
 
-func initLogger() {
-	atom := zap.NewAtomicLevelAt(zap.InfoLevel)
-	logger := zap.New(zapcore.NewCore(
-		zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
-		zapcore.Lock(os.Stdout),
-		atom), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
-	defer logger.Sync()
-	log = logger.Sugar()
-}
-
 func pathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -63,68 +52,67 @@ func getDirectoryFiles(dirPath string) ([]string, error) {
 
 // Command line flags
 var (
-	outputFilepath = flag.String("o", "-", "Output file for mutated domains")
-	workerCount    = flag.Int("workers", 1, "Number of parallel WHOIS requests")
-	scanRate       = flag.Int("rate", 10, "Number of WHOIS requests per minute, per worker")
-	memProfile     = flag.Bool("mem-profile", false, "Run memory profiling")
-	cpuProfile     = flag.Bool("cpu-profile", false, "Run cpu profiling")
-	domainFilepath = flag.String("domains", "", ".txt file with base domain names for name-similarity labeling")
-	usage          = func() {
+	outputFilepath  = flag.String("o", "-", "Output file for mutated domains")
+	workerCount     = flag.Int("workers", 1, "Number of parallel WHOIS requests")
+	scanRate        = flag.Int("rate", 10, "Number of WHOIS requests per minute, per worker")
+	memProfile      = flag.Bool("mem-profile", false, "Run memory profiling")
+	cpuProfile      = flag.Bool("cpu-profile", false, "Run cpu profiling")
+	configFilepath  = flag.String("config", "", "YAML or .json file selecting labelers and their parameters; explicit flags override it")
+	domainFilepath  = flag.String("domains", "", ".txt file with base domain names for name-similarity labeling")
+	layoutNames     = flag.String("keyboard-layouts", "qwerty", "Comma-separated keyboard layouts for typo generation (qwerty, qwertz, azerty, dvorak, phone)")
+	pslFilepath     = flag.String("psl-file", "", "Public Suffix List file to use instead of the embedded snapshot")
+	wrongTLDPrivate = flag.Bool("wrongtld-private", false, "Also swap in PSL private-domain suffixes (github.io) for wrong-TLD labeling")
+	indexFilepath   = flag.String("index", "", "Mutation index file from build-index, used instead of generating mutations when its inputs still match")
+	logFlags        = cli.RegisterLogFlags()
+	usage           = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s: %s <flags>\n", os.Args[0], os.Args[0])
-		fmt.Print("Flags:\n")
+		fmt.Fprint(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
 )
 
-func main() {
-	initLogger()
+// Flags given explicitly on the command line take precedence over the config file
+func applyFlagOverrides(config *cs.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "domains":
+			config.BaseDomainFile = *domainFilepath
+		case "keyboard-layouts":
+			config.Labelers.TypoSquatting.KeyboardLayouts = make([]string, 0)
+			for _, name := range strings.Split(*layoutNames, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					config.Labelers.TypoSquatting.KeyboardLayouts = append(config.Labelers.TypoSquatting.KeyboardLayouts, name)
+				}
+			}
+		case "psl-file":
+			config.PSLFile = *pslFilepath
+		case "wrongtld-private":
+			config.Labelers.WrongTLD.IncludePrivate = *wrongTLDPrivate
+		}
+	})
+}
 
+func main() {
 	flag.Usage = usage
 	flag.Parse()
+	logger, err := logFlags.NewLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up logging: %s\n", err.Error())
+		os.Exit(1)
+	}
+	log = logger
 
 	if flag.NArg() > 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	var baseDomains []string
-	defaultDomains := []string{
-		"www.google.com",
-		"www.google.it",
-		"www.youtube.com",
-		"www.tmall.com",
-		"www.facebook.com",
-		"www.baidu.com",
-		"www.apple.com",
-	}
-
-	if *domainFilepath == "" {
-		log.Infof("No base domain file specified, using default list of %d domains", len(defaultDomains))
-		baseDomains = defaultDomains
-	} else {
-		f, err := os.Open(*domainFilepath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer f.Close()
-
-		baseDomains = make([]string, 0)
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			rawDomain := strings.TrimSpace(scanner.Text())
-			sanitizedDomain := strings.ToLower(rawDomain)
-			baseDomains = append(baseDomains, sanitizedDomain)
-
-			if rawDomain != sanitizedDomain {
-				log.Warnf("domain %s was sanitized to %s", rawDomain, sanitizedDomain)
-			}
-		}
-	}
-
-	baseDomainMap := make(map[string]struct{})
-	for _, domain := range baseDomains {
-		baseDomainMap[domain] = struct{}{}
+	config, err := cli.LoadConfig(*configFilepath, applyFlagOverrides)
+	if err != nil {
+		log.Fatal(err)
 	}
+	// the homograph labeler matches names without listing its mutations
+	config.Labelers.HomoGraph.Enabled = false
 
 	if *cpuProfile {
 		defer profile.Start(profile.CPUProfile, profile.ProfilePath(".")).Stop()
@@ -133,19 +121,33 @@ func main() {
 		defer profile.Start(profile.MemProfile, profile.ProfilePath("."), profile.NoShutdownHook).Stop()
 	}
 
+	baseDomains, err := cli.LoadBaseDomains(log, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cli.LoadPSL(log, config); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Info("building domain labelers")
+	loadedIndex := cli.OpenMutationIndex(log, config, *indexFilepath, baseDomains)
+	domainLabelers, mutationIndex, err := config.BuildMutationLabelers(&baseDomains, loadedIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mutationIndex.Close()
+	cli.LogMutationIndex(log, mutationIndex)
 
-	domainMutators := []cs.DomainMutator{
-		cs.NewTypoSquattingLabeler(&baseDomains),
-		cs.NewBitSquattingLabeler(&baseDomains),
-		cs.NewWrongTLDLabeler(&baseDomains),
+	domainMutators := make([]cs.DomainMutator, 0, len(domainLabelers))
+	for _, labeler := range domainLabelers {
+		domainMutators = append(domainMutators, labeler.(cs.DomainMutator))
 	}
 
 	allMutations := make(cs.MutatedDomains)
 	for _, list := range domainMutators {
 		for mutation, baseDomains := range list.GetMutations() {
 			// remove invalid domains
-			if _, err := publicsuffix.EffectiveTLDPlusOne(string(mutation)); err != nil {
+			if _, err := cs.EffectiveTLDPlusOne(string(mutation)); err != nil {
 				continue
 			}
 			for domain, _ := range baseDomains {
@@ -155,7 +157,6 @@ func main() {
 	}
 
 	var outputFile *os.File
-	var err error
 
 	if *outputFilepath == "-" {
 		outputFile = os.Stdout
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"github.com/teamnsrg/certificate-searcher/cmd/internal/cli"
+	"go.uber.org/zap"
+	"os"
+)
+
+var log *zap.SugaredLogger
+
+// Command line flags
+var (
+	outputFilepath    = flag.String("o", "-", "Output file for the JSON evaluation report")
+	configFilepath    = flag.String("config", "", "YAML or .json file selecting labelers and their parameters; explicit flags override it")
+	domainFilepath    = flag.String("domains", "", ".txt file with base domain names for name-similarity labeling")
+	pslFilepath       = flag.String("psl-file", "", "Public Suffix List file to use instead of the embedded snapshot")
+	allowlistFilepath = flag.String("allowlist", "", ".json file with brand-owned domains and certificate orgs whose matches are suppressed")
+	indexFilepath     = flag.String("index", "", "Mutation index file from build-index, used instead of generating mutations when its inputs still match")
+	logFlags          = cli.RegisterLogFlags()
+	usage             = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: %s <flags> <ground-truth-csv>\n", os.Args[0], os.Args[0])
+		fmt.Fprint(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+)
+
+func applyFlagOverrides(config *cs.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "domains":
+			config.BaseDomainFile = *domainFilepath
+		case "psl-file":
+			config.PSLFile = *pslFilepath
+		case "allowlist":
+			config.AllowlistFile = *allowlistFilepath
+		}
+	})
+}
+
+/*
+Scores the configured labelers against a ground-truth file of hostname,class,labels rows and
+writes per-label precision and recall, label confusion and throughput as JSON, so changes to base
+lists and labeler parameters can be tracked over time.
+*/
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	logger, err := logFlags.NewLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up logging: %s\n", err.Error())
+		os.Exit(1)
+	}
+	log = logger
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	entries, err := cs.LoadGroundTruth(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("loaded %d ground-truth hostnames from %s", len(entries), flag.Arg(0))
+
+	config, err := cli.LoadConfig(*configFilepath, applyFlagOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	baseDomains, err := cli.LoadBaseDomains(log, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cli.LoadPSL(log, config); err != nil {
+		log.Fatal(err)
+	}
+	allowlist, err := cli.LoadAllowlist(log, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("building domain labelers")
+	loadedIndex := cli.OpenMutationIndex(log, config, *indexFilepath, baseDomains)
+	domainLabelers, mutationIndex, err := config.BuildLabelers(&baseDomains, loadedIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mutationIndex.Close()
+
+	report := cs.Evaluate(entries, domainLabelers, allowlist)
+	report.Config = config
+	log.Infof("detection precision %.3f recall %.3f over %d hostnames at %.0f hostnames/s",
+		report.Detection.Precision, report.Detection.Recall, report.Hostnames, report.HostnamesPerSecond)
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var outputFile *os.File
+	if *outputFilepath == "-" {
+		outputFile = os.Stdout
+	} else {
+		outputFile, err = os.Create(*outputFilepath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer outputFile.Close()
+
+	outputFile.Write(append(reportJSON, '\n'))
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+// Base domains used when neither the config nor -domains lists any
+var DefaultBaseDomains = []string{
+	"google.com",
+	"youtube.com",
+	"tmall.com",
+	"facebook.com",
+	"baidu.com",
+	"apple.com",
+}
+
+// Loads the config at path, or the defaults if path is empty, then applies the explicit flags and validates it
+func LoadConfig(path string, applyFlagOverrides func(config *cs.Config)) (*cs.Config, error) {
+	config := cs.DefaultConfig()
+	if path != "" {
+		var err error
+		config, err = cs.LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	applyFlagOverrides(config)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// The config's base domains followed by those in its base domain file, lower cased
+func LoadBaseDomains(log *zap.SugaredLogger, config *cs.Config) ([]string, error) {
+	if config.BaseDomainFile == "" && len(config.BaseDomains) == 0 {
+		log.Infof("No base domain file specified, using default list of %d domains", len(DefaultBaseDomains))
+		return append(make([]string, 0), DefaultBaseDomains...), nil
+	}
+
+	baseDomains := append(make([]string, 0), config.BaseDomains...)
+	if config.BaseDomainFile == "" {
+		return baseDomains, nil
+	}
+
+	f, err := os.Open(config.BaseDomainFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rawDomain := strings.TrimSpace(scanner.Text())
+		sanitizedDomain := strings.ToLower(rawDomain)
+		baseDomains = append(baseDomains, sanitizedDomain)
+
+		if rawDomain != sanitizedDomain {
+			log.Warnf("domain %s was sanitized to %s", rawDomain, sanitizedDomain)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read base domains from %s: %s", config.BaseDomainFile, err.Error())
+	}
+
+	return baseDomains, nil
+}
+
+// Replaces the embedded public suffix list with the config's PSL file, if it has one
+func LoadPSL(log *zap.SugaredLogger, config *cs.Config) error {
+	if config.PSLFile != "" {
+		psl, err := cs.LoadPublicSuffixListFile(config.PSLFile)
+		if err != nil {
+			return fmt.Errorf("unable to load PSL from %s: %s", config.PSLFile, err.Error())
+		}
+		cs.SetPublicSuffixList(psl)
+	}
+	log.Infof("using public suffix list with %d rules (sha256 %s)", len(cs.PSL.Rules), cs.PSL.Checksum)
+	return nil
+}
+
+// The config's allowlist, or nil if it has none
+func LoadAllowlist(log *zap.SugaredLogger, config *cs.Config) (*cs.Allowlist, error) {
+	if config.AllowlistFile == "" {
+		return nil, nil
+	}
+
+	allowlist, err := cs.LoadAllowlist(config.AllowlistFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load allowlist from %s: %s", config.AllowlistFile, err.Error())
+	}
+	log.Infof("loaded allowlist for %d brands", len(allowlist.Brands))
+	return allowlist, nil
+}
+
+// Maps the mutation index file at path if it was built from the same inputs, otherwise returns nil so mutations are generated
+func OpenMutationIndex(log *zap.SugaredLogger, config *cs.Config, path string, baseDomains []string) *cs.MutationIndex {
+	if path == "" {
+		return nil
+	}
+
+	index, header, err := cs.OpenMutationIndexFile(path, config.MutationIndexInputs(baseDomains))
+	if err != nil {
+		log.Warnf("not using mutation index %s, generating mutations instead: %s", path, err.Error())
+		return nil
+	}
+	log.Infof("mapped mutation index %s built %s", path, header.Created.Format(time.RFC3339))
+	return index
+}
+
+// Logs the size of the mutation index the labelers use, and the memory building it took if it was generated
+func LogMutationIndex(log *zap.SugaredLogger, index *cs.MutationIndex) {
+	log.Infof("mutation index holds %d mutations and %d postings for %d base domains in %.1f MiB",
+		index.Len(), index.PostingCount(), len(index.BaseDomains), float64(index.SizeBytes())/(1<<20))
+	if index.PeakBuildBytes > 0 {
+		log.Infof("building the mutation index held at most %.1f MiB", float64(index.PeakBuildBytes)/(1<<20))
+	}
+}
@@ -0,0 +1,58 @@
+// Setup shared by the commands: logging, configuration, base domains and mutation indexes.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+)
+
+// Where and how a command logs
+type LogFlags struct {
+	File   *string
+	Format *string
+	Level  *string
+}
+
+// Registers -log-file, -log-format and -log-level on the command line flags
+func RegisterLogFlags() *LogFlags {
+	return &LogFlags{
+		File:   flag.String("log-file", "", "File to append logs to instead of stderr"),
+		Format: flag.String("log-format", "console", "Log format, console or json"),
+		Level:  flag.String("log-level", "info", "Minimum level to log (debug, info, warn, error)"),
+	}
+}
+
+// Logs go to stderr, or -log-file, so stdout only carries output
+func (f *LogFlags) NewLogger() (*zap.SugaredLogger, error) {
+	level, err := zapcore.ParseLevel(*f.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var encoder zapcore.Encoder
+	switch *f.Format {
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case "json":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return nil, fmt.Errorf("unknown log format %s, expected console or json", *f.Format)
+	}
+
+	logOutput := os.Stderr
+	if *f.File != "" {
+		if logOutput, err = os.OpenFile(*f.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	atom := zap.NewAtomicLevelAt(level)
+	logger := zap.New(zapcore.NewCore(
+		encoder,
+		zapcore.Lock(logOutput),
+		atom), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+	return logger.Sugar(), nil
+}
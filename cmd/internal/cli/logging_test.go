@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func logFlags(file, format, level string) *LogFlags {
+	return &LogFlags{File: &file, Format: &format, Level: &level}
+}
+
+func TestNewLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		format string
+		level  string
+		// substrings of each line written
+		want []string
+	}{
+		{"json", "info", []string{`"msg":"searching"`, `"msg":"unable to open input"`}},
+		{"json", "warn", []string{`"msg":"unable to open input"`}},
+		// with the caller
+		{"console", "debug", []string{"\tDEBUG\tcli/logging_test.go", "\tINFO\tcli/logging_test.go", "\tWARN\tcli/logging_test.go"}},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.format+"-"+test.level+".log")
+		// logs are appended to an existing file
+		if err := ioutil.WriteFile(path, []byte("previous run\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		logger, err := logFlags(path, test.format, test.level).NewLogger()
+		if err != nil {
+			t.Fatalf("%s %s: NewLogger: %s", test.format, test.level, err)
+		}
+		logger.Debugf("parsed flags")
+		logger.Infof("searching")
+		logger.Warnf("unable to open input")
+		logger.Sync()
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		if lines[0] != "previous run" {
+			t.Errorf("%s %s: log file starts with %q, want the previous contents kept", test.format, test.level, lines[0])
+		}
+		lines = lines[1:]
+		if len(lines) != len(test.want) {
+			t.Errorf("%s %s: wrote %d lines, want %d:\n%s", test.format, test.level, len(lines), len(test.want), contents)
+			continue
+		}
+		for idx, line := range lines {
+			if !strings.Contains(line, test.want[idx]) {
+				t.Errorf("%s %s: line %q does not contain %q", test.format, test.level, line, test.want[idx])
+			}
+			if test.format == "json" {
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Errorf("%s %s: line %q is not JSON: %s", test.format, test.level, line, err)
+				}
+			}
+		}
+	}
+}
+
+func TestNewLoggerErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		flags *LogFlags
+	}{
+		{"unknown level", logFlags("", "console", "verbose")},
+		{"unknown format", logFlags("", "logfmt", "info")},
+		{"unwritable file", logFlags(filepath.Join(dir, "missing", "search.log"), "json", "info")},
+	}
+
+	for _, test := range tests {
+		if _, err := test.flags.NewLogger(); err == nil {
+			t.Errorf("%s: NewLogger() succeeded, want an error", test.name)
+		}
+	}
+}
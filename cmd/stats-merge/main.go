@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	cs "github.com/teamnsrg/certificate-searcher"
+	"github.com/teamnsrg/certificate-searcher/cmd/internal/cli"
+	"go.uber.org/zap"
+	"os"
+)
+
+var log *zap.SugaredLogger
+
+// Command line flags
+var (
+	outputFilepath   = flag.String("o", "-", "Output file for the merged statistics report")
+	snapshotFilepath = flag.String("snapshot", "", "File to write the merged stats snapshot to, for merging again later")
+	logFlags         = cli.RegisterLogFlags()
+	usage            = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: %s <flags> <stats-snapshot>...\n", os.Args[0], os.Args[0])
+		fmt.Fprint(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+)
+
+/*
+Merges the stats snapshots that certificate-searcher -stats-snapshot wrote for separate shards of
+a corpus into one statistics report, counting certificates several shards saw once.
+*/
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	logger, err := logFlags.NewLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up logging: %s\n", err.Error())
+		os.Exit(1)
+	}
+	log = logger
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	snapshots := make([]*cs.StatsSnapshot, 0, flag.NArg())
+	for _, path := range flag.Args() {
+		snapshot, err := cs.LoadStatsSnapshot(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("loaded %s: %d shards, %d parent SPKI subjects", path, snapshot.Shards, len(snapshot.Parents))
+		snapshots = append(snapshots, snapshot)
+	}
+
+	merged, err := cs.MergeStatsSnapshots(snapshots)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *snapshotFilepath != "" {
+		if err := cs.WriteStatsSnapshot(*snapshotFilepath, merged); err != nil {
+			log.Fatalf("Unable to write stats snapshot %s: %s", *snapshotFilepath, err.Error())
+		}
+		log.Infof("wrote merged snapshot of %d shards to %s", merged.Shards, *snapshotFilepath)
+	}
+
+	var outputFile *os.File
+	if *outputFilepath == "-" {
+		outputFile = os.Stdout
+	} else {
+		outputFile, err = os.Create(*outputFilepath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := bufio.NewWriter(outputFile)
+	w.WriteString(merged.String())
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	outputFile.Close()
+}
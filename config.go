@@ -0,0 +1,377 @@
+package certificate_searcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type LabelerToggle struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+type TypoSquattingConfig struct {
+	Enabled         bool     `yaml:"enabled" json:"enabled"`
+	KeyboardLayouts []string `yaml:"keyboard_layouts" json:"keyboard_layouts"`
+	// Keys closer than this many key widths are adjacent
+	AdjacencyCutoff float64 `yaml:"adjacency_cutoff" json:"adjacency_cutoff"`
+}
+
+type HomoGraphConfig struct {
+	Enabled          bool `yaml:"enabled" json:"enabled"`
+	MaxHomoglyphSubs int  `yaml:"max_homoglyph_subs" json:"max_homoglyph_subs"`
+}
+
+type IDNConfusableConfig struct {
+	Enabled             bool   `yaml:"enabled" json:"enabled"`
+	MaxRestrictionLevel string `yaml:"max_restriction_level" json:"max_restriction_level"`
+}
+
+type EditDistanceConfig struct {
+	Enabled     bool `yaml:"enabled" json:"enabled"`
+	MaxDistance int  `yaml:"max_distance" json:"max_distance"`
+}
+
+type WrongTLDConfig struct {
+	Enabled        bool `yaml:"enabled" json:"enabled"`
+	IncludePrivate bool `yaml:"include_private" json:"include_private"`
+}
+
+// A hostname blocklist; an empty FeedFile uses the snapshot bundled in domainlists/
+type FeedConfig struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	FeedFile string `yaml:"feed_file,omitempty" json:"feed_file,omitempty"`
+}
+
+type LabelersConfig struct {
+	TypoSquatting   TypoSquattingConfig `yaml:"typosquatting" json:"typosquatting"`
+	TargetEmbedding LabelerToggle       `yaml:"target_embedding" json:"target_embedding"`
+	HomoGraph       HomoGraphConfig     `yaml:"homograph" json:"homograph"`
+	IDNConfusable   IDNConfusableConfig `yaml:"idn_confusable" json:"idn_confusable"`
+	EditDistance    EditDistanceConfig  `yaml:"edit_distance" json:"edit_distance"`
+	BitSquatting    LabelerToggle       `yaml:"bitsquatting" json:"bitsquatting"`
+	WrongTLD        WrongTLDConfig      `yaml:"wrongtld" json:"wrongtld"`
+	PhishTank       FeedConfig          `yaml:"phishtank" json:"phishtank"`
+	OpenPhish       FeedConfig          `yaml:"openphish" json:"openphish"`
+	SafeBrowsing    FeedConfig          `yaml:"safebrowsing" json:"safebrowsing"`
+	// Certificate labelers
+	SSLBlacklist FeedConfig `yaml:"sslblacklist" json:"sslblacklist"`
+}
+
+/*
+Which labelers run and with what parameters, plus the inputs they are built from. Files may
+set any subset of fields; the rest keep the values from DefaultConfig. TypoPriors maps label names
+to the prior likelihood of that typo type, and labels a file leaves out keep their default prior.
+*/
+type Config struct {
+	BaseDomains    []string           `yaml:"base_domains,omitempty" json:"base_domains,omitempty"`
+	BaseDomainFile string             `yaml:"base_domain_file,omitempty" json:"base_domain_file,omitempty"`
+	PSLFile        string             `yaml:"psl_file,omitempty" json:"psl_file,omitempty"`
+	AllowlistFile  string             `yaml:"allowlist_file,omitempty" json:"allowlist_file,omitempty"`
+	MinScore       float64            `yaml:"min_score" json:"min_score"`
+	TypoPriors     map[string]float64 `yaml:"typo_priors" json:"typo_priors"`
+	Labelers       LabelersConfig     `yaml:"labelers" json:"labelers"`
+}
+
+func DefaultConfig() *Config {
+	typoPriors := make(map[string]float64)
+	for label, prior := range DefaultTypoPriors() {
+		typoPriors[label.String()] = prior
+	}
+
+	return &Config{
+		TypoPriors: typoPriors,
+		Labelers: LabelersConfig{
+			TypoSquatting: TypoSquattingConfig{
+				Enabled:         true,
+				KeyboardLayouts: []string{QWERTY.Name},
+				AdjacencyCutoff: DefaultAdjacencyCutoff,
+			},
+			TargetEmbedding: LabelerToggle{Enabled: true},
+			HomoGraph:       HomoGraphConfig{Enabled: true, MaxHomoglyphSubs: 2},
+			IDNConfusable:   IDNConfusableConfig{Enabled: true, MaxRestrictionLevel: HIGHLY_RESTRICTIVE.String()},
+			EditDistance:    EditDistanceConfig{Enabled: true, MaxDistance: 2},
+			BitSquatting:    LabelerToggle{Enabled: true},
+			WrongTLD:        WrongTLDConfig{Enabled: true},
+			PhishTank:       FeedConfig{Enabled: true},
+			OpenPhish:       FeedConfig{Enabled: true},
+			SafeBrowsing:    FeedConfig{Enabled: true},
+			// no bundled snapshot, needs a feed_file
+			SSLBlacklist: FeedConfig{Enabled: false},
+		},
+	}
+}
+
+// Reads a .json config, or YAML for any other extension, on top of DefaultConfig. Unknown keys are errors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	// decoded on its own and merged below, strict YAML rejects keys already in a map
+	defaultPriors := config.TypoPriors
+	config.TypoPriors = nil
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	} else {
+		err = yaml.UnmarshalStrict(data, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", path, err.Error())
+	}
+	if config.TypoPriors == nil {
+		config.TypoPriors = make(map[string]float64)
+	}
+	for name, prior := range defaultPriors {
+		if _, present := config.TypoPriors[name]; !present {
+			config.TypoPriors[name] = prior
+		}
+	}
+
+	return config, nil
+}
+
+func checkFileExists(problems []string, field string, path string) []string {
+	if path == "" {
+		return problems
+	}
+	if _, err := os.Stat(path); err != nil {
+		return append(problems, fmt.Sprintf("%s: %s", field, err.Error()))
+	}
+	return problems
+}
+
+// Reports every invalid parameter and missing input file at once
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	labelers := c.Labelers
+
+	if c.MinScore < 0 || c.MinScore > maxRiskScore {
+		problems = append(problems, fmt.Sprintf("min_score must be between 0 and %.0f", maxRiskScore))
+	}
+	if _, err := c.LikelihoodPriors(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	problems = checkFileExists(problems, "base_domain_file", c.BaseDomainFile)
+	problems = checkFileExists(problems, "psl_file", c.PSLFile)
+	problems = checkFileExists(problems, "allowlist_file", c.AllowlistFile)
+
+	if labelers.TypoSquatting.Enabled {
+		if len(labelers.TypoSquatting.KeyboardLayouts) == 0 {
+			problems = append(problems, "typosquatting.keyboard_layouts must not be empty")
+		}
+		if len(labelers.TypoSquatting.KeyboardLayouts) > 32 {
+			problems = append(problems, "typosquatting.keyboard_layouts supports at most 32 layouts")
+		}
+		for _, name := range labelers.TypoSquatting.KeyboardLayouts {
+			if GetKeyboardLayout(name) == nil {
+				problems = append(problems, "typosquatting.keyboard_layouts: unknown layout "+name)
+			}
+		}
+		if labelers.TypoSquatting.AdjacencyCutoff <= 1 {
+			problems = append(problems, "typosquatting.adjacency_cutoff must be greater than 1 key width")
+		}
+	}
+	if labelers.HomoGraph.Enabled && labelers.HomoGraph.MaxHomoglyphSubs < 1 {
+		problems = append(problems, "homograph.max_homoglyph_subs must be at least 1")
+	}
+	if labelers.IDNConfusable.Enabled {
+		if _, err := ParseRestrictionLevel(labelers.IDNConfusable.MaxRestrictionLevel); err != nil {
+			problems = append(problems, "idn_confusable.max_restriction_level: "+err.Error())
+		}
+	}
+	if labelers.EditDistance.Enabled && labelers.EditDistance.MaxDistance < 1 {
+		problems = append(problems, "edit_distance.max_distance must be at least 1")
+	}
+	problems = checkFileExists(problems, "phishtank.feed_file", labelers.PhishTank.FeedFile)
+	problems = checkFileExists(problems, "openphish.feed_file", labelers.OpenPhish.FeedFile)
+	problems = checkFileExists(problems, "safebrowsing.feed_file", labelers.SafeBrowsing.FeedFile)
+	if labelers.SSLBlacklist.Enabled && labelers.SSLBlacklist.FeedFile == "" {
+		problems = append(problems, "sslblacklist.feed_file is required when sslblacklist is enabled")
+	}
+	problems = checkFileExists(problems, "sslblacklist.feed_file", labelers.SSLBlacklist.FeedFile)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// The effective configuration as YAML, for the run log
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// The configured keyboard layouts with the configured adjacency cutoff applied
+func (c *Config) KeyboardLayouts() ([]*KeyboardLayout, error) {
+	layouts := make([]*KeyboardLayout, 0)
+	for _, name := range c.Labelers.TypoSquatting.KeyboardLayouts {
+		layout := GetKeyboardLayout(strings.TrimSpace(name))
+		if layout == nil {
+			return nil, fmt.Errorf("unknown keyboard layout %s", name)
+		}
+		if c.Labelers.TypoSquatting.AdjacencyCutoff != layout.AdjacencyCutoff {
+			layout = layout.WithAdjacencyCutoff(c.Labelers.TypoSquatting.AdjacencyCutoff)
+		}
+		layouts = append(layouts, layout)
+	}
+
+	return layouts, nil
+}
+
+// TypoPriors keyed by label
+func (c *Config) LikelihoodPriors() (TypoPriors, error) {
+	priors := make(TypoPriors)
+	for name, prior := range c.TypoPriors {
+		label, err := ParseDomainLabel(name)
+		if err != nil {
+			return nil, fmt.Errorf("typo_priors: %s", err.Error())
+		}
+		if prior <= 0 || prior > 1 {
+			return nil, fmt.Errorf("typo_priors.%s must be greater than 0 and at most 1", name)
+		}
+		priors[label] = prior
+	}
+	return priors, nil
+}
+
+func (c *Config) MutationParams() MutationParams {
+	return MutationParams{
+		TypoSquatting: c.Labelers.TypoSquatting,
+		HomoGraph:     c.Labelers.HomoGraph,
+		BitSquatting:  c.Labelers.BitSquatting,
+	}
+}
+
+// What an index file must have been built from to serve this configuration over baseDomains
+func (c *Config) MutationIndexInputs(baseDomains []string) MutationIndexInputs {
+	return MutationIndexInputs{
+		BaseDomainsSHA256: HashBaseDomains(baseDomains),
+		PSLSHA256:         PSL.Checksum,
+		Params:            c.MutationParams(),
+	}
+}
+
+/*
+Builds the enabled mutation-based labelers (typosquatting, homograph, bitsquatting, wrong TLD).
+All but wrong TLD, which looks names up by registrable label, share one MutationIndex. If index
+is nil the mutations are generated, otherwise index must already hold them, e.g. from
+OpenMutationIndexFile.
+*/
+func (c *Config) BuildMutationLabelers(baseDomains *[]string, index *MutationIndex) ([]DomainLabeler, *MutationIndex, error) {
+	labelers := c.Labelers
+	domainLabelers := make([]DomainLabeler, 0)
+
+	var layouts []*KeyboardLayout
+	if labelers.TypoSquatting.Enabled {
+		var err error
+		if layouts, err = c.KeyboardLayouts(); err != nil {
+			return nil, nil, err
+		}
+	}
+	priors, err := c.LikelihoodPriors()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if index != nil {
+		if labelers.TypoSquatting.Enabled {
+			tsl := NewTypoSquattingLabelerFromIndex(baseDomains, layouts, index)
+			tsl.Priors = priors
+			domainLabelers = append(domainLabelers, tsl)
+		}
+		if labelers.HomoGraph.Enabled {
+			domainLabelers = append(domainLabelers, NewHomoGraphLabelerFromIndex(baseDomains, index))
+		}
+		if labelers.BitSquatting.Enabled {
+			domainLabelers = append(domainLabelers, NewBitSquattingLabelerFromIndex(baseDomains, index))
+		}
+		if labelers.WrongTLD.Enabled {
+			domainLabelers = append(domainLabelers, NewWrongTLDLabeler(baseDomains, labelers.WrongTLD.IncludePrivate))
+		}
+		return domainLabelers, index, nil
+	}
+
+	builder := NewMutationIndexBuilder()
+	if labelers.TypoSquatting.Enabled {
+		tsl := NewTypoSquattingLabeler(baseDomains, layouts, builder)
+		tsl.Priors = priors
+		domainLabelers = append(domainLabelers, tsl)
+	}
+	if labelers.HomoGraph.Enabled {
+		domainLabelers = append(domainLabelers, NewHomoGraphLabeler(baseDomains, labelers.HomoGraph.MaxHomoglyphSubs, builder))
+	}
+	if labelers.BitSquatting.Enabled {
+		domainLabelers = append(domainLabelers, NewBitSquattingLabeler(baseDomains, builder))
+	}
+	if labelers.WrongTLD.Enabled {
+		domainLabelers = append(domainLabelers, NewWrongTLDLabeler(baseDomains, labelers.WrongTLD.IncludePrivate))
+	}
+
+	return domainLabelers, builder.Build(), nil
+}
+
+/*
+Builds the enabled labelers over baseDomains. All of them can be shared between goroutines, and
+the mutation-based ones share the returned MutationIndex (see BuildMutationLabelers for index).
+*/
+func (c *Config) BuildLabelers(baseDomains *[]string, index *MutationIndex) ([]DomainLabeler, *MutationIndex, error) {
+	labelers := c.Labelers
+
+	domainLabelers, index, err := c.BuildMutationLabelers(baseDomains, index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if labelers.TargetEmbedding.Enabled {
+		domainLabelers = append(domainLabelers, NewTargetEmbeddingLabeler(baseDomains))
+	}
+	if labelers.IDNConfusable.Enabled {
+		level, err := ParseRestrictionLevel(labelers.IDNConfusable.MaxRestrictionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		domainLabelers = append(domainLabelers, NewIDNConfusableLabeler(baseDomains, level))
+	}
+	if labelers.EditDistance.Enabled {
+		edl := NewEditDistanceLabeler(baseDomains, labelers.EditDistance.MaxDistance)
+		if edl.Priors, err = c.LikelihoodPriors(); err != nil {
+			return nil, nil, err
+		}
+		domainLabelers = append(domainLabelers, edl)
+	}
+	if labelers.PhishTank.Enabled {
+		domainLabelers = append(domainLabelers, NewPhishTankLabeler(labelers.PhishTank.FeedFile))
+	}
+	if labelers.OpenPhish.Enabled {
+		domainLabelers = append(domainLabelers, NewOpenPhishLabeler(labelers.OpenPhish.FeedFile))
+	}
+	if labelers.SafeBrowsing.Enabled {
+		domainLabelers = append(domainLabelers, NewSafeBrowsingLabeler(labelers.SafeBrowsing.FeedFile))
+	}
+
+	return domainLabelers, index, nil
+}
+
+func (c *Config) BuildCertificateLabelers() []CertificateLabeler {
+	certLabelers := make([]CertificateLabeler, 0)
+
+	if c.Labelers.SSLBlacklist.Enabled {
+		certLabelers = append(certLabelers, NewSSLBlacklistLabeler(c.Labelers.SSLBlacklist.FeedFile))
+	}
+
+	return certLabelers
+}
@@ -0,0 +1,255 @@
+package certificate_searcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes contents to name in a new temporary directory, returning its path and the directory
+func writeConfigFile(t *testing.T, name, contents string) (string, string) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name, contents string
+		modify         func(config *Config)
+	}{
+		{"empty.yaml", "", func(config *Config) {}},
+		{
+			"partial.yaml",
+			"base_domains: [google.com, paypal.com]\nmin_score: 30\nlabelers:\n  edit_distance: {max_distance: 1}\n  phishtank: {enabled: false}\n",
+			func(config *Config) {
+				config.BaseDomains = []string{"google.com", "paypal.com"}
+				config.MinScore = 30
+				// fields the file leaves out keep their defaults, enabled included
+				config.Labelers.EditDistance.MaxDistance = 1
+				config.Labelers.PhishTank.Enabled = false
+			},
+		},
+		{
+			"priors.yaml",
+			"typo_priors:\n  TYPOSQUATTING_CHAR_OMISSION: 0.5\n",
+			func(config *Config) {
+				config.TypoPriors["TYPOSQUATTING_CHAR_OMISSION"] = 0.5
+			},
+		},
+		{
+			"config.JSON",
+			`{"labelers": {"typosquatting": {"keyboard_layouts": ["qwerty", "azerty"]}, "wrongtld": {"include_private": true}}}`,
+			func(config *Config) {
+				config.Labelers.TypoSquatting.KeyboardLayouts = []string{"qwerty", "azerty"}
+				config.Labelers.WrongTLD.IncludePrivate = true
+			},
+		},
+	}
+
+	for _, test := range tests {
+		path, dir := writeConfigFile(t, test.name, test.contents)
+		defer os.RemoveAll(dir)
+
+		want := DefaultConfig()
+		test.modify(want)
+
+		got, err := LoadConfig(path)
+		if err != nil {
+			t.Errorf("LoadConfig(%s): %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("LoadConfig(%s) = %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, contents string
+	}{
+		{"unknown.yaml", "labelers:\n  typosquating: {enabled: false}\n"},
+		{"unknown.json", `{"labelers": {"typosquating": {"enabled": false}}}`},
+		{"type.yaml", "min_score: high\n"},
+		{"syntax.json", `{"min_score": `},
+	}
+
+	for _, test := range tests {
+		path, dir := writeConfigFile(t, test.name, test.contents)
+		defer os.RemoveAll(dir)
+
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("LoadConfig(%s) error = %v, want one naming the file", test.name, err)
+		}
+	}
+
+	if _, err := LoadConfig(filepath.Join(os.TempDir(), "missing-config.yaml")); err == nil {
+		t.Error("LoadConfig of a missing file succeeded, want an error")
+	}
+}
+
+// The effective config echoed to the run log loads back as the same config
+func TestConfigStringRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.BaseDomains = []string{"google.com"}
+	config.Labelers.TypoSquatting.KeyboardLayouts = []string{"qwertz", "phone"}
+	config.Labelers.PhishTank.FeedFile = "phishtank.txt"
+	config.Labelers.SSLBlacklist = FeedConfig{Enabled: true, FeedFile: "sslblacklist.csv"}
+	config.TypoPriors["EDIT_DISTANCE"] = 0.2
+
+	path, dir := writeConfigFile(t, "echoed.yaml", config.String())
+	defer os.RemoveAll(dir)
+
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %s", err)
+	}
+	if !reflect.DeepEqual(got, config) {
+		t.Errorf("LoadConfig(String()) = %+v, want %+v", got, config)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+		// substrings of the error, none for a valid config
+		want []string
+	}{
+		{"default", func(config *Config) {}, nil},
+		{"min score", func(config *Config) { config.MinScore = 101 }, []string{"min_score"}},
+		{"unknown prior label", func(config *Config) { config.TypoPriors["TYPO"] = 0.1 }, []string{"typo_priors"}},
+		{"prior out of range", func(config *Config) { config.TypoPriors["EDIT_DISTANCE"] = 0 }, []string{"typo_priors.EDIT_DISTANCE"}},
+		{"missing files", func(config *Config) {
+			config.BaseDomainFile = "missing-domains.txt"
+			config.Labelers.PhishTank.FeedFile = "missing-phishtank.txt"
+		}, []string{"base_domain_file", "phishtank.feed_file"}},
+		{"sslblacklist without feed", func(config *Config) { config.Labelers.SSLBlacklist.Enabled = true }, []string{"sslblacklist.feed_file is required"}},
+		{"keyboard layouts", func(config *Config) {
+			config.Labelers.TypoSquatting.KeyboardLayouts = []string{"qwerty", "colemak"}
+			config.Labelers.TypoSquatting.AdjacencyCutoff = 1
+		}, []string{"unknown layout colemak", "adjacency_cutoff"}},
+		{"no keyboard layouts", func(config *Config) { config.Labelers.TypoSquatting.KeyboardLayouts = nil }, []string{"keyboard_layouts must not be empty"}},
+		// parameters of disabled labelers are not checked
+		{"disabled typosquatting", func(config *Config) {
+			config.Labelers.TypoSquatting.Enabled = false
+			config.Labelers.TypoSquatting.KeyboardLayouts = nil
+		}, nil},
+		{"labeler parameters", func(config *Config) {
+			config.Labelers.HomoGraph.MaxHomoglyphSubs = 0
+			config.Labelers.IDNConfusable.MaxRestrictionLevel = "UNRESTRICTED"
+			config.Labelers.EditDistance.MaxDistance = 0
+		}, []string{"max_homoglyph_subs", "max_restriction_level", "max_distance"}},
+	}
+
+	for _, test := range tests {
+		config := DefaultConfig()
+		test.modify(config)
+
+		err := config.Validate()
+		if len(test.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: Validate() = %s, want no error", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Validate() succeeded, want an error", test.name)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: Validate() = %s, want it to mention %s", test.name, err, want)
+			}
+		}
+	}
+}
+
+func TestConfigBuildLabelers(t *testing.T) {
+	baseDomains := []string{"google.com"}
+	config := DefaultConfig()
+	config.Labelers.PhishTank.Enabled = false
+	config.Labelers.OpenPhish.Enabled = false
+	config.Labelers.SafeBrowsing.Enabled = false
+	config.Labelers.HomoGraph.Enabled = false
+
+	labelers, index, err := config.BuildLabelers(&baseDomains, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(labelers))
+	for _, labeler := range labelers {
+		names = append(names, reflect.TypeOf(labeler).Elem().Name())
+	}
+	want := []string{"TypoSquattingLabeler", "BitSquattingLabeler", "WrongTLDLabeler", "TargetEmbeddingLabeler", "IDNConfusableLabeler", "EditDistanceLabeler"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("BuildLabelers() = %v, want %v", names, want)
+	}
+	if index == nil || index.Len() == 0 {
+		t.Error("BuildLabelers() built no mutation index")
+	}
+	if certLabelers := config.BuildCertificateLabelers(); len(certLabelers) != 0 {
+		t.Errorf("BuildCertificateLabelers() = %v, want none by default", certLabelers)
+	}
+
+	feedFile, dir := writeConfigFile(t, "sslblacklist.csv", "# Listingdate,SHA1,Listingreason\n")
+	defer os.RemoveAll(dir)
+	config.Labelers.SSLBlacklist = FeedConfig{Enabled: true, FeedFile: feedFile}
+	certLabelers := config.BuildCertificateLabelers()
+	if len(certLabelers) != 1 {
+		t.Fatalf("BuildCertificateLabelers() = %v, want the SSL Blacklist labeler", certLabelers)
+	}
+	if _, ok := certLabelers[0].(*SSLBlacklistLabeler); !ok {
+		t.Errorf("BuildCertificateLabelers() = %T, want *SSLBlacklistLabeler", certLabelers[0])
+	}
+}
+
+func TestConfigKeyboardLayouts(t *testing.T) {
+	config := DefaultConfig()
+	config.Labelers.TypoSquatting.KeyboardLayouts = []string{"qwerty", " AZERTY"}
+	layouts, err := config.KeyboardLayouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layouts) != 2 || layouts[0] != QWERTY || layouts[1] != AZERTY {
+		t.Errorf("KeyboardLayouts() = %v, want the built-in qwerty and azerty", layouts)
+	}
+
+	config.Labelers.TypoSquatting.AdjacencyCutoff = 2.5
+	if layouts, err = config.KeyboardLayouts(); err != nil {
+		t.Fatal(err)
+	}
+	if adjacent, _ := layouts[0].Adjacent('g', 'j'); !adjacent || layouts[0].AdjacencyCutoff != 2.5 {
+		t.Errorf("KeyboardLayouts() with a 2.5 cutoff = %v, want g and j adjacent", layouts)
+	}
+
+	config.Labelers.TypoSquatting.KeyboardLayouts = []string{"colemak"}
+	if _, err := config.KeyboardLayouts(); err == nil {
+		t.Error("KeyboardLayouts() with colemak succeeded, want an error")
+	}
+}
+
+func TestConfigLikelihoodPriors(t *testing.T) {
+	config := DefaultConfig()
+	config.TypoPriors["TYPOSQUATTING_CHAR_OMISSION"] = 0.5
+
+	priors, err := config.LikelihoodPriors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultTypoPriors()
+	want[TYPOSQUATTING_CHAR_OMISSION] = 0.5
+	if !reflect.DeepEqual(priors, want) {
+		t.Errorf("LikelihoodPriors() = %v, want %v", priors, want)
+	}
+}
@@ -0,0 +1,424 @@
+package certificate_searcher
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+Restriction levels from Unicode Technical Standard #39, section 5.2, ordered from most to least
+restrictive. UNRESTRICTED is left out: telling it apart needs the identifier profile of UTS #39
+section 3.1, which is not bundled, so labels outside the profile rank as MINIMALLY_RESTRICTIVE.
+*/
+type RestrictionLevel int
+
+const (
+	ASCII_ONLY RestrictionLevel = iota
+	SINGLE_SCRIPT
+	HIGHLY_RESTRICTIVE
+	MODERATELY_RESTRICTIVE
+	MINIMALLY_RESTRICTIVE
+)
+
+func (rl RestrictionLevel) String() string {
+	return [...]string{
+		"ASCII_ONLY",
+		"SINGLE_SCRIPT",
+		"HIGHLY_RESTRICTIVE",
+		"MODERATELY_RESTRICTIVE",
+		"MINIMALLY_RESTRICTIVE",
+	}[rl]
+}
+
+func ParseRestrictionLevel(name string) (RestrictionLevel, error) {
+	for level := ASCII_ONLY; level <= MINIMALLY_RESTRICTIVE; level++ {
+		if strings.EqualFold(level.String(), name) {
+			return level, nil
+		}
+	}
+	return ASCII_ONLY, fmt.Errorf("unknown restriction level %s", name)
+}
+
+// One run of code points in scriptRanges
+type scriptRange struct {
+	lo, hi rune
+	script string
+}
+
+// Every range of unicode.Scripts, sorted, so a rune's script is one binary search away
+var scriptRanges []scriptRange
+
+/*
+Script_Extensions of a hand-picked set of characters that UTS #39 counts towards several scripts
+although their Script property is Common, Inherited or a single script. This is not the whole of
+ScriptExtensions.txt: other characters resolve to their Script property alone, so a label mixing
+them with a script they extend to can rank as less restrictive than it is.
+*/
+var scriptExtensionRanges = []struct {
+	lo, hi  rune
+	scripts []string
+}{
+	{0x0483, 0x0483, []string{"Cyrillic", "Old_Permic"}},
+	{0x0484, 0x0484, []string{"Cyrillic", "Glagolitic"}},
+	{0x0487, 0x0487, []string{"Cyrillic", "Glagolitic"}},
+	{0x0660, 0x0669, []string{"Arabic", "Thaana", "Yezidi"}},
+	{0x0951, 0x0952, []string{"Bengali", "Devanagari", "Grantha", "Gujarati", "Gurmukhi", "Kannada", "Latin", "Malayalam", "Oriya", "Tamil", "Telugu", "Tirhuta"}},
+	{0x3006, 0x3006, []string{"Han"}},
+	{0x302A, 0x302D, []string{"Bopomofo", "Han"}},
+	{0x3031, 0x3035, []string{"Hiragana", "Katakana"}},
+	{0x3099, 0x309C, []string{"Hiragana", "Katakana"}},
+	{0x30A0, 0x30A0, []string{"Hiragana", "Katakana"}},
+	{0x30FC, 0x30FC, []string{"Hiragana", "Katakana"}},
+	{0x3190, 0x319F, []string{"Han"}},
+	{0x31C0, 0x31E3, []string{"Han"}},
+	{0xA700, 0xA707, []string{"Han", "Latin"}},
+	{0xFF70, 0xFF70, []string{"Hiragana", "Katakana"}},
+	{0xFF9E, 0xFF9F, []string{"Hiragana", "Katakana"}},
+}
+
+var scriptExtensions = make(map[rune][]string)
+
+// Writing systems UTS #39 treats as scripts of their own when resolving script sets
+const (
+	HAN_WITH_BOPOMOFO = "Han_with_Bopomofo"
+	JAPANESE          = "Japanese"
+	KOREAN            = "Korean"
+)
+
+func init() {
+	for name, table := range unicode.Scripts {
+		for _, r := range table.R16 {
+			scriptRanges = appendScriptRange(scriptRanges, rune(r.Lo), rune(r.Hi), rune(r.Stride), name)
+		}
+		for _, r := range table.R32 {
+			scriptRanges = appendScriptRange(scriptRanges, rune(r.Lo), rune(r.Hi), rune(r.Stride), name)
+		}
+	}
+	sort.Slice(scriptRanges, func(i, j int) bool { return scriptRanges[i].lo < scriptRanges[j].lo })
+
+	for _, extension := range scriptExtensionRanges {
+		for r := extension.lo; r <= extension.hi; r++ {
+			scriptExtensions[r] = extension.scripts
+		}
+	}
+}
+
+// Ranges with a stride interleave with other scripts, so those are split into single code points
+func appendScriptRange(ranges []scriptRange, lo, hi, stride rune, script string) []scriptRange {
+	if stride == 1 {
+		return append(ranges, scriptRange{lo: lo, hi: hi, script: script})
+	}
+	for r := lo; r <= hi; r += stride {
+		ranges = append(ranges, scriptRange{lo: r, hi: r, script: script})
+	}
+	return ranges
+}
+
+// Returns the script of r, or "Common"/"Inherited" for characters shared across scripts
+func runeScript(r rune) string {
+	if r <= unicode.MaxASCII {
+		if unicode.IsLetter(r) {
+			return "Latin"
+		}
+		return "Common"
+	}
+
+	idx := sort.Search(len(scriptRanges), func(i int) bool { return scriptRanges[i].hi >= r })
+	if idx < len(scriptRanges) && scriptRanges[idx].lo <= r {
+		return scriptRanges[idx].script
+	}
+	return "Unknown"
+}
+
+/*
+The augmented Script_Extensions of r from UTS #39 section 5.1: Han, Hiragana, Katakana, Hangul
+and Bopomofo also count as the Japanese, Korean or Han with Bopomofo writing systems they are part
+of. Nil means r is Common or Inherited and fits any script.
+*/
+func augmentedScripts(r rune) map[string]struct{} {
+	extensions, present := scriptExtensions[r]
+	if !present {
+		script := runeScript(r)
+		if script == "Common" || script == "Inherited" {
+			return nil
+		}
+		extensions = []string{script}
+	}
+
+	scripts := make(map[string]struct{})
+	for _, script := range extensions {
+		scripts[script] = struct{}{}
+		switch script {
+		case "Han":
+			scripts[HAN_WITH_BOPOMOFO] = struct{}{}
+			scripts[JAPANESE] = struct{}{}
+			scripts[KOREAN] = struct{}{}
+		case "Hiragana", "Katakana":
+			scripts[JAPANESE] = struct{}{}
+		case "Hangul":
+			scripts[KOREAN] = struct{}{}
+		case "Bopomofo":
+			scripts[HAN_WITH_BOPOMOFO] = struct{}{}
+		}
+	}
+	return scripts
+}
+
+/*
+The UTS #39 resolved script set of label: the scripts every character of it can be written in. An
+empty set means the label mixes scripts; nil means it only has Common and Inherited characters.
+With skipLatin, characters that can be Latin are left out.
+*/
+func resolveScripts(label string, skipLatin bool) map[string]struct{} {
+	var resolved map[string]struct{}
+	for _, r := range label {
+		scripts := augmentedScripts(r)
+		if scripts == nil {
+			continue
+		}
+		if _, latin := scripts["Latin"]; latin && skipLatin {
+			continue
+		}
+
+		if resolved == nil {
+			resolved = scripts
+			continue
+		}
+		for script := range resolved {
+			if _, present := scripts[script]; !present {
+				delete(resolved, script)
+			}
+		}
+	}
+
+	return resolved
+}
+
+func ResolvedScripts(label string) map[string]struct{} {
+	return resolveScripts(label, false)
+}
+
+// Computes the UTS #39 restriction level of a single U-label
+func GetRestrictionLevel(label string) RestrictionLevel {
+	ascii := true
+	for _, r := range label {
+		if r > unicode.MaxASCII {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return ASCII_ONLY
+	}
+
+	if resolved := ResolvedScripts(label); resolved == nil || len(resolved) > 0 {
+		return SINGLE_SCRIPT
+	}
+
+	// Latin plus Japanese, Korean or Han with Bopomofo
+	nonLatin := resolveScripts(label, true)
+	for _, writingSystem := range []string{JAPANESE, KOREAN, HAN_WITH_BOPOMOFO} {
+		if _, present := nonLatin[writingSystem]; present {
+			return HIGHLY_RESTRICTIVE
+		}
+	}
+
+	// Latin plus one other script, other than Cyrillic and Greek
+	if len(nonLatin) > 0 {
+		_, hasCyrillic := nonLatin["Cyrillic"]
+		_, hasGreek := nonLatin["Greek"]
+		if !hasCyrillic && !hasGreek {
+			return MODERATELY_RESTRICTIVE
+		}
+	}
+
+	return MINIMALLY_RESTRICTIVE
+}
+
+/*
+Computes the UTS #39 skeleton of s: NFD, then every character replaced by its prototype
+from the Unicode confusables, then NFD again. Glyphs only found in the other homoglyph
+tables take their preferred prototype, letters before digits, so Greek ο is o and not 0.
+*/
+func Skeleton(s string) string {
+	var skeleton strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if r <= unicode.MaxASCII {
+			skeleton.WriteRune(unicode.ToLower(r))
+			continue
+		}
+
+		if prototype, present := GLYPH_PROTOTYPE[string(r)]; present {
+			skeleton.WriteRune(prototype)
+			continue
+		}
+
+		prototypes := GLYPH_TO_ASCII[string(r)]
+		if len(prototypes) == 0 {
+			skeleton.WriteRune(r)
+			continue
+		}
+
+		prototype := prototypes[0]
+		for _, p := range prototypes[1:] {
+			if preferredPrototype(p, prototype) {
+				prototype = p
+			}
+		}
+		skeleton.WriteRune(prototype)
+	}
+
+	return norm.NFD.String(skeleton.String())
+}
+
+// Whether every character of a non-Latin single-script label can be mistaken for ASCII
+func WholeScriptConfusable(label string) bool {
+	scripts := ResolvedScripts(label)
+	if len(scripts) == 0 {
+		return false
+	}
+	if _, latin := scripts["Latin"]; latin {
+		return false
+	}
+
+	for _, r := range Skeleton(label) {
+		if !validDomainChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Flags IDN names that are suspicious on their own: U-labels mixing scripts beyond
+MaxRestrictionLevel, and whole-script confusables. A flagged label confusable with a base
+domain's registrable label is reported with that base domain as its source; any other is
+reported without a source. Details carry the reason and the skeleton.
+*/
+type IDNConfusableLabeler struct {
+	BaseDomains         *[]string
+	MaxRestrictionLevel RestrictionLevel
+	// Length in runes -> registrable labels of that length and their base domains
+	baseLabels map[int][]idnBase
+}
+
+type idnBase struct {
+	label  string
+	domain string
+}
+
+/*
+Whether uLabel can be mistaken for the ASCII label: every character is either the same or has the
+one in label among its homoglyph prototypes. Unlike comparing skeletons, this also holds for the
+prototypes Skeleton does not pick (ⅼ looks like l, i and 1).
+*/
+func ConfusableWith(uLabel, label string) bool {
+	u, l := []rune(norm.NFD.String(uLabel)), []rune(strings.ToLower(label))
+	if len(u) != len(l) {
+		return false
+	}
+	for idx, r := range u {
+		if unicode.ToLower(r) == l[idx] {
+			continue
+		}
+		if !runesContain(GLYPH_TO_ASCII[string(r)], l[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewIDNConfusableLabeler(baseDomains *[]string, maxRestrictionLevel RestrictionLevel) *IDNConfusableLabeler {
+	c := &IDNConfusableLabeler{
+		BaseDomains:         baseDomains,
+		MaxRestrictionLevel: maxRestrictionLevel,
+		baseLabels:          make(map[int][]idnBase),
+	}
+
+	seen := make(map[string]struct{})
+	for _, domain := range *baseDomains {
+		if _, present := seen[domain]; present {
+			continue
+		}
+		seen[domain] = struct{}{}
+
+		registrableLabel, err := baseRegistrableLabel(domain)
+		if err != nil {
+			log.Fatalf("Unable to extract eTLD+1 from %s: %s", domain, err.Error())
+		}
+		length := len([]rune(registrableLabel))
+		c.baseLabels[length] = append(c.baseLabels[length], idnBase{label: registrableLabel, domain: domain})
+	}
+
+	return c
+}
+
+// The registrable label of a base domain as a U-label
+func baseRegistrableLabel(domain string) (string, error) {
+	punyDomain, err := idna.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	registrableLabel, _, err := splitRegistrableLabel(punyDomain)
+	if err != nil {
+		return "", err
+	}
+	if uLabel, err := idna.ToUnicode(registrableLabel); err == nil {
+		registrableLabel = uLabel
+	}
+	return registrableLabel, nil
+}
+
+func (c *IDNConfusableLabeler) LabelDomain(domain string) map[DomainLabel][]string {
+	// labels without a source, like the blocklists, are kept
+	return DetailLabels(c.LabelDomainDetails(domain))
+}
+
+func (c *IDNConfusableLabeler) LabelDomainDetails(domain string) map[DomainLabel][]MatchDetail {
+	domainDetails := make(map[DomainLabel][]MatchDetail)
+
+	for _, label := range strings.Split(domain, ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+
+		uLabel, err := idna.ToUnicode(label)
+		if err != nil {
+			continue
+		}
+
+		level := GetRestrictionLevel(uLabel)
+		wholeScript := WholeScriptConfusable(uLabel)
+		if level <= c.MaxRestrictionLevel && !wholeScript {
+			continue
+		}
+
+		detail := MatchDetail{
+			ConfusableLabel: uLabel,
+			Reason:          level.String(),
+			Skeleton:        Skeleton(uLabel),
+		}
+		if wholeScript {
+			detail.Reason = "WHOLE_SCRIPT_CONFUSABLE"
+		}
+
+		matched := false
+		for _, base := range c.baseLabels[len([]rune(norm.NFD.String(uLabel)))] {
+			if ConfusableWith(uLabel, base.label) {
+				detail.BaseDomain = base.domain
+				domainDetails[IDN_CONFUSABLE] = append(domainDetails[IDN_CONFUSABLE], detail)
+				matched = true
+			}
+		}
+		if !matched {
+			domainDetails[IDN_CONFUSABLE] = append(domainDetails[IDN_CONFUSABLE], detail)
+		}
+	}
+
+	return domainDetails
+}
@@ -0,0 +1,163 @@
+package certificate_searcher
+
+import (
+	"golang.org/x/net/idna"
+	"reflect"
+	"testing"
+)
+
+func TestGetRestrictionLevel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  RestrictionLevel
+	}{
+		{"google", ASCII_ONLY},
+		{"paypal-1", ASCII_ONLY},
+		{"bücher", SINGLE_SCRIPT},
+		{"яндекс", SINGLE_SCRIPT},
+		{"日本", SINGLE_SCRIPT},
+		// Han and Hangul together are Korean
+		{"한국漢字", SINGLE_SCRIPT},
+		// Latin with Japanese, Korean or Han with Bopomofo
+		{"abcひらがな", HIGHLY_RESTRICTIVE},
+		{"abc漢字カナ", HIGHLY_RESTRICTIVE},
+		{"abc한국", HIGHLY_RESTRICTIVE},
+		{"abc漢한", HIGHLY_RESTRICTIVE},
+		{"abc中文ㄅ", HIGHLY_RESTRICTIVE},
+		// Latin with one other script
+		{"shopहिंदी", MODERATELY_RESTRICTIVE},
+		// Latin with Cyrillic or Greek, or any other mix
+		{"аррlе", MINIMALLY_RESTRICTIVE},
+		{"gοοgle", MINIMALLY_RESTRICTIVE},
+		{"abcカ한", MINIMALLY_RESTRICTIVE},
+		{"яα", MINIMALLY_RESTRICTIVE},
+	}
+
+	for _, test := range tests {
+		if got := GetRestrictionLevel(test.label); got != test.want {
+			t.Errorf("GetRestrictionLevel(%q) = %s, want %s", test.label, got, test.want)
+		}
+	}
+}
+
+func TestParseRestrictionLevel(t *testing.T) {
+	for level := ASCII_ONLY; level <= MINIMALLY_RESTRICTIVE; level++ {
+		if got, err := ParseRestrictionLevel(level.String()); err != nil || got != level {
+			t.Errorf("ParseRestrictionLevel(%q) = %s, %v, want %s", level.String(), got, err, level)
+		}
+	}
+	if got, err := ParseRestrictionLevel("highly_restrictive"); err != nil || got != HIGHLY_RESTRICTIVE {
+		t.Errorf("ParseRestrictionLevel(highly_restrictive) = %s, %v, want %s", got, err, HIGHLY_RESTRICTIVE)
+	}
+	if _, err := ParseRestrictionLevel("UNRESTRICTED"); err == nil {
+		t.Error("ParseRestrictionLevel(UNRESTRICTED) succeeded, want an error")
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"google", "google"},
+		{"PayPal", "paypal"},
+		{"аррlе", "apple"},
+		// Greek omicron is a letter first, not a zero
+		{"gοοgle", "google"},
+		{"ѕсоре", "scope"},
+		{"ⅼ", "l"},
+	}
+
+	for _, test := range tests {
+		if got := Skeleton(test.s); got != test.want {
+			t.Errorf("Skeleton(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestWholeScriptConfusable(t *testing.T) {
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"ѕсоре", true},
+		{"сосо", true},
+		// mixed with Latin, so not whole-script
+		{"аррlе", false},
+		{"яндекс", false},
+		{"google", false},
+		{"bücher", false},
+		{"日本", false},
+	}
+
+	for _, test := range tests {
+		if got := WholeScriptConfusable(test.label); got != test.want {
+			t.Errorf("WholeScriptConfusable(%q) = %t, want %t", test.label, got, test.want)
+		}
+	}
+}
+
+func TestConfusableWith(t *testing.T) {
+	tests := []struct {
+		uLabel, label string
+		want          bool
+	}{
+		{"аррlе", "apple", true},
+		{"gοοgle", "google", true},
+		{"gοοgle", "g00gle", true},
+		{"ⅼ", "i", true},
+		{"аррlе", "appel", false},
+		{"аррlе", "apples", false},
+	}
+
+	for _, test := range tests {
+		if got := ConfusableWith(test.uLabel, test.label); got != test.want {
+			t.Errorf("ConfusableWith(%q, %q) = %t, want %t", test.uLabel, test.label, got, test.want)
+		}
+	}
+}
+
+func TestIDNConfusableLabeler(t *testing.T) {
+	baseDomains := []string{"apple.com", "google.com", "paypal.com"}
+	labeler := NewIDNConfusableLabeler(&baseDomains, HIGHLY_RESTRICTIVE)
+
+	tests := []struct {
+		name string
+		want []MatchDetail
+	}{
+		{"аррlе.com", []MatchDetail{{BaseDomain: "apple.com", ConfusableLabel: "аррlе", Reason: "MINIMALLY_RESTRICTIVE", Skeleton: "apple"}}},
+		{"login.gοοgle.net", []MatchDetail{{BaseDomain: "google.com", ConfusableLabel: "gοοgle", Reason: "MINIMALLY_RESTRICTIVE", Skeleton: "google"}}},
+		// suspicious on its own, without a base domain
+		{"ѕсоре.com", []MatchDetail{{ConfusableLabel: "ѕсоре", Reason: "WHOLE_SCRIPT_CONFUSABLE", Skeleton: "scope"}}},
+		{"shopहिंदीไทย.com", []MatchDetail{{ConfusableLabel: "shopहिंदीไทย", Reason: "MINIMALLY_RESTRICTIVE", Skeleton: "shopहिंदीไทย"}}},
+		// within the allowed restriction level
+		{"abcひらがな.jp", nil},
+		{"яндекс.com", nil},
+		{"bücher.de", nil},
+		{"apple.com", nil},
+	}
+
+	for _, test := range tests {
+		name, err := idna.ToASCII(test.name)
+		if err != nil {
+			t.Fatalf("idna.ToASCII(%q): %s", test.name, err)
+		}
+
+		if got := labeler.LabelDomainDetails(name)[IDN_CONFUSABLE]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("LabelDomainDetails(%q) = %+v, want %+v", test.name, got, test.want)
+		}
+
+		wantLabels := make(map[DomainLabel][]string)
+		for _, detail := range test.want {
+			if detail.BaseDomain == "" {
+				if _, present := wantLabels[IDN_CONFUSABLE]; !present {
+					wantLabels[IDN_CONFUSABLE] = nil
+				}
+				continue
+			}
+			wantLabels[IDN_CONFUSABLE] = append(wantLabels[IDN_CONFUSABLE], detail.BaseDomain)
+		}
+		if got := labeler.LabelDomain(name); !reflect.DeepEqual(got, wantLabels) {
+			t.Errorf("LabelDomain(%q) = %v, want %v", test.name, got, wantLabels)
+		}
+	}
+}
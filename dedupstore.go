@@ -0,0 +1,275 @@
+package certificate_searcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/cespare/xxhash"
+	"github.com/steakknife/bloomfilter"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Remembers which certificates CertStats has counted. Not safe for concurrent use.
+type DedupStore interface {
+	// Records key, returning false if it was already present
+	Add(key []byte) (bool, error)
+	// Persists the store if it has a file and releases it
+	Close() error
+}
+
+// Kinds of DedupStore
+const (
+	// Bloom filter in memory, optionally saved to and loaded from a file; may rarely report a new key as seen
+	BLOOM_DEDUP_STORE string = "bloom"
+	// Exact set of keys in a bbolt database on disk
+	BOLT_DEDUP_STORE string = "bolt"
+)
+
+const DefaultDedupCapacity uint64 = 100000000
+const DefaultDedupFalsePositiveRate float64 = 0.000000001
+
+/*
+Opens a DedupStore of kind at path. A bloom store starts from the filter saved at path if there is
+one, otherwise from an empty filter sized for capacity keys at fpRate, and is saved back to path
+on Close unless path is empty. A bolt store needs a path and ignores capacity and fpRate.
+*/
+func OpenDedupStore(kind string, path string, capacity uint64, fpRate float64) (DedupStore, error) {
+	switch kind {
+	case BLOOM_DEDUP_STORE, "":
+		return OpenBloomDedupStore(path, capacity, fpRate)
+	case BOLT_DEDUP_STORE:
+		if path == "" {
+			return nil, fmt.Errorf("a %s dedup store needs a file", BOLT_DEDUP_STORE)
+		}
+		return OpenBoltDedupStore(path)
+	default:
+		return nil, fmt.Errorf("unknown dedup store %s, expected %s or %s", kind, BLOOM_DEDUP_STORE, BOLT_DEDUP_STORE)
+	}
+}
+
+type BloomDedupStore struct {
+	Filter *bloomfilter.Filter
+	// Where Close saves the filter, if set
+	Path string
+	// Whether Filter was read from Path, keeping the size it was created with
+	Loaded bool
+}
+
+func NewBloomDedupStore(capacity uint64, fpRate float64) (*BloomDedupStore, error) {
+	if capacity == 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("bloom filter needs a positive capacity and a false-positive rate between 0 and 1, got %d and %g", capacity, fpRate)
+	}
+	bf, err := bloomfilter.NewOptimal(capacity, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	return &BloomDedupStore{Filter: bf}, nil
+}
+
+// Loads the filter saved at path, or creates an empty one if there is none yet. A loaded filter
+// keeps the size it was created with, whatever capacity and fpRate are.
+func OpenBloomDedupStore(path string, capacity uint64, fpRate float64) (*BloomDedupStore, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err == nil {
+			bf, err := readBloomFilter(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load bloom filter %s: %s", path, err.Error())
+			}
+			return &BloomDedupStore{Filter: bf, Path: path, Loaded: true}, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	store, err := NewBloomDedupStore(capacity, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	store.Path = path
+	return store, nil
+}
+
+func (b *BloomDedupStore) Add(key []byte) (bool, error) {
+	hash := xxhash.New()
+	hash.Write(key)
+
+	if b.Filter.Contains(hash) {
+		return false, nil
+	}
+	b.Filter.Add(hash)
+	return true, nil
+}
+
+// Keys added so far
+func (b *BloomDedupStore) Len() uint64 {
+	return b.Filter.N()
+}
+
+// Chance that a key not yet added is reported as seen, with the keys added so far
+func (b *BloomDedupStore) FalsePositiveRate() float64 {
+	m, k, n := float64(b.Filter.M()), float64(b.Filter.K()), float64(b.Filter.N())
+	return math.Pow(1-math.Exp(-k*n/m), k)
+}
+
+// Keys the filter holds before its false-positive rate passes fpRate
+func (b *BloomDedupStore) Capacity(fpRate float64) uint64 {
+	m, k := float64(b.Filter.M()), float64(b.Filter.K())
+	return uint64(-m / k * math.Log(1-math.Pow(fpRate, 1/k)))
+}
+
+func (b *BloomDedupStore) Close() error {
+	if b.Path == "" {
+		return nil
+	}
+	return writeBloomFilter(b.Path, b.Filter)
+}
+
+func readBloomFilter(path string) (*bloomfilter.Filter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	filterBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bf := &bloomfilter.Filter{}
+	if err := bf.UnmarshalBinary(filterBytes); err != nil {
+		return nil, err
+	}
+	return bf, nil
+}
+
+// Writes the gzipped filter to a temporary file and renames it over path, so a crash keeps the previous filter
+func writeBloomFilter(path string, bf *bloomfilter.Filter) error {
+	filterBytes, err := bf.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(filterBytes); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(compressed.Bytes()); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+var boltDedupBucket = []byte("certificates")
+
+// Keys added in one transaction before it is committed
+const boltDedupBatchSize = 10000
+
+/*
+Exact DedupStore keeping every key in a bbolt database, so it needs no capacity up front and
+survives restarts. Lookups and new keys share one write transaction, which sees its own
+uncommitted keys and is committed every boltDedupBatchSize new keys; Close commits the last one.
+*/
+type BoltDedupStore struct {
+	DB          *bolt.DB
+	tx          *bolt.Tx
+	uncommitted int
+}
+
+func OpenBoltDedupStore(path string) (*BoltDedupStore, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDedupBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDedupStore{DB: db}, nil
+}
+
+func (b *BoltDedupStore) Add(key []byte) (bool, error) {
+	if b.tx == nil {
+		tx, err := b.DB.Begin(true)
+		if err != nil {
+			return false, err
+		}
+		b.tx = tx
+	}
+
+	bucket := b.tx.Bucket(boltDedupBucket)
+	if bucket.Get(key) != nil {
+		return false, nil
+	}
+	// bbolt keeps the key until the transaction is committed
+	if err := bucket.Put(append([]byte(nil), key...), []byte{}); err != nil {
+		return false, err
+	}
+
+	b.uncommitted++
+	if b.uncommitted >= boltDedupBatchSize {
+		if err := b.commit(); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (b *BoltDedupStore) commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Commit()
+	b.tx = nil
+	b.uncommitted = 0
+	return err
+}
+
+// Keys stored so far
+func (b *BoltDedupStore) Len() uint64 {
+	if b.tx != nil {
+		return uint64(b.tx.Bucket(boltDedupBucket).Stats().KeyN + b.uncommitted)
+	}
+	stored := 0
+	b.DB.View(func(tx *bolt.Tx) error {
+		stored = tx.Bucket(boltDedupBucket).Stats().KeyN
+		return nil
+	})
+	return uint64(stored)
+}
+
+func (b *BoltDedupStore) Close() error {
+	err := b.commit()
+	if closeErr := b.DB.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
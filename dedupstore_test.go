@@ -0,0 +1,174 @@
+package certificate_searcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Adds keys, checking which are reported new
+func addKeys(t *testing.T, store DedupStore, keys []string, want []bool) {
+	for idx, key := range keys {
+		added, err := store.Add([]byte(key))
+		if err != nil {
+			t.Fatalf("Add(%s): %s", key, err)
+		}
+		if added != want[idx] {
+			t.Errorf("Add(%s) = %v, want %v", key, added, want[idx])
+		}
+	}
+}
+
+func TestOpenDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		kind string
+		path string
+		// substring of the error, empty if the store opens
+		wantErr string
+	}{
+		{"", "", ""},
+		{BLOOM_DEDUP_STORE, filepath.Join(dir, "seen.bloom"), ""},
+		{BOLT_DEDUP_STORE, filepath.Join(dir, "seen.db"), ""},
+		{BOLT_DEDUP_STORE, "", "needs a file"},
+		{"redis", "", "unknown dedup store redis"},
+	}
+
+	for _, test := range tests {
+		store, err := OpenDedupStore(test.kind, test.path, 1000, 0.001)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("OpenDedupStore(%q, %q) error = %v, want it to mention %s", test.kind, test.path, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("OpenDedupStore(%q, %q): %s", test.kind, test.path, err)
+			continue
+		}
+		if err := store.Close(); err != nil {
+			t.Errorf("OpenDedupStore(%q, %q).Close(): %s", test.kind, test.path, err)
+		}
+	}
+
+	badParameters := [][2]float64{{0, 0.001}, {1000, 0}, {1000, 1}}
+	for _, parameters := range badParameters {
+		if _, err := NewBloomDedupStore(uint64(parameters[0]), parameters[1]); err == nil {
+			t.Errorf("NewBloomDedupStore(%v, %v) succeeded, want an error", parameters[0], parameters[1])
+		}
+	}
+}
+
+func TestBloomDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.bloom")
+
+	store, err := OpenBloomDedupStore(path, 1000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Loaded {
+		t.Error("a new filter is marked Loaded")
+	}
+	addKeys(t, store, []string{"a", "b", "a", "c", "b"}, []bool{true, true, false, true, false})
+	if store.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", store.Len())
+	}
+
+	// the filter is sized for the requested capacity and rate
+	if capacity := store.Capacity(0.001); capacity < 990 || capacity > 1100 {
+		t.Errorf("Capacity(0.001) = %d, want about 1000", capacity)
+	}
+	if rate := store.FalsePositiveRate(); rate <= 0 || rate > 1e-6 {
+		t.Errorf("FalsePositiveRate() with 3 keys = %g, want far below 0.001", rate)
+	}
+	for idx := 0; idx < 997; idx++ {
+		store.Add([]byte(fmt.Sprintf("key%d", idx)))
+	}
+	if rate := store.FalsePositiveRate(); math.Abs(rate-0.001) > 0.0002 {
+		t.Errorf("FalsePositiveRate() at capacity = %g, want about 0.001", rate)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a saved filter keeps its keys and size, whatever it is reopened with
+	reopened, err := OpenBloomDedupStore(path, 10, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Loaded {
+		t.Error("a saved filter is not marked Loaded")
+	}
+	if reopened.Filter.M() != store.Filter.M() || reopened.Len() != store.Len() {
+		t.Errorf("reopened filter has %d bits and %d keys, want %d and %d", reopened.Filter.M(), reopened.Len(), store.Filter.M(), store.Len())
+	}
+	addKeys(t, reopened, []string{"a", "c", "key996"}, []bool{false, false, false})
+
+	if err := ioutil.WriteFile(path, []byte("not gzipped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBloomDedupStore(path, 1000, 0.001); err == nil {
+		t.Error("OpenBloomDedupStore() of a corrupt file succeeded")
+	}
+}
+
+func TestBoltDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.db")
+
+	store, err := OpenBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 0 {
+		t.Errorf("Len() of a new store = %d, want 0", store.Len())
+	}
+	addKeys(t, store, []string{"a", "b", "a", "c", "b"}, []bool{true, true, false, true, false})
+	if store.Len() != 3 {
+		t.Errorf("Len() before a commit = %d, want 3", store.Len())
+	}
+
+	// past a batch, keys are committed and still seen
+	for idx := 0; idx < boltDedupBatchSize; idx++ {
+		if _, err := store.Add([]byte(fmt.Sprintf("key%d", idx))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addKeys(t, store, []string{"a", "key0", "d"}, []bool{false, false, true})
+	want := uint64(boltDedupBatchSize + 4)
+	if store.Len() != want {
+		t.Errorf("Len() after a commit = %d, want %d", store.Len(), want)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenBoltDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != want {
+		t.Errorf("Len() after reopening = %d, want %d", reopened.Len(), want)
+	}
+	addKeys(t, reopened, []string{"a", "d", "key9999", "e"}, []bool{false, false, false, true})
+}
@@ -4,17 +4,13 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"github.com/src-d/go-oniguruma"
 	"golang.org/x/net/idna"
-	"golang.org/x/net/publicsuffix"
 	"log"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 	"unicode"
 	"unicode/utf8"
 )
@@ -56,29 +52,79 @@ const (
 	SSL_BLACKLIST
 	// Google SafeBrowsing
 	GOOGLE_SAFEBROWSING
+
+	// Labels below were added later; new labels go at the end so persisted values keep their meaning
+
+	// Unicode Technical Standard #39: Unicode Security Mechanisms
+	IDN_CONFUSABLE
+	// Damerau-Levenshtein distance to a base domain's registrable part
+	EDIT_DISTANCE
+	// dnstwist - https://github.com/elceef/dnstwist
+	TYPOSQUATTING_CHAR_INSERTION
+	TYPOSQUATTING_VOWEL_SWAP
+	TYPOSQUATTING_HYPHEN_INSERTION
+	TYPOSQUATTING_HYPHEN_REMOVAL
+	TYPOSQUATTING_NUMERAL_SWAP
+	TYPOSQUATTING_DOT_INSERTION
+	// Missing dots between subdomain labels and before the public suffix
+	TYPOSQUATTING_MISSING_DOT_SUBDOMAIN
+	TYPOSQUATTING_MISSING_DOT_SUFFIX
+	// Wrong TLD from the PSL private section
+	WRONGTLD_PRIVATE
 )
 
+var domainLabelNames = [...]string{
+	"Unlabeled",
+	"TYPOSQUATTING_MISSING_DOT",
+	"TYPOSQUATTING_CHAR_OMISSION",
+	"TYPOSQUATTING_CHAR_PERMUTATION",
+	"TYPOSQUATTING_CHAR_SUBSTITUTION",
+	"TYPOSQUATTING_CHAR_DUPLICATION",
+	"TARGET_EMBEDDING",
+	"COMBOSQUATTING",
+	"HOMOGRAPH",
+	"WRONGTLD",
+	"BITSQUATTING",
+	"PHISHTANK",
+	"SSL_BLACKLIST",
+	"GOOGLE_SAFEBROWSING",
+	"IDN_CONFUSABLE",
+	"EDIT_DISTANCE",
+	"TYPOSQUATTING_CHAR_INSERTION",
+	"TYPOSQUATTING_VOWEL_SWAP",
+	"TYPOSQUATTING_HYPHEN_INSERTION",
+	"TYPOSQUATTING_HYPHEN_REMOVAL",
+	"TYPOSQUATTING_NUMERAL_SWAP",
+	"TYPOSQUATTING_DOT_INSERTION",
+	"TYPOSQUATTING_MISSING_DOT_SUBDOMAIN",
+	"TYPOSQUATTING_MISSING_DOT_SUFFIX",
+	"WRONGTLD_PRIVATE",
+}
+
 func (dl DomainLabel) String() string {
-	return [...]string{
-		"Unlabeled",
-		"TYPOSQUATTING_MISSING_DOT",
-		"TYPOSQUATTING_CHAR_OMISSION",
-		"TYPOSQUATTING_CHAR_PERMUTATION",
-		"TYPOSQUATTING_CHAR_SUBSTITUTION",
-		"TYPOSQUATTING_CHAR_DUPLICATION",
-		"TARGET_EMBEDDING",
-		"COMBOSQUATTING",
-		"HOMOGRAPH",
-		"WRONGTLD",
-		"BITSQUATTING",
-		"PHISHTANK",
-		"SSL_BLACKLIST",
-		"GOOGLE_SAFEBROWSING",
-	}[dl]
+	return domainLabelNames[dl]
+}
+
+// Every label, in numeric order
+func DomainLabels() []DomainLabel {
+	labels := make([]DomainLabel, 0, len(domainLabelNames))
+	for dl := range domainLabelNames {
+		labels = append(labels, DomainLabel(dl))
+	}
+	return labels
+}
+
+// The label named name, as printed by String
+func ParseDomainLabel(name string) (DomainLabel, error) {
+	for dl, labelName := range domainLabelNames {
+		if labelName == name {
+			return DomainLabel(dl), nil
+		}
+	}
+	return UNLABELED, fmt.Errorf("unknown domain label %s", name)
 }
 
 func (dl *DomainLabel) MarshalJSON() ([]byte, error) {
-	fmt.Println("Marshalling %d", dl)
 	return []byte(dl.String()), nil
 }
 
@@ -87,6 +133,56 @@ type DomainLabeler interface {
 	LabelDomain(domain string) map[DomainLabel][]string
 }
 
+// What a labeler knows about one base domain it matched, beyond the base domain itself
+type MatchDetail struct {
+	BaseDomain      string   `json:"base_domain"`
+	KeyboardLayouts []string `json:"keyboard_layouts,omitempty"`
+	EditDistance    int      `json:"edit_distance,omitempty"`
+	// Estimated probability that a user typing BaseDomain lands on the labeled name
+	Likelihood float64 `json:"likelihood,omitempty"`
+	// For IDN_CONFUSABLE: the flagged U-label, why it was flagged and its UTS #39 skeleton
+	ConfusableLabel string `json:"confusable_label,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	Skeleton        string `json:"skeleton,omitempty"`
+}
+
+type LabelsDetails map[DomainLabel][]MatchDetail
+
+func (ld LabelsDetails) MarshalJSON() ([]byte, error) {
+	stringLabels := make(map[string][]MatchDetail)
+	for dl, slice := range ld {
+		stringLabels[dl.String()] = slice
+	}
+
+	return json.Marshal(stringLabels)
+}
+
+// Labelers that can explain their matches implement this in addition to LabelDomain
+type DetailedDomainLabeler interface {
+	DomainLabeler
+	LabelDomainDetails(domain string) map[DomainLabel][]MatchDetail
+}
+
+/*
+The labels LabelDomain returns for these details: each label with the base domains of its
+details, in order. A label whose details have no base domain is kept with no sources.
+*/
+func DetailLabels(details map[DomainLabel][]MatchDetail) map[DomainLabel][]string {
+	domainLabels := make(map[DomainLabel][]string)
+	for label, labelDetails := range details {
+		for _, detail := range labelDetails {
+			if detail.BaseDomain == "" {
+				if _, present := domainLabels[label]; !present {
+					domainLabels[label] = nil
+				}
+				continue
+			}
+			domainLabels[label] = append(domainLabels[label], detail.BaseDomain)
+		}
+	}
+	return domainLabels
+}
+
 type BaseDomains map[string]struct{}
 type Mutation string
 type MutatedDomains map[Mutation]BaseDomains
@@ -102,34 +198,169 @@ func AddMutation(mutatedDomains MutatedDomains, mutation Mutation, baseDomain st
 	mutatedDomains[mutation][baseDomain] = struct{}{}
 }
 
+/*
+Generates single-edit typos of every base domain into a MutationIndex. MissingDotSuffixLabels is
+the exception to exact matching: it is keyed by the registrable label with the public suffix
+appended sans dots (googlecom), and matches that label registered under any suffix (googlecom.xyz).
+*/
 type TypoSquattingLabeler struct {
-	BaseDomains             *[]string
-	MissingDotDomains       MutatedDomains
-	CharOmissionDomains     MutatedDomains
-	CharPermutationDomains  MutatedDomains
-	CharSubstitutionDomains MutatedDomains
-	CharDuplicationDomains  MutatedDomains
-	AllMutatedDomains       MutatedDomains
+	BaseDomains            *[]string
+	MissingDotSuffixLabels MutatedDomains
+	Layouts                []*KeyboardLayout
+	Index                  *MutationIndex
+	// Used to score matches; nil means DefaultTypoPriors
+	Priors TypoPriors
+}
+
+// Labels produced by TypoSquattingLabeler, all looked up in its MutationIndex
+var TypoSquattingLabels = map[DomainLabel]struct{}{
+	TYPOSQUATTING_MISSING_DOT:           {},
+	TYPOSQUATTING_MISSING_DOT_SUBDOMAIN: {},
+	TYPOSQUATTING_MISSING_DOT_SUFFIX:    {},
+	TYPOSQUATTING_CHAR_OMISSION:         {},
+	TYPOSQUATTING_CHAR_PERMUTATION:      {},
+	TYPOSQUATTING_CHAR_SUBSTITUTION:     {},
+	TYPOSQUATTING_CHAR_DUPLICATION:      {},
+	TYPOSQUATTING_CHAR_INSERTION:        {},
+	TYPOSQUATTING_VOWEL_SWAP:            {},
+	TYPOSQUATTING_HYPHEN_INSERTION:      {},
+	TYPOSQUATTING_HYPHEN_REMOVAL:        {},
+	TYPOSQUATTING_NUMERAL_SWAP:          {},
+	TYPOSQUATTING_DOT_INSERTION:         {},
+}
+
+const typoVowels = "aeiou"
+
+// Letters and the numerals commonly typed in their place
+var numeralSwaps = map[rune][]rune{
+	'o': {'0'},
+	'0': {'o'},
+	'l': {'1'},
+	'i': {'1'},
+	'1': {'l', 'i'},
+	'e': {'3'},
+	'3': {'e'},
+	'a': {'4'},
+	'4': {'a'},
+	's': {'5'},
+	'5': {'s'},
+	't': {'7'},
+	'7': {'t'},
+	'b': {'8'},
+	'8': {'b'},
+	'g': {'9'},
+	'9': {'g'},
+}
+
+func replaceRune(runes []rune, idx int, replacement ...rune) string {
+	tempSlice := append(make([]rune, 0), runes[:idx]...)
+	tempSlice = append(tempSlice, replacement...)
+	return string(append(tempSlice, runes[idx+1:]...))
+}
+
+func insertRune(runes []rune, idx int, r rune) string {
+	tempSlice := append(make([]rune, 0), runes[:idx]...)
+	tempSlice = append(tempSlice, r)
+	return string(append(tempSlice, runes[idx:]...))
+}
+
+// Whether a hyphen or dot may be placed at idx without creating an empty or hyphen-edged label
+func innerLabelPosition(runes []rune, idx int) bool {
+	if idx <= 0 || idx >= len(runes) {
+		return false
+	}
+	return validDomainChar(runes[idx-1]) && runes[idx-1] != '.' && runes[idx-1] != '-' &&
+		validDomainChar(runes[idx]) && runes[idx] != '.' && runes[idx] != '-'
+}
+
+// Names of the keyboard layouts in which mutation is a substitution or insertion typo of baseDomain
+func (t *TypoSquattingLabeler) MutationLayouts(mutation Mutation, baseDomain string) []string {
+	var mask uint32
+	for _, posting := range t.Index.Lookup(string(mutation)) {
+		if t.Index.BaseDomains[posting.BaseDomain] == baseDomain {
+			mask |= posting.LayoutMask
+		}
+	}
+
+	layouts := make([]string, 0)
+	for idx, layout := range t.Layouts {
+		if mask&(1<<uint(idx)) != 0 {
+			layouts = append(layouts, layout.Name)
+		}
+	}
+	return layouts
 }
 
-func NewTypoSquattingLabeler(baseDomains *[]string) *TypoSquattingLabeler {
+// Labeler over an index that already holds the typos of baseDomains, such as one loaded from a file
+func NewTypoSquattingLabelerFromIndex(baseDomains *[]string, layouts []*KeyboardLayout, index *MutationIndex) *TypoSquattingLabeler {
+	if len(layouts) == 0 {
+		layouts = []*KeyboardLayout{QWERTY}
+	}
+	if len(layouts) > 32 {
+		log.Fatalf("At most 32 keyboard layouts are supported, got %d", len(layouts))
+	}
+
 	tsl := &TypoSquattingLabeler{
-		BaseDomains:             baseDomains,
-		MissingDotDomains:       make(MutatedDomains),
-		CharOmissionDomains:     make(MutatedDomains),
-		CharPermutationDomains:  make(MutatedDomains),
-		CharSubstitutionDomains: make(MutatedDomains),
-		CharDuplicationDomains:  make(MutatedDomains),
-		AllMutatedDomains:       make(MutatedDomains),
+		BaseDomains:            baseDomains,
+		MissingDotSuffixLabels: make(MutatedDomains),
+		Layouts:                layouts,
+		Index:                  index,
+	}
+
+	for _, domain := range *baseDomains {
+		publicSuffix, _ := PublicSuffix(domain)
+		domainSansSuffix := strings.TrimSuffix(domain, "."+publicSuffix)
+		if domainSansSuffix != domain {
+			registrableLabel := domainSansSuffix[strings.LastIndex(domainSansSuffix, ".")+1:]
+			suffixLabel := registrableLabel + strings.ReplaceAll(publicSuffix, ".", "")
+			AddMutation(tsl.MissingDotSuffixLabels, Mutation(suffixLabel), domain)
+		}
+	}
+
+	return tsl
+}
+
+/*
+Substitution and insertion typos are generated for every keyboard layout given (QWERTY if none),
+and the index records which layouts explain each one. Mutations go into builder so they can share
+one index with other labelers; the labeler is usable once builder.Build() has run. With a nil
+builder the labeler builds its own index.
+*/
+func NewTypoSquattingLabeler(baseDomains *[]string, layouts []*KeyboardLayout, builder *MutationIndexBuilder) *TypoSquattingLabeler {
+	ownIndex := builder == nil
+	if ownIndex {
+		builder = NewMutationIndexBuilder()
 	}
 
+	tsl := NewTypoSquattingLabelerFromIndex(baseDomains, layouts, builder.Index())
+
 	for _, domain := range *baseDomains {
 		if strings.HasPrefix(domain, "www.") {
-			AddMutation(tsl.MissingDotDomains, Mutation("www"+domain[4:]), domain)
-			AddMutation(tsl.AllMutatedDomains, Mutation("www"+domain[4:]), domain)
+			builder.Add(TYPOSQUATTING_MISSING_DOT, "www"+domain[4:], domain, 0)
+		} else {
+			builder.Add(TYPOSQUATTING_MISSING_DOT, "www"+domain, domain, 0)
+		}
+
+		publicSuffix, _ := PublicSuffix(domain)
+		domainSansSuffix := strings.TrimSuffix(domain, "."+publicSuffix)
+		for idx := range domainSansSuffix {
+			if domainSansSuffix[idx] != '.' || (idx == 3 && strings.HasPrefix(domain, "www.")) {
+				continue
+			}
+
+			missingDotDomain := domain[:idx] + domain[idx+1:]
+			builder.Add(TYPOSQUATTING_MISSING_DOT_SUBDOMAIN, missingDotDomain, domain, 0)
+		}
+
+		// A multi-label suffix leaves a registrable name after dropping the dot (googleco.uk)
+		if domainSansSuffix != domain && strings.Contains(publicSuffix, ".") {
+			missingDotDomain := domainSansSuffix + publicSuffix
+			builder.Add(TYPOSQUATTING_MISSING_DOT_SUFFIX, missingDotDomain, domain, 0)
 		}
 
 		runeDomain := []rune(domain)
+		// vowel swaps, hyphens and dots only go before the public suffix, as in dnstwist
+		suffixStart := utf8.RuneCountInString(domainSansSuffix)
 
 		for idx, r := range runeDomain {
 			if !unicode.IsLetter(r) {
@@ -138,8 +369,7 @@ func NewTypoSquattingLabeler(baseDomains *[]string) *TypoSquattingLabeler {
 
 			tempSlice := append(make([]rune, 0), runeDomain[:idx]...)
 			omittedCharDomain := string(append(tempSlice, runeDomain[idx+1:]...))
-			AddMutation(tsl.CharOmissionDomains, Mutation(omittedCharDomain), domain)
-			AddMutation(tsl.AllMutatedDomains, Mutation(omittedCharDomain), domain)
+			builder.Add(TYPOSQUATTING_CHAR_OMISSION, omittedCharDomain, domain, 0)
 		}
 
 		for idx, char := range runeDomain {
@@ -156,8 +386,7 @@ func NewTypoSquattingLabeler(baseDomains *[]string) *TypoSquattingLabeler {
 			tempSlice := append(make([]rune, 0), runeDomain[:idx]...)
 			tempSlice = append(tempSlice, runeDomain[idx+1], runeDomain[idx])
 			permutedCharDomain := string(append(tempSlice, runeDomain[idx+2:]...))
-			AddMutation(tsl.CharPermutationDomains, Mutation(permutedCharDomain), domain)
-			AddMutation(tsl.AllMutatedDomains, Mutation(permutedCharDomain), domain)
+			builder.Add(TYPOSQUATTING_CHAR_PERMUTATION, permutedCharDomain, domain, 0)
 		}
 
 		for idx, char := range runeDomain {
@@ -168,164 +397,290 @@ func NewTypoSquattingLabeler(baseDomains *[]string) *TypoSquattingLabeler {
 			tempSlice := append(make([]rune, 0), runeDomain[:idx]...)
 			tempSlice = append(tempSlice, runeDomain[idx])
 			duplicatedCharDomain := string(append(tempSlice, runeDomain[idx:]...))
-			AddMutation(tsl.CharDuplicationDomains, Mutation(duplicatedCharDomain), domain)
-			AddMutation(tsl.AllMutatedDomains, Mutation(duplicatedCharDomain), domain)
+			builder.Add(TYPOSQUATTING_CHAR_DUPLICATION, duplicatedCharDomain, domain, 0)
 		}
 
-		for idx, char := range runeDomain {
-			if !QwertyAlphanumeric(char) {
+		for layoutIdx, layout := range tsl.Layouts {
+			for idx, char := range runeDomain {
+				// runes missing from the layout, like the dot, have no neighbours to slip to
+				adjacentChars, err := layout.AdjacentRunes(char)
+				if err != nil {
+					continue
+				}
+
+				for _, adjacentChar := range adjacentChars {
+					tempSlice := append(make([]rune, 0), runeDomain[:idx]...)
+					tempSlice = append(tempSlice, adjacentChar)
+					substitutedCharDomain := string(append(tempSlice, runeDomain[idx+1:]...))
+					builder.Add(TYPOSQUATTING_CHAR_SUBSTITUTION, substitutedCharDomain, domain, 1<<uint(layoutIdx))
+				}
+			}
+
+			for idx, char := range runeDomain {
+				adjacentChars, err := layout.AdjacentRunes(char)
+				if err != nil {
+					continue
+				}
+
+				for _, adjacentChar := range adjacentChars {
+					for _, insertIdx := range []int{idx, idx + 1} {
+						insertedCharDomain := insertRune(runeDomain, insertIdx, adjacentChar)
+						builder.Add(TYPOSQUATTING_CHAR_INSERTION, insertedCharDomain, domain, 1<<uint(layoutIdx))
+					}
+				}
+			}
+		}
+
+		for idx, char := range runeDomain[:suffixStart] {
+			if !strings.ContainsRune(typoVowels, char) {
 				continue
 			}
 
-			adjacentChars, err := QwertyAdjacentRunes(char)
-			if err != nil {
+			for _, vowel := range typoVowels {
+				if vowel == char {
+					continue
+				}
+				vowelSwappedDomain := replaceRune(runeDomain, idx, vowel)
+				builder.Add(TYPOSQUATTING_VOWEL_SWAP, vowelSwappedDomain, domain, 0)
+			}
+		}
+
+		for idx := range runeDomain[:suffixStart] {
+			if !innerLabelPosition(runeDomain, idx) {
 				continue
 			}
 
-			for _, adjacentChar := range adjacentChars {
-				tempSlice := append(make([]rune, 0), runeDomain[:idx]...)
-				tempSlice = append(tempSlice, adjacentChar)
-				substitutedCharDomain := string(append(tempSlice, runeDomain[idx+1:]...))
-				AddMutation(tsl.CharSubstitutionDomains, Mutation(substitutedCharDomain), domain)
-				AddMutation(tsl.AllMutatedDomains, Mutation(substitutedCharDomain), domain)
+			hyphenatedDomain := insertRune(runeDomain, idx, '-')
+			builder.Add(TYPOSQUATTING_HYPHEN_INSERTION, hyphenatedDomain, domain, 0)
+
+			dotInsertedDomain := insertRune(runeDomain, idx, '.')
+			builder.Add(TYPOSQUATTING_DOT_INSERTION, dotInsertedDomain, domain, 0)
+		}
+
+		for idx, char := range runeDomain {
+			if char != '-' {
+				continue
+			}
+
+			unhyphenatedDomain := replaceRune(runeDomain, idx)
+			builder.Add(TYPOSQUATTING_HYPHEN_REMOVAL, unhyphenatedDomain, domain, 0)
+		}
+
+		for idx, char := range runeDomain {
+			for _, swap := range numeralSwaps[char] {
+				numeralSwappedDomain := replaceRune(runeDomain, idx, swap)
+				builder.Add(TYPOSQUATTING_NUMERAL_SWAP, numeralSwappedDomain, domain, 0)
 			}
 		}
 	}
 
+	if ownIndex {
+		builder.Build()
+	}
+
 	return tsl
 }
 
 func (t *TypoSquattingLabeler) LabelDomain(domain string) map[DomainLabel][]string {
-	domainLabels := make(map[DomainLabel][]string)
-	mutation := Mutation(domain)
+	domainLabels := t.Index.LabelMutation(domain, TypoSquattingLabels)
 
-	if _, present := t.MissingDotDomains[mutation]; present {
-		domainLabels[TYPOSQUATTING_MISSING_DOT] = make([]string, 0)
-		for k, _ := range t.MissingDotDomains[mutation] {
-			domainLabels[TYPOSQUATTING_MISSING_DOT] = append(domainLabels[TYPOSQUATTING_MISSING_DOT], k)
-		}
-	}
-	if _, present := t.CharOmissionDomains[mutation]; present {
-		domainLabels[TYPOSQUATTING_CHAR_OMISSION] = make([]string, 0)
-		for k, _ := range t.CharOmissionDomains[mutation] {
-			domainLabels[TYPOSQUATTING_CHAR_OMISSION] = append(domainLabels[TYPOSQUATTING_CHAR_OMISSION], k)
-		}
-	}
-	if _, present := t.CharPermutationDomains[mutation]; present {
-		domainLabels[TYPOSQUATTING_CHAR_PERMUTATION] = make([]string, 0)
-		for k, _ := range t.CharPermutationDomains[mutation] {
-			domainLabels[TYPOSQUATTING_CHAR_PERMUTATION] = append(domainLabels[TYPOSQUATTING_CHAR_PERMUTATION], k)
-		}
-	}
-	if _, present := t.CharDuplicationDomains[mutation]; present {
-		domainLabels[TYPOSQUATTING_CHAR_DUPLICATION] = make([]string, 0)
-		for k, _ := range t.CharDuplicationDomains[mutation] {
-			domainLabels[TYPOSQUATTING_CHAR_DUPLICATION] = append(domainLabels[TYPOSQUATTING_CHAR_DUPLICATION], k)
+	if eTLDplus1, err := EffectiveTLDPlusOne(strings.TrimPrefix(domain, "*.")); err == nil {
+		registrableLabel := Mutation(eTLDplus1[:strings.Index(eTLDplus1, ".")])
+		for k, _ := range t.MissingDotSuffixLabels[registrableLabel] {
+			domainLabels[TYPOSQUATTING_MISSING_DOT_SUFFIX] = append(domainLabels[TYPOSQUATTING_MISSING_DOT_SUFFIX], k)
 		}
 	}
-	if _, present := t.CharSubstitutionDomains[mutation]; present {
-		domainLabels[TYPOSQUATTING_CHAR_SUBSTITUTION] = make([]string, 0)
-		for k, _ := range t.CharSubstitutionDomains[mutation] {
-			domainLabels[TYPOSQUATTING_CHAR_SUBSTITUTION] = append(domainLabels[TYPOSQUATTING_CHAR_SUBSTITUTION], k)
+
+	return domainLabels
+}
+
+func (t *TypoSquattingLabeler) LabelDomainDetails(domain string) map[DomainLabel][]MatchDetail {
+	domainDetails := make(map[DomainLabel][]MatchDetail)
+	for label, baseDomains := range t.LabelDomain(domain) {
+		for _, baseDomain := range baseDomains {
+			detail := MatchDetail{
+				BaseDomain: baseDomain,
+				Likelihood: TypoLikelihood(label, baseDomain, domain, t.Layouts, t.Priors),
+			}
+			if label == TYPOSQUATTING_CHAR_SUBSTITUTION || label == TYPOSQUATTING_CHAR_INSERTION {
+				detail.KeyboardLayouts = t.MutationLayouts(Mutation(domain), baseDomain)
+			}
+			domainDetails[label] = append(domainDetails[label], detail)
 		}
 	}
 
-	return domainLabels
+	return domainDetails
 }
 
 func (t *TypoSquattingLabeler) GetMutations() MutatedDomains {
-	return t.AllMutatedDomains
+	return t.Index.Mutations(TypoSquattingLabels)
 }
 
+/*
+Labels names embedding a base domain as a whole run of labels followed by more of the name,
+e.g. google.com.evil.net or login-google.com-secure.net. The base domain must start the name or
+follow a '-' or '.', and must be followed by a '-' or '.'. Safe to share between goroutines.
+*/
 type TargetEmbeddingLabeler struct {
-	BaseDomains   *[]string
-	CombinedRegex *rubex.Regexp
-	RegexString   string
-	Mux           sync.Mutex
+	BaseDomains *[]string
+	Matcher     *AhoCorasick
 }
 
 func NewTargetEmbeddingLabeler(baseDomains *[]string) *TargetEmbeddingLabeler {
-	tel := &TargetEmbeddingLabeler{
+	return &TargetEmbeddingLabeler{
 		BaseDomains: baseDomains,
+		Matcher:     NewAhoCorasick(*baseDomains),
 	}
+}
 
-	regexExpressions := make([]string, len(*baseDomains))
-	for idx, domain := range *baseDomains {
-		regexDomain := rubex.QuoteMeta(domain)
-		regexExpressions[idx] = `(?:[-\.]|^)` + regexDomain + `[-\.]`
-	}
-	tel.RegexString = strings.Join(regexExpressions, "|")
-	tel.CombinedRegex = rubex.MustCompile(strings.Join(regexExpressions, "|"))
+func isEmbeddingBoundary(b byte) bool {
+	return b == '-' || b == '.'
+}
 
-	return tel
+// Byte offset of the first occurrence of baseDomain in domain that TargetEmbeddingLabeler labels, or -1
+func embeddingIndex(domain, baseDomain string) int {
+	for offset := 0; offset < len(domain); {
+		idx := strings.Index(domain[offset:], baseDomain)
+		if idx < 0 {
+			return -1
+		}
+		start, end := offset+idx, offset+idx+len(baseDomain)
+		if (start == 0 || isEmbeddingBoundary(domain[start-1])) && end < len(domain) && isEmbeddingBoundary(domain[end]) {
+			return start
+		}
+		offset = start + 1
+	}
+	return -1
 }
 
 func (t *TargetEmbeddingLabeler) LabelDomain(domain string) map[DomainLabel][]string {
-	t.Mux.Lock()
-	firstMatch := t.CombinedRegex.FindString(domain)
-	t.Mux.Unlock()
+	domainLabels := make(map[DomainLabel][]string)
 
-	if len(firstMatch) > 0 {
-		return map[DomainLabel][]string{
-			TARGET_EMBEDDING: {firstMatch},
+	seen := make(map[int]struct{})
+	for _, match := range t.Matcher.FindAll(domain) {
+		start := match.End - len(t.Matcher.Patterns[match.Pattern])
+		if start > 0 && !isEmbeddingBoundary(domain[start-1]) {
+			continue
+		}
+		if match.End >= len(domain) || !isEmbeddingBoundary(domain[match.End]) {
+			continue
 		}
+		if _, present := seen[match.Pattern]; present {
+			continue
+		}
+
+		seen[match.Pattern] = struct{}{}
+		domainLabels[TARGET_EMBEDDING] = append(domainLabels[TARGET_EMBEDDING], t.Matcher.Patterns[match.Pattern])
 	}
 
-	return make(map[DomainLabel][]string)
+	return domainLabels
 }
 
 type HomoGraphLabeler struct {
-	BaseDomainMap    map[string]struct{}
-	HomographDomains MutatedDomains
+	BaseDomainMap      map[string]struct{}
+	BaseDomainPrefixes map[string]struct{}
+	Index              *MutationIndex
+}
+
+var homographLabels = map[DomainLabel]struct{}{HOMOGRAPH: {}}
+
+// Labeler over an index that already holds the homographs of baseDomains
+func NewHomoGraphLabelerFromIndex(baseDomains *[]string, index *MutationIndex) *HomoGraphLabeler {
+	hl := &HomoGraphLabeler{
+		BaseDomainMap:      make(map[string]struct{}),
+		BaseDomainPrefixes: make(map[string]struct{}),
+		Index:              index,
+	}
+
+	for _, domain := range *baseDomains {
+		hl.BaseDomainMap[domain] = struct{}{}
+		for idx := range domain {
+			hl.BaseDomainPrefixes[domain[:idx+1]] = struct{}{}
+		}
+	}
+
+	return hl
 }
 
 /*
 Ingests an array of unicode strings, and generates a list of
-punycode (ASCII) homographs for labeling domains.
+punycode (ASCII) homographs with up to maxHomoglyphSubs substitutions for labeling domains.
+As with NewTypoSquattingLabeler, a nil builder gives the labeler its own index.
 */
-func NewHomoGraphLabeler(baseDomains *[]string) *HomoGraphLabeler {
-	domains := make(map[string]struct{})
-	hl := &HomoGraphLabeler{
-		HomographDomains: make(MutatedDomains),
+func NewHomoGraphLabeler(baseDomains *[]string, maxHomoglyphSubs int, builder *MutationIndexBuilder) *HomoGraphLabeler {
+	ownIndex := builder == nil
+	if ownIndex {
+		builder = NewMutationIndexBuilder()
 	}
 
+	hl := NewHomoGraphLabelerFromIndex(baseDomains, builder.Index())
+
 	for _, domain := range *baseDomains {
 		mutations := make(chan Mutation)
-		domains[domain] = struct{}{}
-		go GenerateASCIIHomographs(mutations, domain, 2)
+		go GenerateASCIIHomographs(mutations, domain, maxHomoglyphSubs)
 
 		for mutation := range mutations {
-			if _, present := hl.HomographDomains[mutation]; !present {
-				hl.HomographDomains[mutation] = make(BaseDomains)
-			}
-			hl.HomographDomains[mutation][domain] = struct{}{}
+			builder.Add(HOMOGRAPH, string(mutation), domain, 0)
 		}
 	}
 
-	hl.BaseDomainMap = domains
+	if ownIndex {
+		builder.Build()
+	}
 
 	return hl
 }
 
 func (t *HomoGraphLabeler) LabelDomain(domain string) map[DomainLabel][]string {
-	domainLabels := make(map[DomainLabel][]string)
-	mutation := Mutation(domain)
+	domainLabels := t.Index.LabelMutation(domain, homographLabels)
 
-	if _, present := t.HomographDomains[mutation]; present {
-		domainLabels[HOMOGRAPH] = make([]string, 0)
-		for k, _ := range t.HomographDomains[mutation] {
-			domainLabels[HOMOGRAPH] = append(domainLabels[HOMOGRAPH], k)
+	precomputed := make(map[string]struct{})
+	for _, baseDomain := range domainLabels[HOMOGRAPH] {
+		precomputed[baseDomain] = struct{}{}
+	}
+	for _, baseDomain := range t.SkeletonMatches(domain) {
+		if _, present := precomputed[baseDomain]; present {
+			continue
 		}
+		domainLabels[HOMOGRAPH] = append(domainLabels[HOMOGRAPH], baseDomain)
 	}
 
 	return domainLabels
 }
 
+/*
+Decodes the xn-- labels of domain and returns the base domains matching one of its
+ASCII skeletons. Unlike the precomputed homographs in the index, this catches homographs with
+any number of substituted characters.
+*/
+func (t *HomoGraphLabeler) SkeletonMatches(domain string) []string {
+	unicodeDomain, isIDN := UnicodeHostname(strings.TrimPrefix(domain, "*."))
+	if !isIDN {
+		return nil
+	}
+
+	keepPrefix := func(prefix string) bool {
+		_, present := t.BaseDomainPrefixes[prefix]
+		return present
+	}
+
+	matches := make([]string, 0)
+	for _, skeleton := range ASCIISkeletons(unicodeDomain, keepPrefix) {
+		if _, present := t.BaseDomainMap[skeleton]; present {
+			matches = append(matches, skeleton)
+		}
+	}
+
+	return matches
+}
+
 type BitSquattingLabeler struct {
-	BaseDomains        *[]string
-	BitSquattedDomains MutatedDomains
+	BaseDomains *[]string
+	Index       *MutationIndex
 }
 
+var bitSquattingLabels = map[DomainLabel]struct{}{BITSQUATTING: {}}
+
 func uint8Exp2(pow int) uint8 {
 	output := uint8(1)
 	for i := 0; i < pow; i++ {
@@ -335,11 +690,22 @@ func uint8Exp2(pow int) uint8 {
 	return output
 }
 
-func NewBitSquattingLabeler(baseDomains *[]string) *BitSquattingLabeler {
-	bsl := &BitSquattingLabeler{
-		BaseDomains:        baseDomains,
-		BitSquattedDomains: make(MutatedDomains),
+// Labeler over an index that already holds the bit flips of baseDomains
+func NewBitSquattingLabelerFromIndex(baseDomains *[]string, index *MutationIndex) *BitSquattingLabeler {
+	return &BitSquattingLabeler{
+		BaseDomains: baseDomains,
+		Index:       index,
+	}
+}
+
+// As with NewTypoSquattingLabeler, a nil builder gives the labeler its own index
+func NewBitSquattingLabeler(baseDomains *[]string, builder *MutationIndexBuilder) *BitSquattingLabeler {
+	ownIndex := builder == nil
+	if ownIndex {
+		builder = NewMutationIndexBuilder()
 	}
+
+	bsl := NewBitSquattingLabelerFromIndex(baseDomains, builder.Index())
 	for _, domain := range *baseDomains {
 		for idx := range domain {
 			for offset := 0; offset < 8; offset++ {
@@ -350,115 +716,167 @@ func NewBitSquattingLabeler(baseDomains *[]string) *BitSquattingLabeler {
 				bitFlippedDomain := append(tempSlice, domain[idx+1:]...)
 
 				if utf8.Valid(bitFlippedDomain) && ValidHostname(string(bitFlippedDomain)) {
-					AddMutation(bsl.BitSquattedDomains, Mutation(bitFlippedDomain), domain)
+					builder.Add(BITSQUATTING, string(bitFlippedDomain), domain, 0)
 				}
 			}
 		}
 	}
 
+	if ownIndex {
+		builder.Build()
+	}
+
 	return bsl
 }
 
 func (b *BitSquattingLabeler) LabelDomain(domain string) map[DomainLabel][]string {
-	domainLabels := make(map[DomainLabel][]string)
-	mutation := Mutation(domain)
-
-	if _, present := b.BitSquattedDomains[mutation]; present {
-		domainLabels[BITSQUATTING] = make([]string, 0)
-		for k, _ := range b.BitSquattedDomains[mutation] {
-			domainLabels[BITSQUATTING] = append(domainLabels[BITSQUATTING], k)
-		}
-	}
-
-	return domainLabels
+	return b.Index.LabelMutation(domain, bitSquattingLabels)
 }
 
 func (b *BitSquattingLabeler) GetMutations() MutatedDomains {
-	return b.BitSquattedDomains
+	return b.Index.Mutations(bitSquattingLabels)
 }
 
+/*
+Labels an eTLD+1 whose registrable label is that of a base domain but whose public suffix is
+another exact PSL rule of any depth (google.co.uk -> google.com.br). With IncludePrivate, suffixes
+from the PSL private section (github.io, herokuapp.com) count too and are labeled
+WRONGTLD_PRIVATE. Only a name that is itself an eTLD+1 is labeled, and only its whole public
+suffix is swapped: google.github.io is labeled for google.com, google.com.github.io and
+login.google.de are not. Names are looked up by registrable label when labeled rather than
+generating every base domain under every suffix.
+*/
 type WrongTLDLabeler struct {
 	BaseDomains     *[]string
-	OneOrTwoTLDs    map[string]struct{}
-	WrongTLDDomains MutatedDomains
+	IncludePrivate  bool
+	ICANNSuffixes   map[string]struct{}
+	PrivateSuffixes map[string]struct{}
+	// Punycode registrable label (google) -> base domains with it and their public suffixes
+	registrableLabels map[string][]wrongTLDBase
 }
 
-func NewWrongTLDLabeler(baseDomains *[]string) *WrongTLDLabeler {
-	wtl := &WrongTLDLabeler{
-		BaseDomains:     baseDomains,
-		WrongTLDDomains: make(MutatedDomains),
-		OneOrTwoTLDs:    make(map[string]struct{}),
-	}
-
-	PublicSuffixListUrl := "https://publicsuffix.org/list/public_suffix_list.dat"
-	resp, err := http.Get(PublicSuffixListUrl)
-	defer resp.Body.Close()
+type wrongTLDBase struct {
+	domain string
+	suffix string
+}
 
-	if err != nil {
-		log.Fatalf("Unable to download PSL list from %s", PublicSuffixListUrl)
+func NewWrongTLDLabeler(baseDomains *[]string, includePrivate bool) *WrongTLDLabeler {
+	wtl := &WrongTLDLabeler{
+		BaseDomains:       baseDomains,
+		IncludePrivate:    includePrivate,
+		ICANNSuffixes:     make(map[string]struct{}),
+		PrivateSuffixes:   make(map[string]struct{}),
+		registrableLabels: make(map[string][]wrongTLDBase),
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 || strings.HasPrefix(line, "//") {
+	for _, rule := range PSL.Rules {
+		if rule.Wildcard || rule.Exception {
 			continue
 		}
 
-		if len(strings.Split(line, ".")) > 2 {
-			continue
+		if rule.Private {
+			wtl.PrivateSuffixes[rule.Suffix] = struct{}{}
+		} else {
+			wtl.ICANNSuffixes[rule.Suffix] = struct{}{}
 		}
-
-		wtl.OneOrTwoTLDs[line] = struct{}{}
 	}
 
+	seen := make(map[string]struct{})
 	for _, domain := range *baseDomains {
-		publicSuffix, _ := publicsuffix.PublicSuffix(domain)
-		eTLDplus1, err := publicsuffix.EffectiveTLDPlusOne(domain)
-		if err != nil {
-			log.Fatalf("Unable to extract eTLD+1 from %s: %s", domain, err.Error())
+		if _, present := seen[domain]; present {
+			continue
 		}
-		domainNameSansETLD := strings.TrimSuffix(eTLDplus1, "."+publicSuffix)
+		seen[domain] = struct{}{}
 
-		for eTLD, _ := range wtl.OneOrTwoTLDs {
-			if eTLD != publicSuffix {
-				punyDomain, err := idna.ToASCII(domainNameSansETLD + "." + eTLD)
-				if err != nil {
-					log.Fatalf("Error converting %s to ascii/punycode", domainNameSansETLD+"."+eTLD)
-				}
-				AddMutation(wtl.WrongTLDDomains, Mutation(punyDomain), domain)
-			}
+		punyDomain, err := idna.ToASCII(domain)
+		if err != nil {
+			log.Fatalf("Error converting %s to ascii/punycode", domain)
+		}
+		registrableLabel, publicSuffix, err := splitRegistrableLabel(punyDomain)
+		if err != nil {
+			log.Fatalf("Unable to extract eTLD+1 from %s: %s", domain, err.Error())
 		}
+		wtl.registrableLabels[registrableLabel] = append(wtl.registrableLabels[registrableLabel], wrongTLDBase{domain: domain, suffix: publicSuffix})
 	}
 
 	return wtl
 }
 
+// The label left of the public suffix of domain's eTLD+1, and that suffix
+func splitRegistrableLabel(domain string) (string, string, error) {
+	eTLDplus1, err := EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return "", "", err
+	}
+	publicSuffix, _ := PublicSuffix(eTLDplus1)
+	return strings.TrimSuffix(eTLDplus1, "."+publicSuffix), publicSuffix, nil
+}
+
+// The label a name on suffix gets, if suffix is one the labeler swaps in
+func (w *WrongTLDLabeler) suffixLabel(suffix string) (DomainLabel, bool) {
+	if _, present := w.ICANNSuffixes[suffix]; present {
+		return WRONGTLD, true
+	}
+	if _, present := w.PrivateSuffixes[suffix]; present && w.IncludePrivate {
+		return WRONGTLD_PRIVATE, true
+	}
+	return UNLABELED, false
+}
+
+// Only labels eTLD+1s themselves, as the swaps are of base domains' eTLD+1s
 func (w *WrongTLDLabeler) LabelDomain(domain string) map[DomainLabel][]string {
 	domainLabels := make(map[DomainLabel][]string)
-	mutation := Mutation(domain)
 
-	if _, present := w.WrongTLDDomains[mutation]; present {
-		domainLabels[WRONGTLD] = make([]string, 0)
-		for k, _ := range w.WrongTLDDomains[mutation] {
-			domainLabels[WRONGTLD] = append(domainLabels[WRONGTLD], k)
-		}
+	registrableLabel, publicSuffix, err := splitRegistrableLabel(domain)
+	if err != nil || registrableLabel+"."+publicSuffix != domain {
+		return domainLabels
+	}
+	label, swapped := w.suffixLabel(publicSuffix)
+	if !swapped {
+		return domainLabels
 	}
 
+	for _, base := range w.registrableLabels[registrableLabel] {
+		if base.suffix != publicSuffix {
+			domainLabels[label] = append(domainLabels[label], base.domain)
+		}
+	}
+	if len(domainLabels[label]) == 0 {
+		delete(domainLabels, label)
+	}
 	return domainLabels
 }
 
+// Lists every base domain under every other suffix, which for a long base domain list is large
 func (w *WrongTLDLabeler) GetMutations() MutatedDomains {
-	return w.WrongTLDDomains
+	mutatedDomains := make(MutatedDomains)
+	suffixes := make([]string, 0, len(w.ICANNSuffixes)+len(w.PrivateSuffixes))
+	for suffix := range w.ICANNSuffixes {
+		suffixes = append(suffixes, suffix)
+	}
+	if w.IncludePrivate {
+		for suffix := range w.PrivateSuffixes {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+
+	for registrableLabel, bases := range w.registrableLabels {
+		for _, base := range bases {
+			for _, suffix := range suffixes {
+				if suffix != base.suffix {
+					AddMutation(mutatedDomains, Mutation(registrableLabel+"."+suffix), base.domain)
+				}
+			}
+		}
+	}
+	return mutatedDomains
 }
 
 type ComboSquattingLabeler struct {
 }
 
 func NewComboSquattingLabeler(baseDomains *[]string) *ComboSquattingLabeler {
-	tel := &ComboSquattingLabeler{
-
-	}
+	tel := &ComboSquattingLabeler{}
 	return tel
 }
 
@@ -466,25 +884,31 @@ func (t *ComboSquattingLabeler) LabelDomain(domain string) {
 
 }
 
-type PhishTankLabeler struct {
-	blacklistedDomains map[string]struct{}
-}
+// Bundled blocklist snapshots, relative to the package source directory
+const (
+	DefaultPhishTankFeed    string = "domainlists/phishtank-hostnames-2018-09-19-to-2020-05-26.sanitized.txt"
+	DefaultOpenPhishFeed    string = "domainlists/openphish-hostnames-2018-09-19-to-2020-05-26.sanitized.txt"
+	DefaultSafeBrowsingFeed string = "domainlists/gsb_blacklist_2018-10-29-to-2020-05-26.sanitized.txt"
+)
 
-func NewPhishTankLabeler() *PhishTankLabeler {
+func bundledFile(name string) string {
 	_, filename, _, ok := runtime.Caller(0)
 	if !ok {
 		panic("No caller information")
 	}
 
-	file, err := os.Open(filepath.Join(path.Dir(filename), "domainlists/phishtank-hostnames-2018-09-19-to-2020-05-26.sanitized.txt"))
+	return filepath.Join(path.Dir(filename), name)
+}
+
+// One hostname per line
+func loadHostnameFeed(feedPath string) map[string]struct{} {
+	file, err := os.Open(feedPath)
 	if err != nil {
 		panic(err)
 	}
 	defer file.Close()
 
-	ptl := &PhishTankLabeler{
-		blacklistedDomains: make(map[string]struct{}),
-	}
+	hostnames := make(map[string]struct{})
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		domain := strings.TrimSpace(scanner.Text())
@@ -492,10 +916,25 @@ func NewPhishTankLabeler() *PhishTankLabeler {
 			continue
 		}
 
-		ptl.blacklistedDomains[domain] = struct{}{}
+		hostnames[domain] = struct{}{}
+	}
+
+	return hostnames
+}
+
+type PhishTankLabeler struct {
+	blacklistedDomains map[string]struct{}
+}
+
+// Loads the hostnames in feedPath, or the bundled snapshot if feedPath is empty
+func NewPhishTankLabeler(feedPath string) *PhishTankLabeler {
+	if feedPath == "" {
+		feedPath = bundledFile(DefaultPhishTankFeed)
 	}
 
-	return ptl
+	return &PhishTankLabeler{
+		blacklistedDomains: loadHostnameFeed(feedPath),
+	}
 }
 
 func (p *PhishTankLabeler) LabelDomain(domain string) map[DomainLabel][]string {
@@ -512,32 +951,15 @@ type OpenPhishLabeler struct {
 	blacklistedDomains map[string]struct{}
 }
 
-func NewOpenPhishLabeler() *OpenPhishLabeler {
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		panic("No caller information")
-	}
-
-	file, err := os.Open(filepath.Join(path.Dir(filename), "domainlists/openphish-hostnames-2018-09-19-to-2020-05-26.sanitized.txt"))
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	ptl := &OpenPhishLabeler{
-		blacklistedDomains: make(map[string]struct{}),
+// Loads the hostnames in feedPath, or the bundled snapshot if feedPath is empty
+func NewOpenPhishLabeler(feedPath string) *OpenPhishLabeler {
+	if feedPath == "" {
+		feedPath = bundledFile(DefaultOpenPhishFeed)
 	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		domain := strings.TrimSpace(scanner.Text())
-		if domain == "" {
-			continue
-		}
 
-		ptl.blacklistedDomains[domain] = struct{}{}
+	return &OpenPhishLabeler{
+		blacklistedDomains: loadHostnameFeed(feedPath),
 	}
-
-	return ptl
 }
 
 func (p *OpenPhishLabeler) LabelDomain(domain string) map[DomainLabel][]string {
@@ -554,32 +976,15 @@ type SafeBrowsingLabeler struct {
 	blacklistedDomains map[string]struct{}
 }
 
-func NewSafeBrowsingLabeler() *SafeBrowsingLabeler {
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		panic("No caller information")
-	}
-
-	file, err := os.Open(filepath.Join(path.Dir(filename), "domainlists/gsb_blacklist_2018-10-29-to-2020-05-26.sanitized.txt"))
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	ptl := &SafeBrowsingLabeler{
-		blacklistedDomains: make(map[string]struct{}),
+// Loads the hostnames in feedPath, or the bundled snapshot if feedPath is empty
+func NewSafeBrowsingLabeler(feedPath string) *SafeBrowsingLabeler {
+	if feedPath == "" {
+		feedPath = bundledFile(DefaultSafeBrowsingFeed)
 	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		domain := strings.TrimSpace(scanner.Text())
-		if domain == "" {
-			continue
-		}
 
-		ptl.blacklistedDomains[domain] = struct{}{}
+	return &SafeBrowsingLabeler{
+		blacklistedDomains: loadHostnameFeed(feedPath),
 	}
-
-	return ptl
 }
 
 func (p *SafeBrowsingLabeler) LabelDomain(domain string) map[DomainLabel][]string {
@@ -0,0 +1,169 @@
+package certificate_searcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Whether labels has label with baseDomain among its sources
+func labeledWith(labels map[DomainLabel][]string, label DomainLabel, baseDomain string) bool {
+	for _, source := range labels[label] {
+		if source == baseDomain {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTypoSquattingLabelerMutations(t *testing.T) {
+	baseDomains := []string{"google.com", "my-bank.com"}
+	labeler := NewTypoSquattingLabeler(&baseDomains, []*KeyboardLayout{QWERTY}, nil)
+
+	tests := []struct {
+		name       string
+		label      DomainLabel
+		baseDomain string
+		want       bool
+	}{
+		{"gogle.com", TYPOSQUATTING_CHAR_OMISSION, "google.com", true},
+		{"goolge.com", TYPOSQUATTING_CHAR_PERMUTATION, "google.com", true},
+		{"gooogle.com", TYPOSQUATTING_CHAR_DUPLICATION, "google.com", true},
+		{"goofle.com", TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", true},
+		// a key next to the intended one pressed as well, before or after it
+		{"gopogle.com", TYPOSQUATTING_CHAR_INSERTION, "google.com", true},
+		{"googhle.com", TYPOSQUATTING_CHAR_INSERTION, "google.com", true},
+		{"googxle.com", TYPOSQUATTING_CHAR_INSERTION, "google.com", false},
+		{"goagle.com", TYPOSQUATTING_VOWEL_SWAP, "google.com", true},
+		{"googla.com", TYPOSQUATTING_VOWEL_SWAP, "google.com", true},
+		// vowel swaps, hyphens and dots stay out of the public suffix
+		{"google.cam", TYPOSQUATTING_VOWEL_SWAP, "google.com", false},
+		{"goo-gle.com", TYPOSQUATTING_HYPHEN_INSERTION, "google.com", true},
+		{"google-.com", TYPOSQUATTING_HYPHEN_INSERTION, "google.com", false},
+		{"-google.com", TYPOSQUATTING_HYPHEN_INSERTION, "google.com", false},
+		{"google.c-om", TYPOSQUATTING_HYPHEN_INSERTION, "google.com", false},
+		{"my--bank.com", TYPOSQUATTING_HYPHEN_INSERTION, "my-bank.com", false},
+		{"mybank.com", TYPOSQUATTING_HYPHEN_REMOVAL, "my-bank.com", true},
+		{"go0gle.com", TYPOSQUATTING_NUMERAL_SWAP, "google.com", true},
+		{"googl3.com", TYPOSQUATTING_NUMERAL_SWAP, "google.com", true},
+		{"9oogle.com", TYPOSQUATTING_NUMERAL_SWAP, "google.com", true},
+		{"my-8ank.com", TYPOSQUATTING_NUMERAL_SWAP, "my-bank.com", true},
+		// one swap at a time
+		{"g00gle.com", TYPOSQUATTING_NUMERAL_SWAP, "google.com", false},
+		{"google.com", TYPOSQUATTING_CHAR_OMISSION, "google.com", false},
+	}
+
+	for _, test := range tests {
+		if got := labeledWith(labeler.LabelDomain(test.name), test.label, test.baseDomain); got != test.want {
+			t.Errorf("LabelDomain(%q) has %s from %s: %t, want %t", test.name, test.label, test.baseDomain, got, test.want)
+		}
+	}
+
+	// the new mutations are generated into the same structures domain-mutator prints
+	mutations := labeler.GetMutations()
+	for _, mutation := range []Mutation{"gopogle.com", "goagle.com", "goo-gle.com", "mybank.com", "go0gle.com"} {
+		if _, present := mutations[mutation]; !present {
+			t.Errorf("GetMutations() is missing %s", mutation)
+		}
+	}
+}
+
+func TestTypoSquattingLabelerMissingDots(t *testing.T) {
+	baseDomains := []string{"google.com", "www.paypal.com", "mail.google.com", "bbc.co.uk"}
+	labeler := NewTypoSquattingLabeler(&baseDomains, []*KeyboardLayout{QWERTY}, nil)
+
+	tests := []struct {
+		name       string
+		label      DomainLabel
+		baseDomain string
+		want       bool
+	}{
+		// www glued to the name, whether or not the base domain has it
+		{"wwwgoogle.com", TYPOSQUATTING_MISSING_DOT, "google.com", true},
+		{"wwwpaypal.com", TYPOSQUATTING_MISSING_DOT, "www.paypal.com", true},
+		{"mailgoogle.com", TYPOSQUATTING_MISSING_DOT_SUBDOMAIN, "mail.google.com", true},
+		{"wwwpaypal.com", TYPOSQUATTING_MISSING_DOT_SUBDOMAIN, "www.paypal.com", false},
+		// the dots of the public suffix dropped, under the rest of a multi-label suffix or any other
+		{"bbcco.uk", TYPOSQUATTING_MISSING_DOT_SUFFIX, "bbc.co.uk", true},
+		{"bbccouk.com", TYPOSQUATTING_MISSING_DOT_SUFFIX, "bbc.co.uk", true},
+		{"googlecom.xyz", TYPOSQUATTING_MISSING_DOT_SUFFIX, "google.com", true},
+		{"login.googlecom.net", TYPOSQUATTING_MISSING_DOT_SUFFIX, "google.com", true},
+		{"googlecom.xyz", TYPOSQUATTING_MISSING_DOT_SUFFIX, "mail.google.com", true},
+		{"googlenet.com", TYPOSQUATTING_MISSING_DOT_SUFFIX, "google.com", false},
+		// a label split by a dot, only before the public suffix
+		{"goo.gle.com", TYPOSQUATTING_DOT_INSERTION, "google.com", true},
+		{"g.oogle.com", TYPOSQUATTING_DOT_INSERTION, "google.com", true},
+		{"mail.goog.le.com", TYPOSQUATTING_DOT_INSERTION, "mail.google.com", true},
+		{"google.c.om", TYPOSQUATTING_DOT_INSERTION, "google.com", false},
+		{"bbc.co.u.k", TYPOSQUATTING_DOT_INSERTION, "bbc.co.uk", false},
+	}
+
+	for _, test := range tests {
+		if got := labeledWith(labeler.LabelDomain(test.name), test.label, test.baseDomain); got != test.want {
+			t.Errorf("LabelDomain(%q) has %s from %s: %t, want %t", test.name, test.label, test.baseDomain, got, test.want)
+		}
+	}
+}
+
+func TestTypoSquattingLabelerLayouts(t *testing.T) {
+	baseDomains := []string{"amazon.com"}
+	labeler := NewTypoSquattingLabeler(&baseDomains, []*KeyboardLayout{QWERTY, AZERTY}, nil)
+
+	tests := []struct {
+		name  string
+		label DomainLabel
+		want  []string
+	}{
+		{"amazpn.com", TYPOSQUATTING_CHAR_SUBSTITUTION, []string{"qwerty", "azerty"}},
+		{"amaxon.com", TYPOSQUATTING_CHAR_SUBSTITUTION, []string{"qwerty"}},
+		{"amaeon.com", TYPOSQUATTING_CHAR_SUBSTITUTION, []string{"azerty"}},
+		{"amazxon.com", TYPOSQUATTING_CHAR_INSERTION, []string{"qwerty"}},
+		{"amazeon.com", TYPOSQUATTING_CHAR_INSERTION, []string{"azerty"}},
+	}
+
+	for _, test := range tests {
+		details := labeler.LabelDomainDetails(test.name)[test.label]
+		if len(details) != 1 || details[0].BaseDomain != "amazon.com" {
+			t.Errorf("LabelDomainDetails(%q)[%s] = %+v, want one match of amazon.com", test.name, test.label, details)
+			continue
+		}
+		if !reflect.DeepEqual(details[0].KeyboardLayouts, test.want) {
+			t.Errorf("LabelDomainDetails(%q)[%s] layouts = %v, want %v", test.name, test.label, details[0].KeyboardLayouts, test.want)
+		}
+	}
+}
+
+func TestWrongTLDLabeler(t *testing.T) {
+	baseDomains := []string{"google.com", "google.co.uk", "bbc.co.uk", "myapp.github.io"}
+
+	tests := []struct {
+		name           string
+		includePrivate bool
+		want           map[DomainLabel][]string
+	}{
+		{"google.de", false, map[DomainLabel][]string{WRONGTLD: {"google.com", "google.co.uk"}}},
+		// multi-label suffixes both ways
+		{"google.com.br", false, map[DomainLabel][]string{WRONGTLD: {"google.com", "google.co.uk"}}},
+		{"bbc.com", false, map[DomainLabel][]string{WRONGTLD: {"bbc.co.uk"}}},
+		{"bbc.co.jp", false, map[DomainLabel][]string{WRONGTLD: {"bbc.co.uk"}}},
+		// only the other base domain of the registrable label
+		{"google.com", false, map[DomainLabel][]string{WRONGTLD: {"google.co.uk"}}},
+		{"bbc.co.uk", false, map[DomainLabel][]string{}},
+		// private suffixes only with includePrivate
+		{"google.github.io", false, map[DomainLabel][]string{}},
+		{"google.github.io", true, map[DomainLabel][]string{WRONGTLD_PRIVATE: {"google.com", "google.co.uk"}}},
+		{"bbc.herokuapp.com", true, map[DomainLabel][]string{WRONGTLD_PRIVATE: {"bbc.co.uk"}}},
+		// a base domain on a private suffix swapped to an ICANN one
+		{"myapp.io", true, map[DomainLabel][]string{WRONGTLD: {"myapp.github.io"}}},
+		// only whole suffixes of eTLD+1 names
+		{"login.google.de", false, map[DomainLabel][]string{}},
+		{"google.com.github.io", true, map[DomainLabel][]string{}},
+		{"example.com", false, map[DomainLabel][]string{}},
+	}
+
+	for _, test := range tests {
+		labeler := NewWrongTLDLabeler(&baseDomains, test.includePrivate)
+		if got := labeler.LabelDomain(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("LabelDomain(%q) with includePrivate %t = %v, want %v", test.name, test.includePrivate, got, test.want)
+		}
+	}
+}
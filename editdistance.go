@@ -0,0 +1,280 @@
+package certificate_searcher
+
+import (
+	"github.com/cespare/xxhash"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+/*
+Buffers for computing Damerau-Levenshtein distances, reused between comparisons so a search
+does not allocate a matrix per candidate. Not safe for concurrent use.
+*/
+type DamerauLevenshtein struct {
+	ra, rb  []rune
+	d       []int
+	lastRow map[rune]int
+}
+
+func NewDamerauLevenshtein() *DamerauLevenshtein {
+	return &DamerauLevenshtein{lastRow: make(map[rune]int)}
+}
+
+/*
+Unrestricted Damerau-Levenshtein distance (Lowrance-Wagner): the number of insertions,
+deletions, substitutions and transpositions of adjacent characters needed to turn a into b.
+Unlike optimal string alignment it allows edits between transposed characters, so ca -> abc is 2.
+*/
+func (dl *DamerauLevenshtein) Distance(a, b string) int {
+	dl.ra = append(dl.ra[:0], []rune(a)...)
+	dl.rb = append(dl.rb[:0], []rune(b)...)
+	ra, rb := dl.ra, dl.rb
+	maxDist := len(ra) + len(rb)
+
+	// (len(ra)+2) x (len(rb)+2) matrix, row-major
+	cols := len(rb) + 2
+	if size := (len(ra) + 2) * cols; cap(dl.d) < size {
+		dl.d = make([]int, size)
+	} else {
+		dl.d = dl.d[:size]
+	}
+	d := dl.d
+
+	d[0] = maxDist
+	for i := 0; i <= len(ra); i++ {
+		d[(i+1)*cols] = maxDist
+		d[(i+1)*cols+1] = i
+	}
+	for j := 0; j <= len(rb); j++ {
+		d[j+1] = maxDist
+		d[cols+j+1] = j
+	}
+
+	for r := range dl.lastRow {
+		delete(dl.lastRow, r)
+	}
+	for i := 1; i <= len(ra); i++ {
+		lastMatchCol := 0
+		for j := 1; j <= len(rb); j++ {
+			i1 := dl.lastRow[rb[j-1]]
+			j1 := lastMatchCol
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+				lastMatchCol = j
+			}
+
+			d[(i+1)*cols+j+1] = minInt(
+				d[i*cols+j]+cost,
+				d[(i+1)*cols+j]+1,
+				d[i*cols+j+1]+1,
+				d[i1*cols+j1]+(i-i1-1)+1+(j-j1-1),
+			)
+		}
+		dl.lastRow[ra[i-1]] = i
+	}
+
+	return d[(len(ra)+1)*cols+len(rb)+1]
+}
+
+func DamerauLevenshteinDistance(a, b string) int {
+	return NewDamerauLevenshtein().Distance(a, b)
+}
+
+var distanceBuffers = sync.Pool{New: func() interface{} { return NewDamerauLevenshtein() }}
+
+type deletionKey struct {
+	hash uint64
+	word uint32
+}
+
+/*
+Symmetric-delete index: every word is filed under each string left after deleting up to
+MaxDistance of its runes. Any edit (substitution, insertion, deletion or adjacent transposition)
+costs at most one deletion on each side, so a word within MaxDistance of a query shares a key with
+it, and a search only looks up the query's own deletions instead of walking a tree. Keys are
+64-bit hashes in one sorted array; colliding hashes only add candidates, and every candidate is
+checked with the real distance. Safe for concurrent queries.
+*/
+type DeletionIndex struct {
+	MaxDistance int
+	words       []string
+	keys        []deletionKey
+}
+
+func NewDeletionIndex(words []string, maxDistance int) *DeletionIndex {
+	x := &DeletionIndex{MaxDistance: maxDistance}
+
+	seen := make(map[string]struct{})
+	for _, word := range words {
+		if _, present := seen[word]; present {
+			continue
+		}
+		seen[word] = struct{}{}
+
+		id := uint32(len(x.words))
+		x.words = append(x.words, word)
+		for variant := range deletionVariants(word, maxDistance) {
+			x.keys = append(x.keys, deletionKey{hash: xxhash.Sum64String(variant), word: id})
+		}
+	}
+
+	sort.Slice(x.keys, func(i, j int) bool {
+		if x.keys[i].hash != x.keys[j].hash {
+			return x.keys[i].hash < x.keys[j].hash
+		}
+		return x.keys[i].word < x.keys[j].word
+	})
+
+	return x
+}
+
+// word and every distinct string left after deleting up to maxDeletions of its runes
+func deletionVariants(word string, maxDeletions int) map[string]struct{} {
+	variants := map[string]struct{}{word: {}}
+	frontier := []string{word}
+	for deleted := 0; deleted < maxDeletions; deleted++ {
+		next := make([]string, 0)
+		for _, variant := range frontier {
+			runes := []rune(variant)
+			for idx := range runes {
+				shorter := string(runes[:idx]) + string(runes[idx+1:])
+				if _, present := variants[shorter]; !present {
+					variants[shorter] = struct{}{}
+					next = append(next, shorter)
+				}
+			}
+		}
+		frontier = next
+	}
+	return variants
+}
+
+func (x *DeletionIndex) Len() int {
+	return len(x.words)
+}
+
+// Returns every indexed word within MaxDistance of word, mapped to its distance
+func (x *DeletionIndex) Search(word string) map[string]int {
+	matches := make(map[string]int)
+
+	dl := distanceBuffers.Get().(*DamerauLevenshtein)
+	defer distanceBuffers.Put(dl)
+
+	checked := make(map[uint32]struct{})
+	for variant := range deletionVariants(word, x.MaxDistance) {
+		hash := xxhash.Sum64String(variant)
+		idx := sort.Search(len(x.keys), func(i int) bool { return x.keys[i].hash >= hash })
+		for ; idx < len(x.keys) && x.keys[idx].hash == hash; idx++ {
+			id := x.keys[idx].word
+			if _, present := checked[id]; present {
+				continue
+			}
+			checked[id] = struct{}{}
+
+			if dist := dl.Distance(word, x.words[id]); dist <= x.MaxDistance {
+				matches[x.words[id]] = dist
+			}
+		}
+	}
+
+	return matches
+}
+
+/*
+Labels names whose registrable label (google in mail.google.co.uk) is within MaxDistance edits
+of a base domain's, whatever their public suffixes, catching combined edits that the
+precomputed single-edit typosquatting mutations miss. The distance allowed for a base label is
+also capped at a third of its length, since two edits turn any two-letter label into any other.
+Names with exactly a base domain's label under another suffix are left to WrongTLDLabeler.
+*/
+type EditDistanceLabeler struct {
+	BaseDomains *[]string
+	MaxDistance int
+	// Punycode registrable label -> base domains with it
+	RegistrableLabels map[string][]string
+	Index             *DeletionIndex
+	// Used to score matches; nil means DefaultTypoPriors
+	Priors TypoPriors
+}
+
+func NewEditDistanceLabeler(baseDomains *[]string, maxDistance int) *EditDistanceLabeler {
+	edl := &EditDistanceLabeler{
+		BaseDomains:       baseDomains,
+		MaxDistance:       maxDistance,
+		RegistrableLabels: make(map[string][]string),
+	}
+
+	labels := make([]string, 0, len(*baseDomains))
+	seen := make(map[string]struct{})
+	for _, domain := range *baseDomains {
+		if _, present := seen[domain]; present {
+			continue
+		}
+		seen[domain] = struct{}{}
+
+		registrableLabel, _, err := splitRegistrableLabel(domain)
+		if err != nil {
+			log.Fatalf("Unable to extract eTLD+1 from %s: %s", domain, err.Error())
+		}
+
+		edl.RegistrableLabels[registrableLabel] = append(edl.RegistrableLabels[registrableLabel], domain)
+		labels = append(labels, registrableLabel)
+	}
+	edl.Index = NewDeletionIndex(labels, maxDistance)
+
+	return edl
+}
+
+// Edits allowed against baseLabel: MaxDistance, but at most a third of the label's length
+func (e *EditDistanceLabeler) allowedDistance(baseLabel string) int {
+	return minInt(e.MaxDistance, utf8.RuneCountInString(baseLabel)/3)
+}
+
+func (e *EditDistanceLabeler) LabelDomain(domain string) map[DomainLabel][]string {
+	return DetailLabels(e.LabelDomainDetails(domain))
+}
+
+func (e *EditDistanceLabeler) LabelDomainDetails(domain string) map[DomainLabel][]MatchDetail {
+	domainDetails := make(map[DomainLabel][]MatchDetail)
+
+	registrableLabel, _, err := splitRegistrableLabel(strings.TrimPrefix(domain, "*."))
+	if err != nil {
+		return domainDetails
+	}
+
+	found := e.Index.Search(registrableLabel)
+	matches := make([]string, 0, len(found))
+	for match, dist := range found {
+		if dist > 0 && dist <= e.allowedDistance(match) {
+			matches = append(matches, match)
+		}
+	}
+	sort.Strings(matches)
+
+	for _, match := range matches {
+		dist := found[match]
+		for _, baseDomain := range e.RegistrableLabels[match] {
+			domainDetails[EDIT_DISTANCE] = append(domainDetails[EDIT_DISTANCE], MatchDetail{
+				BaseDomain:   baseDomain,
+				EditDistance: dist,
+				Likelihood:   EditDistanceLikelihood(match, registrableLabel, dist, e.Priors),
+			})
+		}
+	}
+
+	return domainDetails
+}
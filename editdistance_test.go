@@ -0,0 +1,217 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDamerauLevenshteinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"google", "", 6},
+		{"", "google", 6},
+		{"google", "google", 0},
+		{"google", "gogle", 1},
+		{"google", "gooogle", 1},
+		{"google", "goigle", 1},
+		{"google", "ogogle", 1},
+		{"google", "gogole", 1},
+		{"paypal", "paypa1l", 1},
+		{"paypal", "pyapl", 2},
+		// a transposition with an insertion between, which optimal string alignment counts as 3
+		{"ca", "abc", 2},
+		{"amazon", "amazn0", 2},
+		{"kitten", "sitting", 3},
+		{"gооgle", "google", 2},
+	}
+
+	dl := NewDamerauLevenshtein()
+	for _, test := range tests {
+		if got := DamerauLevenshteinDistance(test.a, test.b); got != test.want {
+			t.Errorf("DamerauLevenshteinDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := DamerauLevenshteinDistance(test.b, test.a); got != test.want {
+			t.Errorf("DamerauLevenshteinDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+		// reused buffers must not carry state between comparisons
+		if got := dl.Distance(test.a, test.b); got != test.want {
+			t.Errorf("reused DamerauLevenshtein.Distance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+var searchWords = []string{"google", "goggle", "gogle", "youtube", "yahoo", "paypal", "amazon", "amazing", "apple", "appel", "facebook"}
+
+// Every word within maxDist of word, by comparing against each one
+func linearSearch(words []string, word string, maxDist int) map[string]int {
+	matches := make(map[string]int)
+	for _, candidate := range words {
+		if dist := DamerauLevenshteinDistance(word, candidate); dist <= maxDist {
+			matches[candidate] = dist
+		}
+	}
+	return matches
+}
+
+func TestDeletionIndexSearch(t *testing.T) {
+	tests := []struct {
+		word    string
+		maxDist int
+		want    map[string]int
+	}{
+		{"google", 1, map[string]int{"google": 0, "goggle": 1, "gogle": 1}},
+		{"gooogle", 2, map[string]int{"google": 1, "goggle": 2, "gogle": 2}},
+		{"aple", 1, map[string]int{"apple": 1}},
+		{"aple", 2, map[string]int{"apple": 1, "appel": 2}},
+		{"microsoft", 2, map[string]int{}},
+	}
+
+	for _, test := range tests {
+		index := NewDeletionIndex(append(searchWords, "google"), test.maxDist)
+		if index.Len() != len(searchWords) {
+			t.Errorf("Len() = %d, want %d", index.Len(), len(searchWords))
+		}
+		if got := index.Search(test.word); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%q) with max distance %d = %v, want %v", test.word, test.maxDist, got, test.want)
+		}
+	}
+}
+
+func TestDeletionIndexMatchesLinearSearch(t *testing.T) {
+	queries := []string{"google", "gooogle", "ogogle", "aple", "amazn", "amazoni", "yaho0", "faceboko", "microsoft", "", "g"}
+	for maxDist := 1; maxDist <= 2; maxDist++ {
+		index := NewDeletionIndex(searchWords, maxDist)
+		for _, query := range queries {
+			want := linearSearch(searchWords, query, maxDist)
+			if got := index.Search(query); !reflect.DeepEqual(got, want) {
+				t.Errorf("DeletionIndex.Search(%q) with max distance %d = %v, want %v", query, maxDist, got, want)
+			}
+		}
+	}
+}
+
+func TestEditDistanceLabeler(t *testing.T) {
+	baseDomains := []string{"google.com", "paypal.com", "bbc.co.uk"}
+	labeler := NewEditDistanceLabeler(&baseDomains, 2)
+
+	tests := []struct {
+		name string
+		want map[DomainLabel][]string
+	}{
+		{"gogle.com", map[DomainLabel][]string{EDIT_DISTANCE: {"google.com"}}},
+		// the registrable label is compared whatever the suffix
+		{"gogle.net", map[DomainLabel][]string{EDIT_DISTANCE: {"google.com"}}},
+		{"login.paypa1l.co.uk", map[DomainLabel][]string{EDIT_DISTANCE: {"paypal.com"}}},
+		{"*.bbbc.com", map[DomainLabel][]string{EDIT_DISTANCE: {"bbc.co.uk"}}},
+		// the same label under another suffix is a wrong TLD, not an edit
+		{"google.co", map[DomainLabel][]string{}},
+		{"google.com", map[DomainLabel][]string{}},
+		{"example.com", map[DomainLabel][]string{}},
+		// several base domains within reach, in a stable order
+		{"gaypal.com", map[DomainLabel][]string{EDIT_DISTANCE: {"paypal.com"}}},
+	}
+
+	for _, test := range tests {
+		if got := labeler.LabelDomain(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("LabelDomain(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEditDistanceLabelerShortLabels(t *testing.T) {
+	baseDomains := []string{"qq.com", "vk.com", "t.co", "bbc.co.uk", "yahoo.com"}
+	labeler := NewEditDistanceLabeler(&baseDomains, 2)
+
+	tests := []struct {
+		name string
+		want map[DomainLabel][]string
+	}{
+		// any label of up to four letters is within two edits of a two-letter one
+		{"ab.com", map[DomainLabel][]string{}},
+		{"xy.net", map[DomainLabel][]string{}},
+		{"a.io", map[DomainLabel][]string{}},
+		{"go.dev", map[DomainLabel][]string{}},
+		{"qqq.com", map[DomainLabel][]string{}},
+		// three letters allow one edit, six allow two
+		{"bbbc.com", map[DomainLabel][]string{EDIT_DISTANCE: {"bbc.co.uk"}}},
+		{"bbcc.com", map[DomainLabel][]string{EDIT_DISTANCE: {"bbc.co.uk"}}},
+		{"bc.com", map[DomainLabel][]string{EDIT_DISTANCE: {"bbc.co.uk"}}},
+		{"bxc.com", map[DomainLabel][]string{EDIT_DISTANCE: {"bbc.co.uk"}}},
+		{"bxx.com", map[DomainLabel][]string{}},
+		{"yaho.com", map[DomainLabel][]string{EDIT_DISTANCE: {"yahoo.com"}}},
+		{"yah.com", map[DomainLabel][]string{}},
+	}
+
+	for _, test := range tests {
+		if got := labeler.LabelDomain(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("LabelDomain(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEditDistanceLabelerOrder(t *testing.T) {
+	// labels in order, each label's base domains in config order; payal is two
+	// edits away but only five letters long, so allows one
+	baseDomains := []string{"paypal.com", "paypal.co.uk", "gaypal.com", "payal.com", "pappal.com"}
+	labeler := NewEditDistanceLabeler(&baseDomains, 2)
+	want := []string{"gaypal.com", "pappal.com", "paypal.com", "paypal.co.uk"}
+
+	for run := 0; run < 20; run++ {
+		if got := labeler.LabelDomain("paypa.net")[EDIT_DISTANCE]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("LabelDomain(paypa.net) = %v, want %v", got, want)
+		}
+	}
+}
+
+// Registrable labels of the bundled top 100k list, skipping names that are public suffixes themselves
+func loadBenchmarkDomains(b *testing.B) []string {
+	f, err := os.Open("domainlists/alexa-top-100k-20200526.csv")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer f.Close()
+
+	domains := make([]string, 0, 100000)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		domain := scanner.Text()[strings.Index(scanner.Text(), ",")+1:]
+		if _, _, err := splitRegistrableLabel(domain); err == nil {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+var benchmarkQueries = []string{"gogle.com", "paypa1l.com", "amazn.co.uk", "faceb00k.net", "login-microsoft.com", "xn--ggle-55da.com", "wikipedia.org", "tmal.com"}
+
+func BenchmarkEditDistanceLabeler(b *testing.B) {
+	domains := loadBenchmarkDomains(b)
+	labeler := NewEditDistanceLabeler(&domains, 2)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		labeler.LabelDomain(benchmarkQueries[i%len(benchmarkQueries)])
+	}
+}
+
+// Comparing against every label, what the deletion index saves
+func BenchmarkLinearSearch(b *testing.B) {
+	domains := loadBenchmarkDomains(b)
+	labels := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		label, _, _ := splitRegistrableLabel(domain)
+		labels = append(labels, label)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		label, _, _ := splitRegistrableLabel(benchmarkQueries[i%len(benchmarkQueries)])
+		linearSearch(labels, label, 2)
+	}
+}
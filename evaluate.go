@@ -0,0 +1,258 @@
+package certificate_searcher
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// What a ground-truth hostname really is
+type GroundTruthClass string
+
+const (
+	MALICIOUS GroundTruthClass = "malicious"
+	// Owned by the brand it resembles
+	BENIGN GroundTruthClass = "benign"
+	// Resembles no base domain
+	UNRELATED GroundTruthClass = "unrelated"
+)
+
+// Stands in for "no label" in the confusion matrix
+const noLabel = "NONE"
+
+type GroundTruthEntry struct {
+	Hostname string
+	Class    GroundTruthClass
+	// Labels a correct labeler set assigns, empty for benign and unrelated hostnames
+	Labels []DomainLabel
+}
+
+/*
+Reads a ground-truth CSV of hostname,class,labels rows, where class is malicious, benign or
+unrelated and labels is a |-separated list of expected label names (TYPOSQUATTING_CHAR_OMISSION).
+Lines starting with # and a leading hostname,class,labels header are skipped.
+*/
+func LoadGroundTruth(path string) ([]GroundTruthEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	entries := make([]GroundTruthEntry, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && strings.EqualFold(record[0], "hostname") {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected hostname,class[,labels]", path, line)
+		}
+
+		entry := GroundTruthEntry{
+			Hostname: strings.ToLower(strings.TrimSpace(record[0])),
+			Class:    GroundTruthClass(strings.ToLower(strings.TrimSpace(record[1]))),
+			Labels:   make([]DomainLabel, 0),
+		}
+		switch entry.Class {
+		case MALICIOUS, BENIGN, UNRELATED:
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown class %s", path, line, record[1])
+		}
+
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			for _, name := range strings.Split(record[2], "|") {
+				label, err := ParseDomainLabel(strings.TrimSpace(name))
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s", path, line, err.Error())
+				}
+				entry.Labels = append(entry.Labels, label)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+type LabelMetrics struct {
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	FalseNegatives int     `json:"false_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1             float64 `json:"f1"`
+}
+
+func (m *LabelMetrics) finish() {
+	if m.TruePositives+m.FalsePositives > 0 {
+		m.Precision = float64(m.TruePositives) / float64(m.TruePositives+m.FalsePositives)
+	}
+	if m.TruePositives+m.FalseNegatives > 0 {
+		m.Recall = float64(m.TruePositives) / float64(m.TruePositives+m.FalseNegatives)
+	}
+	if m.Precision+m.Recall > 0 {
+		m.F1 = 2 * m.Precision * m.Recall / (m.Precision + m.Recall)
+	}
+}
+
+type ClassCounts struct {
+	Hostnames int `json:"hostnames"`
+	// Hostnames given at least one label
+	Flagged int `json:"flagged"`
+	// Hostnames with a match suppressed by the allowlist
+	Suppressed int `json:"suppressed"`
+}
+
+type EvaluationReport struct {
+	Created   time.Time                         `json:"created"`
+	Config    *Config                           `json:"config,omitempty"`
+	Hostnames int                               `json:"hostnames"`
+	Classes   map[GroundTruthClass]*ClassCounts `json:"classes"`
+	// Malicious hostnames flagged with any label count as true positives, others as false positives
+	Detection LabelMetrics             `json:"detection"`
+	Labels    map[string]*LabelMetrics `json:"labels"`
+	// Expected label -> assigned label -> hostnames, with NONE for no label on either side
+	Confusion map[string]map[string]int `json:"confusion"`
+
+	Seconds            float64            `json:"seconds"`
+	HostnamesPerSecond float64            `json:"hostnames_per_second"`
+	LabelerSeconds     map[string]float64 `json:"labeler_seconds"`
+}
+
+func labelerName(labeler DomainLabeler) string {
+	t := reflect.TypeOf(labeler)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+/*
+Runs labelers over every ground-truth hostname and scores the labels they assign against the
+expected ones. Matches allowlist (which may be nil) suppresses do not count as assigned.
+*/
+func Evaluate(entries []GroundTruthEntry, labelers []DomainLabeler, allowlist *Allowlist) *EvaluationReport {
+	report := &EvaluationReport{
+		Created:        time.Now().UTC(),
+		Hostnames:      len(entries),
+		Classes:        make(map[GroundTruthClass]*ClassCounts),
+		Labels:         make(map[string]*LabelMetrics),
+		Confusion:      make(map[string]map[string]int),
+		LabelerSeconds: make(map[string]float64),
+	}
+	for _, class := range []GroundTruthClass{MALICIOUS, BENIGN, UNRELATED} {
+		report.Classes[class] = &ClassCounts{}
+	}
+
+	metrics := func(label string) *LabelMetrics {
+		if _, present := report.Labels[label]; !present {
+			report.Labels[label] = &LabelMetrics{}
+		}
+		return report.Labels[label]
+	}
+	confuse := func(expected, assigned string) {
+		if _, present := report.Confusion[expected]; !present {
+			report.Confusion[expected] = make(map[string]int)
+		}
+		report.Confusion[expected][assigned] += 1
+	}
+
+	start := time.Now()
+	for _, entry := range entries {
+		assigned := make(map[DomainLabel]struct{})
+		suppressed := false
+		for _, labeler := range labelers {
+			labelerStart := time.Now()
+			labels := labeler.LabelDomain(entry.Hostname)
+			report.LabelerSeconds[labelerName(labeler)] += time.Since(labelerStart).Seconds()
+
+			if allowlist != nil {
+				var suppressedLabels map[DomainLabel][]string
+				labels, suppressedLabels = allowlist.Filter(entry.Hostname, labels, nil)
+				suppressed = suppressed || len(suppressedLabels) > 0
+			}
+			for label := range labels {
+				assigned[label] = struct{}{}
+			}
+		}
+
+		expected := make(map[DomainLabel]struct{})
+		for _, label := range entry.Labels {
+			expected[label] = struct{}{}
+		}
+
+		counts := report.Classes[entry.Class]
+		counts.Hostnames += 1
+		if suppressed {
+			counts.Suppressed += 1
+		}
+		if len(assigned) > 0 {
+			counts.Flagged += 1
+			if entry.Class == MALICIOUS {
+				report.Detection.TruePositives += 1
+			} else {
+				report.Detection.FalsePositives += 1
+			}
+		} else if entry.Class == MALICIOUS {
+			report.Detection.FalseNegatives += 1
+		}
+
+		for label := range assigned {
+			if _, present := expected[label]; present {
+				metrics(label.String()).TruePositives += 1
+			} else {
+				metrics(label.String()).FalsePositives += 1
+			}
+		}
+		for label := range expected {
+			if _, present := assigned[label]; !present {
+				metrics(label.String()).FalseNegatives += 1
+			}
+		}
+
+		for label := range expected {
+			if len(assigned) == 0 {
+				confuse(label.String(), noLabel)
+			}
+			for assignedLabel := range assigned {
+				confuse(label.String(), assignedLabel.String())
+			}
+		}
+		if len(expected) == 0 {
+			if len(assigned) == 0 {
+				confuse(noLabel, noLabel)
+			}
+			for assignedLabel := range assigned {
+				confuse(noLabel, assignedLabel.String())
+			}
+		}
+	}
+	report.Seconds = time.Since(start).Seconds()
+	if report.Seconds > 0 {
+		report.HostnamesPerSecond = float64(len(entries)) / report.Seconds
+	}
+
+	report.Detection.finish()
+	for _, labelMetrics := range report.Labels {
+		labelMetrics.finish()
+	}
+
+	return report
+}
@@ -0,0 +1,182 @@
+package certificate_searcher
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Assigns fixed labels to hostnames
+type fixedLabeler map[string]map[DomainLabel][]string
+
+func (f fixedLabeler) LabelDomain(domain string) map[DomainLabel][]string {
+	if labels, present := f[domain]; present {
+		return labels
+	}
+	return make(map[DomainLabel][]string)
+}
+
+func TestLoadGroundTruth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groundtruth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("truth.csv", "hostname,class,labels\n"+
+		"# typos\n"+
+		"Gogle.com,malicious,TYPOSQUATTING_CHAR_OMISSION\n"+
+		"paypal.com.evil.net, Malicious, TARGET_EMBEDDING | COMBOSQUATTING\n"+
+		"googel.com,benign\n"+
+		"example.com,unrelated,\n")
+	entries, err := LoadGroundTruth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GroundTruthEntry{
+		{Hostname: "gogle.com", Class: MALICIOUS, Labels: []DomainLabel{TYPOSQUATTING_CHAR_OMISSION}},
+		{Hostname: "paypal.com.evil.net", Class: MALICIOUS, Labels: []DomainLabel{TARGET_EMBEDDING, COMBOSQUATTING}},
+		{Hostname: "googel.com", Class: BENIGN, Labels: []DomainLabel{}},
+		{Hostname: "example.com", Class: UNRELATED, Labels: []DomainLabel{}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("LoadGroundTruth() = %+v, want %+v", entries, want)
+	}
+
+	errorTests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"missing class", "gogle.com\n", "expected hostname,class"},
+		{"unknown class", "gogle.com,phishing\n", "unknown class phishing"},
+		{"unknown label", "gogle.com,malicious,TYPO\n", "truth.csv:1"},
+		{"error line", "gogle.com,malicious\ngoogel.com,owned\n", "truth.csv:2"},
+	}
+	for _, test := range errorTests {
+		_, err := LoadGroundTruth(write("truth.csv", test.contents))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: LoadGroundTruth() error = %v, want it to mention %s", test.name, err, test.want)
+		}
+	}
+	if _, err := LoadGroundTruth(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("LoadGroundTruth() of a missing file succeeded")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	entries := []GroundTruthEntry{
+		{Hostname: "gogle.com", Class: MALICIOUS, Labels: []DomainLabel{TYPOSQUATTING_CHAR_OMISSION}},
+		{Hostname: "paypa1.com", Class: MALICIOUS, Labels: []DomainLabel{HOMOGRAPH}},
+		{Hostname: "paypal.com.evil.net", Class: MALICIOUS, Labels: []DomainLabel{TARGET_EMBEDDING}},
+		{Hostname: "googel.com", Class: BENIGN, Labels: []DomainLabel{}},
+		{Hostname: "bbc.com", Class: UNRELATED, Labels: []DomainLabel{}},
+		{Hostname: "shop-google.net", Class: UNRELATED, Labels: []DomainLabel{}},
+	}
+	labeler := fixedLabeler{
+		"gogle.com":       {TYPOSQUATTING_CHAR_OMISSION: {"google.com"}},
+		"paypa1.com":      {TYPOSQUATTING_CHAR_SUBSTITUTION: {"paypal.com"}},
+		"googel.com":      {TYPOSQUATTING_CHAR_PERMUTATION: {"google.com"}},
+		"shop-google.net": {TARGET_EMBEDDING: {"google.com"}},
+	}
+
+	report := Evaluate(entries, []DomainLabeler{labeler}, testAllowlist())
+
+	wantClasses := map[GroundTruthClass]*ClassCounts{
+		MALICIOUS: {Hostnames: 3, Flagged: 2},
+		// googel.com is owned by Google, so its match is suppressed
+		BENIGN:    {Hostnames: 1, Suppressed: 1},
+		UNRELATED: {Hostnames: 2, Flagged: 1},
+	}
+	if !reflect.DeepEqual(report.Classes, wantClasses) {
+		t.Errorf("Classes = %+v, want %+v", report.Classes, wantClasses)
+	}
+
+	third := 1.0 / 3
+	metrics := func(tp, fp, fn int, precision, recall float64) LabelMetrics {
+		m := LabelMetrics{TruePositives: tp, FalsePositives: fp, FalseNegatives: fn, Precision: precision, Recall: recall}
+		if precision+recall > 0 {
+			m.F1 = 2 * precision * recall / (precision + recall)
+		}
+		return m
+	}
+	equal := func(got, want LabelMetrics) bool {
+		return got.TruePositives == want.TruePositives && got.FalsePositives == want.FalsePositives &&
+			got.FalseNegatives == want.FalseNegatives && math.Abs(got.Precision-want.Precision) < 1e-9 &&
+			math.Abs(got.Recall-want.Recall) < 1e-9 && math.Abs(got.F1-want.F1) < 1e-9
+	}
+
+	if want := metrics(2, 1, 1, 2*third, 2*third); !equal(report.Detection, want) {
+		t.Errorf("Detection = %+v, want %+v", report.Detection, want)
+	}
+
+	wantLabels := map[string]LabelMetrics{
+		"TYPOSQUATTING_CHAR_OMISSION":     metrics(1, 0, 0, 1, 1),
+		"TYPOSQUATTING_CHAR_SUBSTITUTION": metrics(0, 1, 0, 0, 0),
+		"HOMOGRAPH":                       metrics(0, 0, 1, 0, 0),
+		"TARGET_EMBEDDING":                metrics(0, 1, 1, 0, 0),
+	}
+	if len(report.Labels) != len(wantLabels) {
+		t.Errorf("Labels = %v, want %d labels", report.Labels, len(wantLabels))
+	}
+	for label, want := range wantLabels {
+		if got, present := report.Labels[label]; !present || !equal(*got, want) {
+			t.Errorf("Labels[%s] = %+v, want %+v", label, got, want)
+		}
+	}
+
+	wantConfusion := map[string]map[string]int{
+		"TYPOSQUATTING_CHAR_OMISSION": {"TYPOSQUATTING_CHAR_OMISSION": 1},
+		"HOMOGRAPH":                   {"TYPOSQUATTING_CHAR_SUBSTITUTION": 1},
+		"TARGET_EMBEDDING":            {noLabel: 1},
+		noLabel:                       {noLabel: 2, "TARGET_EMBEDDING": 1},
+	}
+	if !reflect.DeepEqual(report.Confusion, wantConfusion) {
+		t.Errorf("Confusion = %v, want %v", report.Confusion, wantConfusion)
+	}
+
+	if report.Hostnames != len(entries) {
+		t.Errorf("Hostnames = %d, want %d", report.Hostnames, len(entries))
+	}
+	if _, present := report.LabelerSeconds["fixedLabeler"]; !present || len(report.LabelerSeconds) != 1 {
+		t.Errorf("LabelerSeconds = %v, want fixedLabeler only", report.LabelerSeconds)
+	}
+
+	// without an allowlist the owned domain is a false positive
+	report = Evaluate(entries, []DomainLabeler{labeler}, nil)
+	if want := (ClassCounts{Hostnames: 1, Flagged: 1}); *report.Classes[BENIGN] != want {
+		t.Errorf("Classes[benign] without allowlist = %+v, want %+v", *report.Classes[BENIGN], want)
+	}
+	if report.Detection.FalsePositives != 2 {
+		t.Errorf("Detection.FalsePositives without allowlist = %d, want 2", report.Detection.FalsePositives)
+	}
+}
+
+func TestLabelerName(t *testing.T) {
+	baseDomains := []string{"google.com"}
+	tests := []struct {
+		labeler DomainLabeler
+		want    string
+	}{
+		{NewTargetEmbeddingLabeler(&baseDomains), "TargetEmbeddingLabeler"},
+		{fixedLabeler{}, "fixedLabeler"},
+	}
+
+	for _, test := range tests {
+		if got := labelerName(test.labeler); got != test.want {
+			t.Errorf("labelerName(%T) = %s, want %s", test.labeler, got, test.want)
+		}
+	}
+}
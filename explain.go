@@ -0,0 +1,358 @@
+package certificate_searcher
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/runenames"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+type MutationOperationType string
+
+const (
+	SUBSTITUTION  MutationOperationType = "substitution"
+	OMISSION      MutationOperationType = "omission"
+	INSERTION     MutationOperationType = "insertion"
+	TRANSPOSITION MutationOperationType = "transposition"
+	BIT_FLIP      MutationOperationType = "bit_flip"
+	HOMOGLYPH     MutationOperationType = "homoglyph"
+	SUFFIX_SWAP   MutationOperationType = "suffix_swap"
+	EMBEDDING     MutationOperationType = "embedding"
+)
+
+/*
+One edit turning a base domain into a labeled name. Position is the rune index in the base domain
+the edit applies at (insertions go before it), except for embeddings, where it is the rune index
+in the name at which the base domain starts.
+*/
+type MutationOperation struct {
+	Operation MutationOperationType `json:"operation"`
+	Position  int                   `json:"position"`
+	From      string                `json:"from,omitempty"`
+	To        string                `json:"to,omitempty"`
+	// Single bit flipped between From and To, for BIT_FLIP
+	BitMask uint8 `json:"bit_mask,omitempty"`
+}
+
+// A character as 'c' (U+0063 LATIN SMALL LETTER C), or just quoted if s is not a single rune
+func describeRunes(s string) string {
+	runes := []rune(s)
+	if len(runes) != 1 {
+		return fmt.Sprintf("'%s'", s)
+	}
+	return fmt.Sprintf("'%c' (%U %s)", runes[0], runes[0], runenames.Name(runes[0]))
+}
+
+func (op MutationOperation) String() string {
+	switch op.Operation {
+	case SUBSTITUTION, HOMOGLYPH:
+		return fmt.Sprintf("%s: %s -> %s at position %d", op.Operation, describeRunes(op.From), describeRunes(op.To), op.Position)
+	case BIT_FLIP:
+		return fmt.Sprintf("%s: bit %d (0x%02x) of %s -> %s at position %d", op.Operation, bits.TrailingZeros8(op.BitMask), op.BitMask,
+			describeRunes(op.From), describeRunes(op.To), op.Position)
+	case OMISSION:
+		return fmt.Sprintf("%s: %s at position %d", op.Operation, describeRunes(op.From), op.Position)
+	case INSERTION:
+		return fmt.Sprintf("%s: %s at position %d", op.Operation, describeRunes(op.To), op.Position)
+	case TRANSPOSITION:
+		return fmt.Sprintf("%s: '%s' -> '%s' at position %d", op.Operation, op.From, op.To, op.Position)
+	case SUFFIX_SWAP:
+		return fmt.Sprintf("%s: .%s -> .%s at position %d", op.Operation, op.From, op.To, op.Position)
+	case EMBEDDING:
+		return fmt.Sprintf("%s: %s inside %s at position %d", op.Operation, op.From, op.To, op.Position)
+	}
+	return fmt.Sprintf("%s at position %d", op.Operation, op.Position)
+}
+
+/*
+A minimal sequence of edits turning base into name, from an optimal string alignment of their
+runes. Runs of adjacent insertions or omissions are merged into one operation.
+*/
+func EditOperations(base, name string) []MutationOperation {
+	a, b := []rune(base), []rune(name)
+
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(b); j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j-1]+cost, d[i-1][j]+1, d[i][j-1]+1)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	// walk back from the end, collecting operations in reverse
+	reversed := make([]MutationOperation, 0)
+	i, j := len(a), len(b)
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && a[i-1] == b[j-1] && d[i][j] == d[i-1][j-1]:
+			i, j = i-1, j-1
+		case i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i][j] == d[i-2][j-2]+1:
+			reversed = append(reversed, MutationOperation{Operation: TRANSPOSITION, Position: i - 2, From: string(a[i-2 : i]), To: string(b[j-2 : j])})
+			i, j = i-2, j-2
+		case i > 0 && j > 0 && d[i][j] == d[i-1][j-1]+1:
+			reversed = append(reversed, MutationOperation{Operation: SUBSTITUTION, Position: i - 1, From: string(a[i-1]), To: string(b[j-1])})
+			i, j = i-1, j-1
+		case i > 0 && d[i][j] == d[i-1][j]+1:
+			reversed = append(reversed, MutationOperation{Operation: OMISSION, Position: i - 1, From: string(a[i-1])})
+			i--
+		default:
+			reversed = append(reversed, MutationOperation{Operation: INSERTION, Position: i, To: string(b[j-1])})
+			j--
+		}
+	}
+
+	ops := make([]MutationOperation, 0, len(reversed))
+	for k := len(reversed) - 1; k >= 0; k-- {
+		op := reversed[k]
+		if len(ops) > 0 {
+			last := &ops[len(ops)-1]
+			if op.Operation == INSERTION && last.Operation == INSERTION && last.Position == op.Position {
+				last.To += op.To
+				continue
+			}
+			if op.Operation == OMISSION && last.Operation == OMISSION && last.Position+len([]rune(last.From)) == op.Position {
+				last.From += op.From
+				continue
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// EditOperations with substitutions reported as homoglyphs
+func homoglyphOperations(base, name string) []MutationOperation {
+	ops := EditOperations(base, name)
+	for idx, op := range ops {
+		if op.Operation == SUBSTITUTION {
+			ops[idx].Operation = HOMOGLYPH
+		}
+	}
+	return ops
+}
+
+// Moves the positions of ops into a string that starts offset runes earlier
+func shiftOperations(ops []MutationOperation, offset int) []MutationOperation {
+	for idx := range ops {
+		ops[idx].Position += offset
+	}
+	return ops
+}
+
+/*
+The operations that turn baseDomain into name for a match the labeler reported under label.
+For IDN confusables the operations are those of the confusable label against the registrable
+label of baseDomain. Labels without a base domain (blocklists) have no operations.
+*/
+func ExplainMatch(label DomainLabel, name string, baseDomain string) []MutationOperation {
+	name = strings.TrimPrefix(name, "*.")
+
+	switch label {
+	case BITSQUATTING:
+		ops := EditOperations(baseDomain, name)
+		for idx, op := range ops {
+			if op.Operation != SUBSTITUTION || len(op.From) != 1 || len(op.To) != 1 {
+				continue
+			}
+			if mask := op.From[0] ^ op.To[0]; bits.OnesCount8(mask) == 1 {
+				ops[idx].Operation = BIT_FLIP
+				ops[idx].BitMask = mask
+			}
+		}
+		return ops
+
+	case HOMOGRAPH:
+		unicodeName, _ := UnicodeHostname(name)
+		return homoglyphOperations(baseDomain, unicodeName)
+
+	case IDN_CONFUSABLE:
+		// the flagged label against the registrable label of baseDomain it is confusable with
+		baseLabel, err := baseRegistrableLabel(baseDomain)
+		if err != nil {
+			return nil
+		}
+		punyBase, _ := idna.ToASCII(baseDomain)
+		baseSuffix, _ := PublicSuffix(punyBase)
+		unicodeBase, _ := UnicodeHostname(punyBase)
+		unicodeSuffix, _ := UnicodeHostname(baseSuffix)
+		labelStart := len([]rune(unicodeBase)) - len([]rune(unicodeSuffix)) - 1 - len([]rune(baseLabel))
+		for _, label := range strings.Split(name, ".") {
+			uLabel, err := idna.ToUnicode(label)
+			if err != nil || !strings.HasPrefix(label, "xn--") || !ConfusableWith(uLabel, baseLabel) {
+				continue
+			}
+			return shiftOperations(homoglyphOperations(baseLabel, uLabel), labelStart)
+		}
+		return nil
+
+	case WRONGTLD, WRONGTLD_PRIVATE:
+		baseSuffix, _ := PublicSuffix(baseDomain)
+		nameSuffix, _ := PublicSuffix(name)
+		unicodeSuffix, _ := UnicodeHostname(nameSuffix)
+		return []MutationOperation{{
+			Operation: SUFFIX_SWAP,
+			Position:  len([]rune(baseDomain)) - len([]rune(baseSuffix)),
+			From:      baseSuffix,
+			To:        unicodeSuffix,
+		}}
+
+	case TARGET_EMBEDDING:
+		idx := embeddingIndex(name, baseDomain)
+		if idx < 0 {
+			return nil
+		}
+		return []MutationOperation{{
+			Operation: EMBEDDING,
+			Position:  len([]rune(name[:idx])),
+			From:      baseDomain,
+			To:        name,
+		}}
+
+	case EDIT_DISTANCE:
+		// distances are measured between the registrable labels, the suffixes may differ too
+		baseLabel, baseSuffix, err := splitRegistrableLabel(baseDomain)
+		if err != nil {
+			return nil
+		}
+		nameLabel, nameSuffix, err := splitRegistrableLabel(name)
+		if err != nil {
+			return nil
+		}
+		labelStart := len([]rune(baseDomain)) - len([]rune(baseSuffix)) - 1 - len([]rune(baseLabel))
+		ops := shiftOperations(EditOperations(baseLabel, nameLabel), labelStart)
+		if nameSuffix != baseSuffix {
+			unicodeSuffix, _ := UnicodeHostname(nameSuffix)
+			ops = append(ops, MutationOperation{
+				Operation: SUFFIX_SWAP,
+				Position:  len([]rune(baseDomain)) - len([]rune(baseSuffix)),
+				From:      baseSuffix,
+				To:        unicodeSuffix,
+			})
+		}
+		return ops
+
+	case TYPOSQUATTING_MISSING_DOT_SUFFIX:
+		ops := EditOperations(baseDomain, name)
+		if len(ops) <= 1 {
+			return ops
+		}
+
+		// googlecom.xyz: the dots of the base suffix dropped, then registered under another suffix
+		baseETLDplus1, err := EffectiveTLDPlusOne(baseDomain)
+		if err != nil {
+			return ops
+		}
+		nameETLDplus1, err := EffectiveTLDPlusOne(name)
+		if err != nil {
+			return ops
+		}
+		nameSuffix, _ := PublicSuffix(name)
+		registrableLabel := strings.TrimSuffix(nameETLDplus1, "."+nameSuffix)
+
+		offset := len([]rune(baseDomain)) - len([]rune(baseETLDplus1))
+		ops = shiftOperations(EditOperations(baseETLDplus1, registrableLabel), offset)
+		return append(ops, MutationOperation{Operation: INSERTION, Position: len([]rune(baseDomain)), To: "." + nameSuffix})
+	}
+
+	if _, present := TypoSquattingLabels[label]; present {
+		return EditOperations(baseDomain, name)
+	}
+	return nil
+}
+
+// Everything one labeler reported for a name under one label and source, and how it came about
+type MatchExplanation struct {
+	Label DomainLabel
+	// Base domain matched, or the labeler's source string for labels without one
+	Source     string
+	Operations []MutationOperation
+	Detail     *MatchDetail
+	// Brand whose allowlist suppressed this match, if any
+	SuppressedBy string
+}
+
+/*
+Runs every labeler on name and explains each match, including those allowlist (which may be nil)
+suppresses. Results are ordered by label and source.
+*/
+func Explain(name string, labelers []DomainLabeler, allowlist *Allowlist) []MatchExplanation {
+	explanations := make([]MatchExplanation, 0)
+
+	for _, labeler := range labelers {
+		var labels map[DomainLabel][]string
+		var details map[DomainLabel][]MatchDetail
+		if detailedLabeler, ok := labeler.(DetailedDomainLabeler); ok {
+			details = detailedLabeler.LabelDomainDetails(name)
+			labels = DetailLabels(details)
+		} else {
+			labels = labeler.LabelDomain(name)
+		}
+
+		for label, sources := range labels {
+			// matches without a base domain, such as IDN confusables of no base domain
+			unsourced := 0
+			for idx, detail := range details[label] {
+				if detail.BaseDomain != "" {
+					continue
+				}
+				explanation := MatchExplanation{Label: label, Detail: &details[label][idx]}
+				if detail.Skeleton != "" {
+					explanation.Operations = homoglyphOperations(detail.Skeleton, detail.ConfusableLabel)
+				}
+				explanations = append(explanations, explanation)
+				unsourced++
+			}
+			if len(sources) == 0 {
+				if unsourced == 0 {
+					explanations = append(explanations, MatchExplanation{Label: label})
+				}
+				continue
+			}
+
+			for _, source := range sources {
+				explanation := MatchExplanation{
+					Label:      label,
+					Source:     source,
+					Operations: ExplainMatch(label, name, source),
+				}
+				for idx := range details[label] {
+					if details[label][idx].BaseDomain == source {
+						explanation.Detail = &details[label][idx]
+						break
+					}
+				}
+				if allowlist != nil {
+					if brand := allowlist.OwningBrand(name, source, nil); brand != nil {
+						explanation.SuppressedBy = brand.Brand
+					}
+				}
+				explanations = append(explanations, explanation)
+			}
+		}
+	}
+
+	sort.SliceStable(explanations, func(i, j int) bool {
+		if explanations[i].Label != explanations[j].Label {
+			return explanations[i].Label < explanations[j].Label
+		}
+		return explanations[i].Source < explanations[j].Source
+	})
+
+	return explanations
+}
@@ -0,0 +1,82 @@
+package certificate_searcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditOperations(t *testing.T) {
+	tests := []struct {
+		base, name string
+		want       []MutationOperation
+	}{
+		{"google", "google", []MutationOperation{}},
+		// a repeated letter is edited at its first position
+		{"google", "gogle", []MutationOperation{{Operation: OMISSION, Position: 1, From: "o"}}},
+		{"google", "gooogle", []MutationOperation{{Operation: INSERTION, Position: 1, To: "o"}}},
+		{"google", "goigle", []MutationOperation{{Operation: SUBSTITUTION, Position: 2, From: "o", To: "i"}}},
+		{"google", "gogole", []MutationOperation{{Operation: TRANSPOSITION, Position: 2, From: "og", To: "go"}}},
+		// adjacent insertions and omissions are merged
+		{"paypal", "secure-paypal", []MutationOperation{{Operation: INSERTION, Position: 0, To: "secure-"}}},
+		{"secure-paypal", "paypal", []MutationOperation{{Operation: OMISSION, Position: 0, From: "secure-"}}},
+	}
+
+	for _, test := range tests {
+		if got := EditOperations(test.base, test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("EditOperations(%q, %q) = %v, want %v", test.base, test.name, got, test.want)
+		}
+	}
+}
+
+func TestExplainMatch(t *testing.T) {
+	tests := []struct {
+		label            DomainLabel
+		name, baseDomain string
+		want             []MutationOperation
+	}{
+		{TYPOSQUATTING_CHAR_OMISSION, "gogle.com", "google.com", []MutationOperation{{Operation: OMISSION, Position: 1, From: "o"}}},
+		{TYPOSQUATTING_CHAR_PERMUTATION, "*.goolge.com", "google.com", []MutationOperation{{Operation: TRANSPOSITION, Position: 3, From: "gl", To: "lg"}}},
+		{BITSQUATTING, "foogle.com", "google.com", []MutationOperation{{Operation: BIT_FLIP, Position: 0, From: "g", To: "f", BitMask: 0x01}}},
+		{HOMOGRAPH, "xn--ggle-55da.com", "google.com", []MutationOperation{
+			{Operation: HOMOGLYPH, Position: 1, From: "o", To: "о"},
+			{Operation: HOMOGLYPH, Position: 2, From: "o", To: "о"},
+		}},
+		{WRONGTLD, "google.co.uk", "google.com", []MutationOperation{{Operation: SUFFIX_SWAP, Position: 7, From: "com", To: "co.uk"}}},
+		{WRONGTLD_PRIVATE, "google.github.io", "google.com", []MutationOperation{{Operation: SUFFIX_SWAP, Position: 7, From: "com", To: "github.io"}}},
+		{TARGET_EMBEDDING, "google.com.evil.net", "google.com", []MutationOperation{{Operation: EMBEDDING, Position: 0, From: "google.com", To: "google.com.evil.net"}}},
+		{TARGET_EMBEDDING, "login-google.com-secure.net", "google.com", []MutationOperation{{Operation: EMBEDDING, Position: 6, From: "google.com", To: "login-google.com-secure.net"}}},
+		// the first occurrence is not on a label boundary, so it is not the one labeled
+		{TARGET_EMBEDDING, "xgoogle.com.google.com.evil.net", "google.com", []MutationOperation{{Operation: EMBEDDING, Position: 12, From: "google.com", To: "xgoogle.com.google.com.evil.net"}}},
+		{TARGET_EMBEDDING, "xgoogle.com.evil.net", "google.com", nil},
+		{EDIT_DISTANCE, "gogle.net", "google.com", []MutationOperation{
+			{Operation: OMISSION, Position: 1, From: "o"},
+			{Operation: SUFFIX_SWAP, Position: 7, From: "com", To: "net"},
+		}},
+		{EDIT_DISTANCE, "login.paypa1l.co.uk", "paypal.com", []MutationOperation{
+			{Operation: INSERTION, Position: 5, To: "1"},
+			{Operation: SUFFIX_SWAP, Position: 7, From: "com", To: "co.uk"},
+		}},
+		{PHISHTANK, "evil.net", "", nil},
+	}
+
+	for _, test := range tests {
+		if got := ExplainMatch(test.label, test.name, test.baseDomain); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExplainMatch(%s, %q, %q) = %v, want %v", test.label, test.name, test.baseDomain, got, test.want)
+		}
+	}
+}
+
+func TestExplainTargetEmbedding(t *testing.T) {
+	baseDomains := []string{"google.com"}
+	labelers := []DomainLabeler{NewTargetEmbeddingLabeler(&baseDomains)}
+
+	got := Explain("xgoogle.com.google.com.evil.net", labelers, nil)
+	want := []MatchExplanation{{
+		Label:      TARGET_EMBEDDING,
+		Source:     "google.com",
+		Operations: []MutationOperation{{Operation: EMBEDDING, Position: 12, From: "google.com", To: "xgoogle.com.google.com.evil.net"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Explain = %+v, want %+v", got, want)
+	}
+}
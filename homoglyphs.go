@@ -17,6 +17,9 @@ import (
 )
 
 var GLYPH_TO_ASCII map[string][]rune
+
+// The one prototype of each glyph in the Unicode confusables, which Skeleton uses
+var GLYPH_PROTOTYPE map[string]rune
 var ASCII_TO_GLYPH map[rune][]rune
 var ASCII_HOMOGLYPHS []rune
 
@@ -29,6 +32,23 @@ func runesContain(runes []rune, target rune) bool {
 	return false
 }
 
+// Whether a is the better prototype: letters before digits before anything else, then the smaller
+func preferredPrototype(a, b rune) bool {
+	rank := func(r rune) int {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 0
+		case r >= '0' && r <= '9':
+			return 1
+		}
+		return 2
+	}
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+	return a < b
+}
+
 func indexesContain(ints []int, target int) bool {
 	for _, i := range ints {
 		if i == target {
@@ -118,6 +138,7 @@ func addAsciiString(ascii rune, glyph rune) {
 
 func init() {
 	GLYPH_TO_ASCII = make(map[string][]rune)
+	GLYPH_PROTOTYPE = make(map[string]rune)
 	ASCII_TO_GLYPH = make(map[rune][]rune)
 	ASCII_HOMOGLYPHS = make([]rune, 0)
 	// https://github.com/reinderien/mimic/blob/master/mimic/__init__.py
@@ -263,6 +284,11 @@ func init() {
 			}
 
 			addStringAscii(str, ascii)
+			if prototype := unicode.ToLower(ascii); validDomainChar(prototype) && !(len(str) == 1 && str[0] <= unicode.MaxASCII) {
+				if current, present := GLYPH_PROTOTYPE[str]; !present || preferredPrototype(prototype, current) {
+					GLYPH_PROTOTYPE[str] = prototype
+				}
+			}
 			//addAsciiString(ascii, []rune(str)[0])
 		}
 	}
@@ -371,4 +397,47 @@ func GenerateASCIIHomographs(mutations chan<- Mutation, unicodeDomain string, ma
 	go homoglyphPermutations(mutations, unicodeDomain, wg, 0, 0, maxHomoglyphSubs)
 	wg.Wait()
 	close(mutations)
-}
\ No newline at end of file
+}
+
+/*
+Maps each non-ASCII rune of unicodeDomain to its ASCII confusables and returns every
+resulting all-ASCII skeleton. ASCII domain characters are kept as they are. Skeletons are
+built left to right, and a partial skeleton is abandoned as soon as keepPrefix rejects it,
+so callers holding a prefix set of their targets never enumerate the full cross product.
+*/
+func ASCIISkeletons(unicodeDomain string, keepPrefix func(prefix string) bool) []string {
+	domainRunes := []rune(unicodeDomain)
+	skeletons := make([]string, 0)
+	skeleton := make([]rune, 0, len(domainRunes))
+
+	var walk func(idx int)
+	walk = func(idx int) {
+		if idx > 0 && keepPrefix != nil && !keepPrefix(string(skeleton)) {
+			return
+		}
+		if idx == len(domainRunes) {
+			skeletons = append(skeletons, string(skeleton))
+			return
+		}
+
+		r := domainRunes[idx]
+		if r <= unicode.MaxASCII {
+			if !validDomainChar(r) {
+				return
+			}
+			skeleton = append(skeleton, unicode.ToLower(r))
+			walk(idx + 1)
+			skeleton = skeleton[:len(skeleton)-1]
+			return
+		}
+
+		for _, ascii := range GLYPH_TO_ASCII[string(r)] {
+			skeleton = append(skeleton, ascii)
+			walk(idx + 1)
+			skeleton = skeleton[:len(skeleton)-1]
+		}
+	}
+	walk(0)
+
+	return skeletons
+}
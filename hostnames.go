@@ -1,5 +1,11 @@
 package certificate_searcher
 
+import (
+	"golang.org/x/net/idna"
+	"strings"
+	"unicode"
+)
+
 var asciiLowerValidHostnameChars = map[rune]struct{}{
 	'a': {},
 	'b': {},
@@ -40,8 +46,6 @@ var asciiLowerValidHostnameChars = map[rune]struct{}{
 	'-': {},
 }
 
-
-
 func ValidHostname(s string) bool {
 	hostnameRunes := []rune(s)
 	for _, r := range hostnameRunes {
@@ -50,4 +54,28 @@ func ValidHostname(s string) bool {
 		}
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// Returns the Unicode form of an IDN hostname and whether it contained any non-ASCII characters
+func UnicodeHostname(hostname string) (string, bool) {
+	if !strings.Contains(hostname, "xn--") {
+		for _, r := range hostname {
+			if r > unicode.MaxASCII {
+				return hostname, true
+			}
+		}
+		return hostname, false
+	}
+
+	unicodeHostname, err := idna.ToUnicode(hostname)
+	if err != nil {
+		return hostname, false
+	}
+
+	for _, r := range unicodeHostname {
+		if r > unicode.MaxASCII {
+			return unicodeHostname, true
+		}
+	}
+	return unicodeHostname, false
+}
@@ -0,0 +1,159 @@
+package certificate_searcher
+
+import (
+	"fmt"
+	"github.com/cespare/xxhash"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// 2^14 registers, for a standard error of about 0.8%
+const hllPrecision uint = 14
+const hllRegisters int = 1 << hllPrecision
+
+// Largest rank a register can hold, with the guard bit capping the run of zeros
+const hllMaxRank uint8 = uint8(64-hllPrecision) + 1
+
+// Nonzero registers kept sparsely before switching to the dense array; 4 bytes each against 1 byte per register
+const hllSparseLimit int = hllRegisters / 8
+
+/*
+Estimates the number of distinct keys added to it. Two sketches merge into one estimating the
+distinct keys of both, which is what makes statistics of separate runs combinable. Small sketches
+only keep their nonzero registers, so the many parents with a handful of certificates stay cheap;
+a sketch switches to all 2^14 registers (16 KiB) once that is smaller.
+*/
+type HyperLogLog struct {
+	// Nonzero registers as index<<8 | rank, sorted by index, while Registers is nil
+	Sparse []uint32 `json:"sparse,omitempty"`
+	// All registers, once the sketch is dense
+	Registers []uint8 `json:"registers,omitempty"`
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+func (h *HyperLogLog) Add(key []byte) {
+	hash := xxhash.Sum64(key)
+	idx := int(hash >> (64 - hllPrecision))
+	// the guard bit caps the run of zeros at 64 - precision
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	h.setRegister(idx, rank)
+}
+
+// Raises register idx to rank
+func (h *HyperLogLog) setRegister(idx int, rank uint8) {
+	if h.Registers != nil {
+		if rank > h.Registers[idx] {
+			h.Registers[idx] = rank
+		}
+		return
+	}
+
+	pos := sort.Search(len(h.Sparse), func(i int) bool { return int(h.Sparse[i]>>8) >= idx })
+	if pos < len(h.Sparse) && int(h.Sparse[pos]>>8) == idx {
+		if rank > uint8(h.Sparse[pos]) {
+			h.Sparse[pos] = uint32(idx)<<8 | uint32(rank)
+		}
+		return
+	}
+
+	h.Sparse = append(h.Sparse, 0)
+	copy(h.Sparse[pos+1:], h.Sparse[pos:])
+	h.Sparse[pos] = uint32(idx)<<8 | uint32(rank)
+	if len(h.Sparse) > hllSparseLimit {
+		h.densify()
+	}
+}
+
+func (h *HyperLogLog) densify() {
+	h.Registers = make([]uint8, hllRegisters)
+	for _, entry := range h.Sparse {
+		h.Registers[entry>>8] = uint8(entry)
+	}
+	h.Sparse = nil
+}
+
+// Folds other into h, after which h estimates the distinct keys added to either
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if err := other.Validate(); err != nil {
+		return err
+	}
+
+	if other.Registers != nil {
+		if h.Registers == nil {
+			h.densify()
+		}
+		for idx, rank := range other.Registers {
+			if rank > h.Registers[idx] {
+				h.Registers[idx] = rank
+			}
+		}
+		return nil
+	}
+
+	for _, entry := range other.Sparse {
+		h.setRegister(int(entry>>8), uint8(entry))
+	}
+	return nil
+}
+
+// Checks a sketch read from a file: registers in range, and sparse entries sorted and distinct
+func (h *HyperLogLog) Validate() error {
+	if h.Registers != nil {
+		if len(h.Registers) != hllRegisters {
+			return fmt.Errorf("sketch has %d registers, expected %d", len(h.Registers), hllRegisters)
+		}
+		if len(h.Sparse) > 0 {
+			return fmt.Errorf("sketch has both sparse and dense registers")
+		}
+		for idx, rank := range h.Registers {
+			if rank > hllMaxRank {
+				return fmt.Errorf("sketch register %d has rank %d, at most %d is possible", idx, rank, hllMaxRank)
+			}
+		}
+		return nil
+	}
+
+	for pos, entry := range h.Sparse {
+		idx, rank := int(entry>>8), uint8(entry)
+		if idx >= hllRegisters || rank == 0 || rank > hllMaxRank {
+			return fmt.Errorf("sketch has invalid sparse register %#x", entry)
+		}
+		if pos > 0 && int(h.Sparse[pos-1]>>8) >= idx {
+			return fmt.Errorf("sketch sparse registers are not sorted by index")
+		}
+	}
+	return nil
+}
+
+// Uses linear counting while registers are still empty, where the raw estimate is biased
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	if h.Registers != nil {
+		for _, rank := range h.Registers {
+			sum += math.Ldexp(1, -int(rank))
+			if rank == 0 {
+				zeros++
+			}
+		}
+	} else {
+		zeros = hllRegisters - len(h.Sparse)
+		sum = float64(zeros)
+		for _, entry := range h.Sparse {
+			sum += math.Ldexp(1, -int(uint8(entry)))
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
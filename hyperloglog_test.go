@@ -0,0 +1,140 @@
+package certificate_searcher
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Sketch of the keys start..end-1
+func sketchOfRange(start, end int) *HyperLogLog {
+	h := NewHyperLogLog()
+	key := make([]byte, 8)
+	for i := start; i < end; i++ {
+		binary.BigEndian.PutUint64(key, uint64(i))
+		h.Add(key)
+	}
+	return h
+}
+
+func relativeError(estimate uint64, actual int) float64 {
+	return math.Abs(float64(estimate)-float64(actual)) / float64(actual)
+}
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	tests := []struct {
+		keys      int
+		wantDense bool
+		// about five standard errors, 0.8% each, more for linear counting's small sets
+		maxError float64
+	}{
+		{0, false, 0},
+		{1, false, 0},
+		{100, false, 0.02},
+		{1000, false, 0.04},
+		{hllSparseLimit, false, 0.04},
+		{10000, true, 0.04},
+		{100000, true, 0.04},
+		{1000000, true, 0.04},
+	}
+
+	for _, test := range tests {
+		h := sketchOfRange(0, test.keys)
+		if dense := h.Registers != nil; dense != test.wantDense {
+			t.Errorf("%d keys: dense = %v, want %v", test.keys, dense, test.wantDense)
+		}
+		estimate := h.Estimate()
+		if test.keys == 0 {
+			if estimate != 0 {
+				t.Errorf("empty sketch estimates %d", estimate)
+			}
+			continue
+		}
+		if err := relativeError(estimate, test.keys); err > test.maxError {
+			t.Errorf("%d keys: estimate %d is off by %.1f%%", test.keys, estimate, err*100)
+		}
+	}
+}
+
+func TestHyperLogLogSparseMatchesDense(t *testing.T) {
+	for _, keys := range []int{1, 50, 1000, hllSparseLimit} {
+		sparse := sketchOfRange(0, keys)
+		dense := sketchOfRange(0, keys)
+		dense.densify()
+		if sparse.Registers != nil {
+			t.Fatalf("%d keys: sketch is already dense", keys)
+		}
+		if sparse.Estimate() != dense.Estimate() {
+			t.Errorf("%d keys: sparse estimate %d, dense estimate %d", keys, sparse.Estimate(), dense.Estimate())
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges [][2]int
+		want   int
+	}{
+		{"sparse disjoint", [][2]int{{0, 500}, {500, 1000}}, 1000},
+		{"sparse overlapping", [][2]int{{0, 800}, {200, 1000}}, 1000},
+		{"sparse into dense", [][2]int{{0, 50000}, {49000, 50500}}, 50500},
+		{"dense into sparse", [][2]int{{0, 100}, {0, 50000}}, 50000},
+		{"dense identical", [][2]int{{0, 50000}, {0, 50000}, {0, 50000}}, 50000},
+		{"sparse growing dense", [][2]int{{0, 1500}, {1500, 3000}, {3000, 4500}}, 4500},
+	}
+
+	for _, test := range tests {
+		merged := NewHyperLogLog()
+		for _, keyRange := range test.ranges {
+			if err := merged.Merge(sketchOfRange(keyRange[0], keyRange[1])); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		if err := relativeError(merged.Estimate(), test.want); err > 0.04 {
+			t.Errorf("%s: merged estimate %d, want about %d", test.name, merged.Estimate(), test.want)
+		}
+
+		// merging is the same as adding every key to one sketch
+		union := NewHyperLogLog()
+		for _, keyRange := range test.ranges {
+			union.Merge(sketchOfRange(keyRange[0], keyRange[1]))
+		}
+		direct := sketchOfRange(0, test.want)
+		if union.Registers == nil {
+			union.densify()
+		}
+		if direct.Registers == nil {
+			direct.densify()
+		}
+		if !reflect.DeepEqual(union.Registers, direct.Registers) {
+			t.Errorf("%s: merged registers differ from a sketch of all the keys", test.name)
+		}
+	}
+}
+
+func TestHyperLogLogValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sketch  *HyperLogLog
+		wantErr bool
+	}{
+		{"empty", &HyperLogLog{}, false},
+		{"sparse", &HyperLogLog{Sparse: []uint32{1<<8 | 3, 7<<8 | 1}}, false},
+		{"dense", &HyperLogLog{Registers: make([]uint8, hllRegisters)}, false},
+		{"short dense", &HyperLogLog{Registers: make([]uint8, 10)}, true},
+		{"both", &HyperLogLog{Sparse: []uint32{1<<8 | 3}, Registers: make([]uint8, hllRegisters)}, true},
+		{"unsorted", &HyperLogLog{Sparse: []uint32{7<<8 | 1, 1<<8 | 3}}, true},
+		{"duplicate index", &HyperLogLog{Sparse: []uint32{1<<8 | 1, 1<<8 | 3}}, true},
+		{"zero rank", &HyperLogLog{Sparse: []uint32{1 << 8}}, true},
+		{"rank too large", &HyperLogLog{Sparse: []uint32{1<<8 | uint32(hllMaxRank+1)}}, true},
+		{"index too large", &HyperLogLog{Sparse: []uint32{uint32(hllRegisters)<<8 | 1}}, true},
+	}
+
+	for _, test := range tests {
+		if err := test.sketch.Validate(); (err != nil) != test.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -3,13 +3,15 @@ package certificate_searcher
 import (
 	"errors"
 	"math"
+	"strings"
 	"unicode"
 )
 
-var qwertyAdjacencyMatrix map[rune]map[rune]bool
-var qwertyAdjacentRunes map[rune][]rune
 var asciiLowerAlphanumeric string
 
+// Characters that can appear in a hostname label, and so take part in keyboard typos
+var keyboardDomainChars string
+
 type coordinate struct {
 	x float64
 	y float64
@@ -19,91 +21,206 @@ func (c1 coordinate) distance(c2 coordinate) float64 {
 	return math.Sqrt(math.Pow(c1.x-c2.x, 2) + math.Pow(c1.y-c2.y, 2))
 }
 
-func init() {
-	qwertyAdjacencyMatrix = make(map[rune]map[rune]bool)
-	qwertyAdjacentRunes = make(map[rune][]rune)
-	asciiLowerAlphanumeric = "abcdefghijklmnopqrstuvwxyz1234567890"
+// One physical row of keys. Each key lists every character it produces (e.g. "6-" on AZERTY).
+type KeyRow struct {
+	Y       float64
+	XOffset float64
+	Keys    []string
+}
 
-	qwertyCoordinates := make(map[rune]coordinate)
-	qwertyCoordinates['1'] = coordinate{x: 0, y: 3}
-	qwertyCoordinates['2'] = coordinate{x: 1, y: 3}
-	qwertyCoordinates['3'] = coordinate{x: 2, y: 3}
-	qwertyCoordinates['4'] = coordinate{x: 3, y: 3}
-	qwertyCoordinates['5'] = coordinate{x: 4, y: 3}
-	qwertyCoordinates['6'] = coordinate{x: 5, y: 3}
-	qwertyCoordinates['7'] = coordinate{x: 6, y: 3}
-	qwertyCoordinates['8'] = coordinate{x: 7, y: 3}
-	qwertyCoordinates['9'] = coordinate{x: 8, y: 3}
-	qwertyCoordinates['0'] = coordinate{x: 9, y: 3}
-
-	qwertyCoordinates['q'] = coordinate{x: 0.5, y: 2}
-	qwertyCoordinates['w'] = coordinate{x: 1.5, y: 2}
-	qwertyCoordinates['e'] = coordinate{x: 2.5, y: 2}
-	qwertyCoordinates['r'] = coordinate{x: 3.5, y: 2}
-	qwertyCoordinates['t'] = coordinate{x: 4.5, y: 2}
-	qwertyCoordinates['y'] = coordinate{x: 5.5, y: 2}
-	qwertyCoordinates['u'] = coordinate{x: 6.5, y: 2}
-	qwertyCoordinates['i'] = coordinate{x: 7.5, y: 2}
-	qwertyCoordinates['o'] = coordinate{x: 8.5, y: 2}
-	qwertyCoordinates['p'] = coordinate{x: 9.5, y: 2}
-
-	qwertyCoordinates['a'] = coordinate{x: 1, y: 1}
-	qwertyCoordinates['s'] = coordinate{x: 2, y: 1}
-	qwertyCoordinates['d'] = coordinate{x: 3, y: 1}
-	qwertyCoordinates['f'] = coordinate{x: 4, y: 1}
-	qwertyCoordinates['g'] = coordinate{x: 5, y: 1}
-	qwertyCoordinates['h'] = coordinate{x: 6, y: 1}
-	qwertyCoordinates['j'] = coordinate{x: 7, y: 1}
-	qwertyCoordinates['k'] = coordinate{x: 8, y: 1}
-	qwertyCoordinates['l'] = coordinate{x: 9, y: 1}
-
-	qwertyCoordinates['z'] = coordinate{x: 1.5, y: 0}
-	qwertyCoordinates['x'] = coordinate{x: 2.5, y: 0}
-	qwertyCoordinates['c'] = coordinate{x: 3.5, y: 0}
-	qwertyCoordinates['v'] = coordinate{x: 4.5, y: 0}
-	qwertyCoordinates['b'] = coordinate{x: 5.5, y: 0}
-	qwertyCoordinates['n'] = coordinate{x: 6.5, y: 0}
-	qwertyCoordinates['m'] = coordinate{x: 7.5, y: 0}
-
-	for _, r1 := range asciiLowerAlphanumeric {
-		if len(qwertyAdjacencyMatrix[r1]) == 0 {
-			qwertyAdjacencyMatrix[r1] = make(map[rune]bool)
-		}
-		if len(qwertyAdjacentRunes[r1]) == 0 {
-			qwertyAdjacentRunes[r1] = make([]rune, 0)
+// A row where every key produces a single character
+func singleCharRow(y, xOffset float64, keys string) KeyRow {
+	return KeyRow{Y: y, XOffset: xOffset, Keys: strings.Split(keys, "")}
+}
+
+type KeyboardLayout struct {
+	Name            string
+	AdjacencyCutoff float64
+	rows            []KeyRow
+	coordinates     map[rune]coordinate
+	adjacencyMatrix map[rune]map[rune]bool
+	adjacentRunes   map[rune][]rune
+}
+
+const DefaultAdjacencyCutoff float64 = 1.5
+
+func NewKeyboardLayout(name string, rows []KeyRow, adjacencyCutoff float64) *KeyboardLayout {
+	kl := &KeyboardLayout{
+		Name:            name,
+		AdjacencyCutoff: adjacencyCutoff,
+		rows:            rows,
+		coordinates:     make(map[rune]coordinate),
+		adjacencyMatrix: make(map[rune]map[rune]bool),
+		adjacentRunes:   make(map[rune][]rune),
+	}
+
+	for _, row := range rows {
+		for idx, key := range row.Keys {
+			for _, r := range key {
+				r = unicode.ToLower(r)
+				if !strings.ContainsRune(keyboardDomainChars, r) {
+					continue
+				}
+				if _, present := kl.coordinates[r]; !present {
+					kl.coordinates[r] = coordinate{x: row.XOffset + float64(idx), y: row.Y}
+				}
+			}
 		}
+	}
 
-		for _, r2 := range asciiLowerAlphanumeric {
-			dist := qwertyCoordinates[r1].distance(qwertyCoordinates[r2])
+	for r1 := range kl.coordinates {
+		kl.adjacencyMatrix[r1] = make(map[rune]bool)
+		kl.adjacentRunes[r1] = make([]rune, 0)
+	}
 
-			if dist > 0 && dist < 1.5 {
-				qwertyAdjacencyMatrix[r1][r2] = true
-				qwertyAdjacentRunes[r1] = append(qwertyAdjacentRunes[r1], r2)
+	// iterate in a fixed order so adjacentRunes is deterministic
+	for _, r1 := range keyboardDomainChars {
+		c1, present := kl.coordinates[r1]
+		if !present {
+			continue
+		}
+		for _, r2 := range keyboardDomainChars {
+			c2, present := kl.coordinates[r2]
+			if !present {
+				continue
+			}
+
+			dist := c1.distance(c2)
+			if dist > 0 && dist < adjacencyCutoff {
+				kl.adjacencyMatrix[r1][r2] = true
+				kl.adjacentRunes[r1] = append(kl.adjacentRunes[r1], r2)
 			}
 		}
 	}
+
+	return kl
 }
 
-func QwertyAdjacent(char1 rune, char2 rune) (bool, error) {
+// Same keys, with keys closer than adjacencyCutoff key widths counted as adjacent
+func (k *KeyboardLayout) WithAdjacencyCutoff(adjacencyCutoff float64) *KeyboardLayout {
+	return NewKeyboardLayout(k.Name, k.rows, adjacencyCutoff)
+}
+
+func (k *KeyboardLayout) Contains(char rune) bool {
+	_, present := k.coordinates[unicode.ToLower(char)]
+	return present
+}
+
+func (k *KeyboardLayout) Adjacent(char1 rune, char2 rune) (bool, error) {
 	char1 = unicode.ToLower(char1)
 	char2 = unicode.ToLower(char2)
 
-	if !QwertyAlphanumeric(char1) {
-		return false, errors.New("Unrecognized QWERTY keyboard character: " + string(char1))
+	if !k.Contains(char1) {
+		return false, errors.New("Unrecognized " + k.Name + " keyboard character: " + string(char1))
 	}
-	if !QwertyAlphanumeric(char2) {
-		return false, errors.New("Unrecognized QWERTY keyboard character: " + string(char2))
+	if !k.Contains(char2) {
+		return false, errors.New("Unrecognized " + k.Name + " keyboard character: " + string(char2))
 	}
 
-	return qwertyAdjacencyMatrix[char1][char2], nil
+	return k.adjacencyMatrix[char1][char2], nil
 }
 
-func QwertyAdjacentRunes(char rune) ([]rune, error) {
+func (k *KeyboardLayout) AdjacentRunes(char rune) ([]rune, error) {
 	char = unicode.ToLower(char)
-	if !QwertyAlphanumeric(char) {
-		return nil, errors.New("Unrecognized QWERTY keyboard character: " + string(char))
+	if !k.Contains(char) {
+		return nil, errors.New("Unrecognized " + k.Name + " keyboard character: " + string(char))
 	}
-	return qwertyAdjacentRunes[char], nil
+	return k.adjacentRunes[char], nil
+}
+
+// Euclidean distance between two keys, in key widths
+func (k *KeyboardLayout) Distance(char1 rune, char2 rune) (float64, error) {
+	char1 = unicode.ToLower(char1)
+	char2 = unicode.ToLower(char2)
+
+	if !k.Contains(char1) {
+		return 0, errors.New("Unrecognized " + k.Name + " keyboard character: " + string(char1))
+	}
+	if !k.Contains(char2) {
+		return 0, errors.New("Unrecognized " + k.Name + " keyboard character: " + string(char2))
+	}
+
+	return k.coordinates[char1].distance(k.coordinates[char2]), nil
+}
+
+var (
+	QWERTY *KeyboardLayout
+	QWERTZ *KeyboardLayout
+	AZERTY *KeyboardLayout
+	DVORAK *KeyboardLayout
+	// Two-layer on-screen phone keyboard: letters, and the numeric layer with the hyphen below the digits
+	PHONE *KeyboardLayout
+)
+
+/*
+Rows of the phone's numeric layer sit this far from the letters: it is a separate screen, so no
+digit is adjacent to a letter, while keys within the layer keep their real spacing.
+*/
+const phoneNumericLayerY float64 = 10
+
+// Built-in layouts, in the order their bits appear in layout masks
+var KeyboardLayouts []*KeyboardLayout
+
+func init() {
+	asciiLowerAlphanumeric = "abcdefghijklmnopqrstuvwxyz1234567890"
+	keyboardDomainChars = asciiLowerAlphanumeric + "-"
+
+	QWERTY = NewKeyboardLayout("qwerty", []KeyRow{
+		singleCharRow(3, 0, "1234567890-"),
+		singleCharRow(2, 0.5, "qwertyuiop"),
+		singleCharRow(1, 1, "asdfghjkl"),
+		singleCharRow(0, 1.5, "zxcvbnm"),
+	}, DefaultAdjacencyCutoff)
+
+	QWERTZ = NewKeyboardLayout("qwertz", []KeyRow{
+		singleCharRow(3, 0, "1234567890ß"),
+		singleCharRow(2, 0.5, "qwertzuiop"),
+		singleCharRow(1, 1, "asdfghjkl"),
+		singleCharRow(0, 1.5, "yxcvbnm,.-"),
+	}, DefaultAdjacencyCutoff)
+
+	// French AZERTY types digits with shift; unshifted, the 6 key gives the hyphen
+	AZERTY = NewKeyboardLayout("azerty", []KeyRow{
+		{Y: 3, XOffset: 0, Keys: []string{"1", "2", "3", "4", "5", "6-", "7", "8", "9", "0"}},
+		singleCharRow(2, 0.5, "azertyuiop"),
+		singleCharRow(1, 1, "qsdfghjklm"),
+		singleCharRow(0, 1.5, "wxcvbn,;:!"),
+	}, DefaultAdjacencyCutoff)
+
+	DVORAK = NewKeyboardLayout("dvorak", []KeyRow{
+		singleCharRow(3, 0, "1234567890"),
+		singleCharRow(2, 0.5, "',.pyfgcrl"),
+		singleCharRow(1, 1, "aoeuidhtns-"),
+		singleCharRow(0, 1.5, ";qjkxbmwvz"),
+	}, DefaultAdjacencyCutoff)
+
+	PHONE = NewKeyboardLayout("phone", []KeyRow{
+		singleCharRow(2, 0, "qwertyuiop"),
+		singleCharRow(1, 0.5, "asdfghjkl"),
+		singleCharRow(0, 1.5, "zxcvbnm"),
+		singleCharRow(phoneNumericLayerY+1, 0, "1234567890"),
+		singleCharRow(phoneNumericLayerY, 0, "-/:;()$&@\""),
+	}, DefaultAdjacencyCutoff)
+
+	KeyboardLayouts = []*KeyboardLayout{QWERTY, QWERTZ, AZERTY, DVORAK, PHONE}
+}
+
+// Returns the built-in layout with the given name, or nil
+func GetKeyboardLayout(name string) *KeyboardLayout {
+	for _, layout := range KeyboardLayouts {
+		if layout.Name == strings.ToLower(name) {
+			return layout
+		}
+	}
+	return nil
+}
+
+func QwertyAdjacent(char1 rune, char2 rune) (bool, error) {
+	return QWERTY.Adjacent(char1, char2)
+}
+
+func QwertyAdjacentRunes(char rune) ([]rune, error) {
+	return QWERTY.AdjacentRunes(char)
 }
 
 func QwertyAlphanumeric(char rune) bool {
@@ -0,0 +1,175 @@
+package certificate_searcher
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKeyboardLayoutAdjacent(t *testing.T) {
+	tests := []struct {
+		layout       *KeyboardLayout
+		char1, char2 rune
+		want         bool
+	}{
+		{QWERTY, 'g', 'h', true},
+		{QWERTY, 'g', 't', true},
+		{QWERTY, 'g', 'b', true},
+		{QWERTY, 'G', 'f', true},
+		{QWERTY, 'g', 'j', false},
+		{QWERTY, 'q', '1', true},
+		{QWERTY, '0', '-', true},
+		{QWERTY, 'p', '-', true},
+		{QWERTZ, 'z', 'u', true},
+		{QWERTZ, 'y', 'x', true},
+		{QWERTZ, 'y', 't', false},
+		{QWERTZ, 'm', '-', false},
+		{AZERTY, 'a', 'z', true},
+		{AZERTY, 'q', 'w', true},
+		{AZERTY, 'l', 'm', true},
+		// the hyphen shares the 6 key, between 5 and 7 and above t and y
+		{AZERTY, '-', '5', true},
+		{AZERTY, '-', '7', true},
+		{AZERTY, '-', 'y', true},
+		{AZERTY, '-', '6', false},
+		{DVORAK, 'a', 'o', true},
+		{DVORAK, 's', '-', true},
+		{DVORAK, 'a', 's', false},
+		{PHONE, 'q', 'w', true},
+		{PHONE, 'a', 'x', false},
+		{PHONE, 'x', 's', true},
+		// digits and the hyphen are on another screen
+		{PHONE, 'q', '1', false},
+		{PHONE, '1', '2', true},
+		{PHONE, '-', '1', true},
+		{PHONE, '-', 'z', false},
+	}
+
+	for _, test := range tests {
+		got, err := test.layout.Adjacent(test.char1, test.char2)
+		if err != nil {
+			t.Errorf("%s Adjacent(%q, %q): %s", test.layout.Name, test.char1, test.char2, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s Adjacent(%q, %q) = %t, want %t", test.layout.Name, test.char1, test.char2, got, test.want)
+		}
+		if reverse, _ := test.layout.Adjacent(test.char2, test.char1); reverse != got {
+			t.Errorf("%s Adjacent(%q, %q) = %t, but %t the other way", test.layout.Name, test.char2, test.char1, reverse, got)
+		}
+	}
+}
+
+func TestKeyboardLayoutContains(t *testing.T) {
+	// every layout types every hostname character, and nothing else
+	for _, layout := range KeyboardLayouts {
+		for _, char := range keyboardDomainChars {
+			if !layout.Contains(char) {
+				t.Errorf("%s does not contain %q", layout.Name, char)
+			}
+		}
+		for _, char := range []rune{'.', ',', ';', 'ß', '/', ' '} {
+			if layout.Contains(char) {
+				t.Errorf("%s contains %q", layout.Name, char)
+			}
+		}
+	}
+
+	if _, err := QWERTY.Adjacent('a', '.'); err == nil {
+		t.Error("Adjacent('a', '.') succeeded, want an error")
+	}
+	if _, err := QWERTY.AdjacentRunes('é'); err == nil {
+		t.Error("AdjacentRunes('é') succeeded, want an error")
+	}
+	if _, err := QWERTY.Distance('.', 'a'); err == nil {
+		t.Error("Distance('.', 'a') succeeded, want an error")
+	}
+}
+
+func TestKeyboardLayoutAdjacentRunes(t *testing.T) {
+	tests := []struct {
+		layout *KeyboardLayout
+		char   rune
+		want   []rune
+	}{
+		{QWERTY, 'a', []rune{'q', 'w', 's', 'z'}},
+		{QWERTY, 'g', []rune{'b', 'f', 'h', 't', 'v', 'y'}},
+		{QWERTZ, 'a', []rune{'q', 'w', 's', 'y'}},
+		{AZERTY, 'a', []rune{'z', '1', '2', 'q'}},
+		{PHONE, '-', []rune{'1', '2'}},
+	}
+
+	for _, test := range tests {
+		got, err := test.layout.AdjacentRunes(test.char)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sortedRunes(got), sortedRunes(test.want)) {
+			t.Errorf("%s AdjacentRunes(%q) = %q, want %q", test.layout.Name, test.char, got, test.want)
+		}
+	}
+
+	// generated typos depend on this order, so it must not vary between runs
+	first, _ := QWERTY.AdjacentRunes('g')
+	for run := 0; run < 10; run++ {
+		if again := NewKeyboardLayout("qwerty", QWERTY.rows, DefaultAdjacencyCutoff); !reflect.DeepEqual(again.adjacentRunes['g'], first) {
+			t.Fatalf("AdjacentRunes('g') = %q, then %q", first, again.adjacentRunes['g'])
+		}
+	}
+}
+
+func sortedRunes(runes []rune) string {
+	sorted := append([]rune{}, runes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return string(sorted)
+}
+
+func TestKeyboardLayoutDistance(t *testing.T) {
+	tests := []struct {
+		layout       *KeyboardLayout
+		char1, char2 rune
+		want         float64
+	}{
+		{QWERTY, 'g', 'g', 0},
+		{QWERTY, 'g', 'h', 1},
+		{QWERTY, 'g', 't', math.Sqrt(1.25)},
+		{QWERTY, 'a', 'l', 8},
+		{AZERTY, '6', '-', 0},
+	}
+
+	for _, test := range tests {
+		got, err := test.layout.Distance(test.char1, test.char2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s Distance(%q, %q) = %f, want %f", test.layout.Name, test.char1, test.char2, got, test.want)
+		}
+	}
+}
+
+func TestKeyboardLayoutWithAdjacencyCutoff(t *testing.T) {
+	wide := QWERTY.WithAdjacencyCutoff(2.5)
+	if adjacent, _ := wide.Adjacent('g', 'j'); !adjacent {
+		t.Error("g and j are not adjacent with a 2.5 cutoff")
+	}
+	// the original keeps its cutoff
+	if adjacent, _ := QWERTY.Adjacent('g', 'j'); adjacent {
+		t.Error("g and j are adjacent with the default cutoff")
+	}
+}
+
+func TestGetKeyboardLayout(t *testing.T) {
+	for _, layout := range KeyboardLayouts {
+		if got := GetKeyboardLayout(layout.Name); got != layout {
+			t.Errorf("GetKeyboardLayout(%q) = %v", layout.Name, got)
+		}
+	}
+	if got := GetKeyboardLayout("AZERTY"); got != AZERTY {
+		t.Errorf("GetKeyboardLayout(AZERTY) = %v", got)
+	}
+	if got := GetKeyboardLayout("colemak"); got != nil {
+		t.Errorf("GetKeyboardLayout(colemak) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package certificate_searcher
+
+import "io/ioutil"
+
+// No mmap here, so the file is read into memory instead
+func mapFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
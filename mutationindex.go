@@ -0,0 +1,362 @@
+package certificate_searcher
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// One (label, base domain) a mutation was generated from
+type MutationPosting struct {
+	BaseDomain uint32
+	// Keyboard layouts (bit i = i-th layout of the generating labeler) explaining the typo, if any
+	LayoutMask uint32
+	Label      uint8
+}
+
+func (p MutationPosting) DomainLabel() DomainLabel {
+	return DomainLabel(p.Label)
+}
+
+/*
+Every mutation generated by the mutation-based labelers, in one immutable structure shared by
+all of them. Mutations are stored sorted and back to back in a single byte arena, and each maps
+to a run of postings naming the label and the base domain (by id) it came from. Lookups are a
+binary search over the arena, so the index is safe to share between goroutines.
+*/
+type MutationIndex struct {
+	BaseDomains []string
+	// mutation i is arena[offsets[i]:offsets[i+1]]
+	arena   []byte
+	offsets []uint64
+	// postings of mutation i are postings[postingOffsets[i]:postingOffsets[i+1]]
+	postingOffsets []uint32
+	postings       []MutationPosting
+	// backing memory when opened from an index file
+	mapping []byte
+	// Most heap bytes the MutationIndexBuilder held while building the index, if it was built here
+	PeakBuildBytes uint64
+}
+
+func (m *MutationIndex) Len() int {
+	if len(m.offsets) == 0 {
+		return 0
+	}
+	return len(m.offsets) - 1
+}
+
+func (m *MutationIndex) PostingCount() int {
+	return len(m.postings)
+}
+
+func (m *MutationIndex) mutation(i int) []byte {
+	return m.arena[m.offsets[i]:m.offsets[i+1]]
+}
+
+// Postings for mutation, or nil. The returned slice is shared and must not be modified.
+func (m *MutationIndex) Lookup(mutation string) []MutationPosting {
+	n := m.Len()
+	i := sort.Search(n, func(i int) bool { return string(m.mutation(i)) >= mutation })
+	if i == n || string(m.mutation(i)) != mutation {
+		return nil
+	}
+	return m.postings[m.postingOffsets[i]:m.postingOffsets[i+1]]
+}
+
+// Base domains mutation was generated from under each of the given labels
+func (m *MutationIndex) LabelMutation(mutation string, labels map[DomainLabel]struct{}) map[DomainLabel][]string {
+	domainLabels := make(map[DomainLabel][]string)
+	for _, posting := range m.Lookup(mutation) {
+		if _, present := labels[posting.DomainLabel()]; present {
+			domainLabels[posting.DomainLabel()] = append(domainLabels[posting.DomainLabel()], m.BaseDomains[posting.BaseDomain])
+		}
+	}
+	return domainLabels
+}
+
+// Expands the entries for the given labels into a MutatedDomains map, for tools that list mutations
+func (m *MutationIndex) Mutations(labels map[DomainLabel]struct{}) MutatedDomains {
+	mutatedDomains := make(MutatedDomains)
+	for i := 0; i < m.Len(); i++ {
+		for _, posting := range m.postings[m.postingOffsets[i]:m.postingOffsets[i+1]] {
+			if _, present := labels[posting.DomainLabel()]; present {
+				AddMutation(mutatedDomains, Mutation(m.mutation(i)), m.BaseDomains[posting.BaseDomain])
+			}
+		}
+	}
+	return mutatedDomains
+}
+
+// Approximate heap bytes held by the index, excluding base domain strings
+func (m *MutationIndex) SizeBytes() uint64 {
+	return uint64(len(m.arena)) +
+		uint64(len(m.offsets))*uint64(unsafe.Sizeof(uint64(0))) +
+		uint64(len(m.postingOffsets))*uint64(unsafe.Sizeof(uint32(0))) +
+		uint64(len(m.postings))*uint64(unsafe.Sizeof(MutationPosting{})) +
+		uint64(len(m.BaseDomains))*uint64(unsafe.Sizeof(""))
+}
+
+type mutationEntry struct {
+	mutation string
+	posting  MutationPosting
+}
+
+// Entries buffered by Add before they are sorted, deduplicated and packed into a chunk
+const mutationChunkEntries = 1 << 18
+
+/*
+Collects mutations from several labelers, then packs them into one MutationIndex. Labelers keep
+the pointer returned by Index, which Build fills in place, so they can be constructed before the
+index is complete.
+
+Added mutations are packed every mutationChunkEntries into sorted chunks laid out like the final
+index, so duplicates are dropped early and the strings do not each keep their own allocation;
+Build merges the chunks.
+*/
+type MutationIndexBuilder struct {
+	baseDomains []string
+	baseIDs     map[string]uint32
+	entries     []mutationEntry
+	chunks      []*MutationIndex
+	// bytes held by the chunks, and the most the builder held at once
+	chunkBytes uint64
+	peakBytes  uint64
+	index      *MutationIndex
+	built      bool
+	mux        sync.Mutex
+}
+
+func NewMutationIndexBuilder() *MutationIndexBuilder {
+	return &MutationIndexBuilder{
+		baseDomains: make([]string, 0),
+		baseIDs:     make(map[string]uint32),
+		entries:     make([]mutationEntry, 0, mutationChunkEntries),
+		chunks:      make([]*MutationIndex, 0),
+		index:       &MutationIndex{},
+	}
+}
+
+func (b *MutationIndexBuilder) Add(label DomainLabel, mutation string, baseDomain string, layoutMask uint32) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.built {
+		panic("mutation added after the index was built")
+	}
+
+	id, present := b.baseIDs[baseDomain]
+	if !present {
+		id = uint32(len(b.baseDomains))
+		b.baseIDs[baseDomain] = id
+		b.baseDomains = append(b.baseDomains, baseDomain)
+	}
+
+	b.entries = append(b.entries, mutationEntry{
+		mutation: mutation,
+		posting:  MutationPosting{BaseDomain: id, LayoutMask: layoutMask, Label: uint8(label)},
+	})
+	if len(b.entries) == mutationChunkEntries {
+		b.flush()
+	}
+}
+
+// Packs the buffered entries into a new chunk
+func (b *MutationIndexBuilder) flush() {
+	if len(b.entries) == 0 {
+		return
+	}
+
+	entryBytes := uint64(cap(b.entries)) * uint64(unsafe.Sizeof(mutationEntry{}))
+	for _, entry := range b.entries {
+		entryBytes += uint64(len(entry.mutation))
+	}
+
+	chunk := packMutationEntries(b.entries)
+	b.chunks = append(b.chunks, chunk)
+	b.chunkBytes += chunk.SizeBytes()
+	if b.chunkBytes+entryBytes > b.peakBytes {
+		b.peakBytes = b.chunkBytes + entryBytes
+	}
+
+	b.entries = b.entries[:0]
+}
+
+func (b *MutationIndexBuilder) Index() *MutationIndex {
+	return b.index
+}
+
+// Packs the collected mutations into the index, merging layout masks of duplicate entries
+func (b *MutationIndexBuilder) Build() *MutationIndex {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.built {
+		return b.index
+	}
+	b.built = true
+
+	b.flush()
+	b.entries = nil
+	chunks := b.chunks
+	b.chunks = nil
+
+	index := b.index
+	switch len(chunks) {
+	case 0:
+	case 1:
+		*index = *chunks[0]
+	default:
+		mergeMutationChunks(chunks, index)
+		if b.chunkBytes+index.SizeBytes() > b.peakBytes {
+			b.peakBytes = b.chunkBytes + index.SizeBytes()
+		}
+	}
+	index.BaseDomains = b.baseDomains
+	index.PeakBuildBytes = b.peakBytes
+
+	return index
+}
+
+func comparePostings(a, b MutationPosting) int {
+	if a.Label != b.Label {
+		return int(a.Label) - int(b.Label)
+	}
+	if a.BaseDomain < b.BaseDomain {
+		return -1
+	} else if a.BaseDomain > b.BaseDomain {
+		return 1
+	}
+	return 0
+}
+
+// Sorts entries and packs them into an index without base domains, merging layout masks of duplicates
+func packMutationEntries(entries []mutationEntry) *MutationIndex {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].mutation != entries[j].mutation {
+			return entries[i].mutation < entries[j].mutation
+		}
+		return comparePostings(entries[i].posting, entries[j].posting) < 0
+	})
+
+	// size the arrays exactly up front, they are the bulk of the labelers' memory
+	mutationCount, arenaSize, postingCount := 0, 0, 0
+	for i, entry := range entries {
+		if i == 0 || entry.mutation != entries[i-1].mutation {
+			mutationCount++
+			arenaSize += len(entry.mutation)
+			postingCount++
+		} else if comparePostings(entry.posting, entries[i-1].posting) != 0 {
+			postingCount++
+		}
+	}
+
+	index := &MutationIndex{
+		arena:          make([]byte, 0, arenaSize),
+		offsets:        append(make([]uint64, 0, mutationCount+1), 0),
+		postingOffsets: append(make([]uint32, 0, mutationCount+1), 0),
+		postings:       make([]MutationPosting, 0, postingCount),
+	}
+	for i, entry := range entries {
+		newMutation := i == 0 || entry.mutation != entries[i-1].mutation
+		if newMutation {
+			if i > 0 {
+				index.offsets = append(index.offsets, uint64(len(index.arena)))
+				index.postingOffsets = append(index.postingOffsets, uint32(len(index.postings)))
+			}
+			index.arena = append(index.arena, entry.mutation...)
+		}
+
+		last := len(index.postings) - 1
+		if !newMutation && comparePostings(index.postings[last], entry.posting) == 0 {
+			index.postings[last].LayoutMask |= entry.posting.LayoutMask
+			continue
+		}
+		index.postings = append(index.postings, entry.posting)
+	}
+	index.offsets = append(index.offsets, uint64(len(index.arena)))
+	index.postingOffsets = append(index.postingOffsets, uint32(len(index.postings)))
+
+	return index
+}
+
+// Position of a k-way merge in one chunk
+type chunkCursor struct {
+	chunk *MutationIndex
+	next  int
+}
+
+type chunkHeap []*chunkCursor
+
+func (h chunkHeap) Len() int { return len(h) }
+func (h chunkHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].chunk.mutation(h[i].next), h[j].chunk.mutation(h[j].next)) < 0
+}
+func (h chunkHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *chunkHeap) Push(x interface{}) { *h = append(*h, x.(*chunkCursor)) }
+func (h *chunkHeap) Pop() interface{} {
+	old := *h
+	cursor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cursor
+}
+
+// Calls visit with each distinct mutation of the chunks, in order, and its merged postings
+func visitMergedChunks(chunks []*MutationIndex, visit func(mutation []byte, postings []MutationPosting)) {
+	cursors := make(chunkHeap, 0, len(chunks))
+	for _, chunk := range chunks {
+		if chunk.Len() > 0 {
+			cursors = append(cursors, &chunkCursor{chunk: chunk})
+		}
+	}
+	heap.Init(&cursors)
+
+	postings := make([]MutationPosting, 0)
+	for len(cursors) > 0 {
+		mutation := cursors[0].chunk.mutation(cursors[0].next)
+		postings = postings[:0]
+		for len(cursors) > 0 && bytes.Equal(cursors[0].chunk.mutation(cursors[0].next), mutation) {
+			cursor := cursors[0]
+			postings = append(postings, cursor.chunk.postings[cursor.chunk.postingOffsets[cursor.next]:cursor.chunk.postingOffsets[cursor.next+1]]...)
+			cursor.next++
+			if cursor.next == cursor.chunk.Len() {
+				heap.Pop(&cursors)
+			} else {
+				heap.Fix(&cursors, 0)
+			}
+		}
+
+		sort.Slice(postings, func(i, j int) bool { return comparePostings(postings[i], postings[j]) < 0 })
+		merged := postings[:1]
+		for _, posting := range postings[1:] {
+			if comparePostings(merged[len(merged)-1], posting) == 0 {
+				merged[len(merged)-1].LayoutMask |= posting.LayoutMask
+			} else {
+				merged = append(merged, posting)
+			}
+		}
+		visit(mutation, merged)
+	}
+}
+
+// Merges sorted chunks into index, sizing its arrays with a counting pass first
+func mergeMutationChunks(chunks []*MutationIndex, index *MutationIndex) {
+	mutationCount, arenaSize, postingCount := 0, 0, 0
+	visitMergedChunks(chunks, func(mutation []byte, postings []MutationPosting) {
+		mutationCount++
+		arenaSize += len(mutation)
+		postingCount += len(postings)
+	})
+
+	index.arena = make([]byte, 0, arenaSize)
+	index.offsets = append(make([]uint64, 0, mutationCount+1), 0)
+	index.postingOffsets = append(make([]uint32, 0, mutationCount+1), 0)
+	index.postings = make([]MutationPosting, 0, postingCount)
+	visitMergedChunks(chunks, func(mutation []byte, postings []MutationPosting) {
+		index.arena = append(index.arena, mutation...)
+		index.postings = append(index.postings, postings...)
+		index.offsets = append(index.offsets, uint64(len(index.arena)))
+		index.postingOffsets = append(index.postingOffsets, uint32(len(index.postings)))
+	})
+}
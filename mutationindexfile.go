@@ -0,0 +1,364 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+/*
+Index file layout, all integers little-endian:
+
+	magic (8 bytes) | format version (uint32) | header length (uint32) | JSON header | padding
+	sections, each starting on an 8-byte boundary relative to the end of the header padding
+
+Sections hold the MutationIndex arrays exactly as they sit in memory, so a mapped file is used
+in place without decoding.
+*/
+const (
+	mutationIndexMagic = "CSMUTIDX"
+	// Bump whenever the file layout changes; generator changes are caught by GeneratorSHA256
+	MutationIndexFormatVersion uint32 = 2
+)
+
+var ErrStaleMutationIndex = errors.New("mutation index file was built from different inputs")
+
+// The mutation labelers and parameters that produced an index
+type MutationParams struct {
+	TypoSquatting TypoSquattingConfig `json:"typosquatting"`
+	HomoGraph     HomoGraphConfig     `json:"homograph"`
+	BitSquatting  LabelerToggle       `json:"bitsquatting"`
+}
+
+// Whether an index built with p has every mutation a run configured with required needs
+func (p MutationParams) Covers(required MutationParams) bool {
+	return (!required.TypoSquatting.Enabled || reflect.DeepEqual(p.TypoSquatting, required.TypoSquatting)) &&
+		(!required.HomoGraph.Enabled || p.HomoGraph == required.HomoGraph) &&
+		(!required.BitSquatting.Enabled || p.BitSquatting == required.BitSquatting)
+}
+
+type MutationIndexInputs struct {
+	BaseDomainsSHA256 string         `json:"base_domains_sha256"`
+	PSLSHA256         string         `json:"psl_sha256"`
+	Params            MutationParams `json:"params"`
+	// MutationGeneratorSHA256 of Params when the index was written, filled in by WriteMutationIndexFile
+	GeneratorSHA256 string `json:"generator_sha256"`
+}
+
+// Base domains the generators are run on to fingerprint them, covering multi-label and private suffixes
+var generatorProbeDomains = []string{"google.com", "pay-pal.co.uk", "example.github.io"}
+
+/*
+Hashes params together with the mutations the generators produce under them for a few probe
+domains, so an index built by a generator that has since changed no longer matches even if
+nobody remembered to bump MutationIndexFormatVersion.
+*/
+func MutationGeneratorSHA256(params MutationParams) (string, error) {
+	config := DefaultConfig()
+	config.Labelers.TypoSquatting = params.TypoSquatting
+	config.Labelers.HomoGraph = params.HomoGraph
+	config.Labelers.BitSquatting = params.BitSquatting
+	config.Labelers.WrongTLD.Enabled = false
+
+	probeDomains := append([]string{}, generatorProbeDomains...)
+	_, index, err := config.BuildMutationLabelers(&probeDomains, nil)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if err := json.NewEncoder(hash).Encode(params); err != nil {
+		return "", err
+	}
+	for i := 0; i < index.Len(); i++ {
+		hash.Write(index.mutation(i))
+		for _, posting := range index.postings[index.postingOffsets[i]:index.postingOffsets[i+1]] {
+			fmt.Fprintf(hash, "\t%s,%s,%d", posting.DomainLabel(), index.BaseDomains[posting.BaseDomain], posting.LayoutMask)
+		}
+		hash.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func HashBaseDomains(baseDomains []string) string {
+	hash := sha256.Sum256([]byte(strings.Join(baseDomains, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+type indexSection struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+type MutationIndexFileHeader struct {
+	FormatVersion uint32              `json:"format_version"`
+	Created       time.Time           `json:"created"`
+	Inputs        MutationIndexInputs `json:"inputs"`
+	// Name of each DomainLabel value stored in the postings, indexed by value
+	Labels    []string                `json:"labels"`
+	Mutations int                     `json:"mutations"`
+	Postings  int                     `json:"postings"`
+	Sections  map[string]indexSection `json:"sections"`
+}
+
+func padTo8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
+
+// The raw memory of a slice of fixed-size values, and the reverse, used to map sections in place
+func uint64Bytes(s []uint64) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*8)
+}
+
+func uint32Bytes(s []uint32) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*4)
+}
+
+func postingBytes(s []MutationPosting) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*int(unsafe.Sizeof(MutationPosting{})))
+}
+
+func bytesAsUint64s(b []byte) []uint64 {
+	if len(b) < 8 {
+		return nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), len(b)/8)
+}
+
+func bytesAsUint32s(b []byte) []uint32 {
+	if len(b) < 4 {
+		return nil
+	}
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), len(b)/4)
+}
+
+func bytesAsPostings(b []byte) []MutationPosting {
+	size := int(unsafe.Sizeof(MutationPosting{}))
+	if len(b) < size {
+		return nil
+	}
+	return unsafe.Slice((*MutationPosting)(unsafe.Pointer(&b[0])), len(b)/size)
+}
+
+func littleEndianHost() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
+
+func WriteMutationIndexFile(path string, index *MutationIndex, inputs MutationIndexInputs) error {
+	if !littleEndianHost() {
+		return errors.New("mutation index files can only be written on little-endian hosts")
+	}
+
+	generatorSHA256, err := MutationGeneratorSHA256(inputs.Params)
+	if err != nil {
+		return err
+	}
+	inputs.GeneratorSHA256 = generatorSHA256
+
+	sections := []struct {
+		name string
+		data []byte
+	}{
+		{"base_domains", []byte(strings.Join(index.BaseDomains, "\n"))},
+		{"arena", index.arena},
+		{"offsets", uint64Bytes(index.offsets)},
+		{"posting_offsets", uint32Bytes(index.postingOffsets)},
+		{"postings", postingBytes(index.postings)},
+	}
+
+	header := MutationIndexFileHeader{
+		FormatVersion: MutationIndexFormatVersion,
+		Created:       time.Now().UTC(),
+		Inputs:        inputs,
+		Labels:        domainLabelNames[:],
+		Mutations:     index.Len(),
+		Postings:      index.PostingCount(),
+		Sections:      make(map[string]indexSection),
+	}
+	offset := uint64(0)
+	for _, section := range sections {
+		header.Sections[section.name] = indexSection{Offset: offset, Length: uint64(len(section.data))}
+		offset = padTo8(offset + uint64(len(section.data)))
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	w.WriteString(mutationIndexMagic)
+	binary.Write(w, binary.LittleEndian, MutationIndexFormatVersion)
+	binary.Write(w, binary.LittleEndian, uint32(len(headerJSON)))
+	w.Write(headerJSON)
+	prefixLen := uint64(len(mutationIndexMagic) + 8 + len(headerJSON))
+	w.Write(make([]byte, padTo8(prefixLen)-prefixLen))
+
+	written := uint64(0)
+	for _, section := range sections {
+		w.Write(make([]byte, header.Sections[section.name].Offset-written))
+		w.Write(section.data)
+		written = header.Sections[section.name].Offset + uint64(len(section.data))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Reads the header of an index file, with the offset at which its sections start
+func readMutationIndexHeader(data []byte) (*MutationIndexFileHeader, uint64, error) {
+	prefixLen := len(mutationIndexMagic) + 8
+	if len(data) < prefixLen || string(data[:len(mutationIndexMagic)]) != mutationIndexMagic {
+		return nil, 0, errors.New("not a mutation index file")
+	}
+
+	version := binary.LittleEndian.Uint32(data[len(mutationIndexMagic):])
+	if version != MutationIndexFormatVersion {
+		return nil, 0, fmt.Errorf("mutation index format version %d, expected %d", version, MutationIndexFormatVersion)
+	}
+
+	headerLen := uint64(binary.LittleEndian.Uint32(data[len(mutationIndexMagic)+4:]))
+	if uint64(len(data)) < uint64(prefixLen)+headerLen {
+		return nil, 0, errors.New("truncated mutation index header")
+	}
+
+	header := &MutationIndexFileHeader{}
+	if err := json.Unmarshal(data[prefixLen:uint64(prefixLen)+headerLen], header); err != nil {
+		return nil, 0, err
+	}
+
+	return header, padTo8(uint64(prefixLen) + headerLen), nil
+}
+
+func (h *MutationIndexFileHeader) section(data []byte, dataStart uint64, name string) ([]byte, error) {
+	section, present := h.Sections[name]
+	if !present {
+		return nil, fmt.Errorf("mutation index file has no %s section", name)
+	}
+	start := dataStart + section.Offset
+	if start+section.Length > uint64(len(data)) {
+		return nil, fmt.Errorf("mutation index section %s is truncated", name)
+	}
+	return data[start : start+section.Length], nil
+}
+
+/*
+Maps an index file into memory and returns the index in it, or ErrStaleMutationIndex if it was
+built from other base domains, another PSL, parameters that do not cover inputs.Params, a
+generator that has changed since, or with labels numbered differently. The index stays valid
+until Close.
+*/
+func OpenMutationIndexFile(path string, inputs MutationIndexInputs) (*MutationIndex, *MutationIndexFileHeader, error) {
+	if !littleEndianHost() {
+		return nil, nil, errors.New("mutation index files can only be used on little-endian hosts")
+	}
+	if unsafe.Sizeof(MutationPosting{}) != 12 {
+		return nil, nil, errors.New("unexpected in-memory layout of MutationPosting")
+	}
+
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index, header, err := mutationIndexFromBytes(data, inputs)
+	if err != nil {
+		unmapFile(data)
+		return nil, header, err
+	}
+	index.mapping = data
+
+	return index, header, nil
+}
+
+func mutationIndexFromBytes(data []byte, inputs MutationIndexInputs) (*MutationIndex, *MutationIndexFileHeader, error) {
+	header, dataStart, err := readMutationIndexHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if header.Inputs.BaseDomainsSHA256 != inputs.BaseDomainsSHA256 ||
+		header.Inputs.PSLSHA256 != inputs.PSLSHA256 ||
+		!header.Inputs.Params.Covers(inputs.Params) {
+		return nil, header, ErrStaleMutationIndex
+	}
+	if len(header.Labels) > len(domainLabelNames) {
+		return nil, header, fmt.Errorf("%w: it has %d labels, expected at most %d", ErrStaleMutationIndex, len(header.Labels), len(domainLabelNames))
+	}
+	for value, name := range header.Labels {
+		if name != domainLabelNames[value] {
+			return nil, header, fmt.Errorf("%w: label %d is %s in the index but %s here", ErrStaleMutationIndex, value, name, domainLabelNames[value])
+		}
+	}
+	generatorSHA256, err := MutationGeneratorSHA256(header.Inputs.Params)
+	if err != nil {
+		return nil, header, err
+	}
+	if header.Inputs.GeneratorSHA256 != generatorSHA256 {
+		return nil, header, fmt.Errorf("%w: the mutation generators have changed since it was built", ErrStaleMutationIndex)
+	}
+
+	sectionData := make(map[string][]byte)
+	for _, name := range []string{"base_domains", "arena", "offsets", "posting_offsets", "postings"} {
+		if sectionData[name], err = header.section(data, dataStart, name); err != nil {
+			return nil, header, err
+		}
+	}
+
+	index := &MutationIndex{
+		arena:          sectionData["arena"],
+		offsets:        bytesAsUint64s(sectionData["offsets"]),
+		postingOffsets: bytesAsUint32s(sectionData["posting_offsets"]),
+		postings:       bytesAsPostings(sectionData["postings"]),
+	}
+	if len(sectionData["base_domains"]) > 0 {
+		for _, domain := range bytes.Split(sectionData["base_domains"], []byte("\n")) {
+			index.BaseDomains = append(index.BaseDomains, string(domain))
+		}
+	}
+
+	if index.Len() != header.Mutations || index.PostingCount() != header.Postings {
+		return nil, header, errors.New("mutation index sections do not match the header counts")
+	}
+
+	return index, header, nil
+}
+
+// Releases the mapping of an index opened from a file; the index must not be used afterwards
+func (m *MutationIndex) Close() error {
+	if m.mapping == nil {
+		return nil
+	}
+	err := unmapFile(m.mapping)
+	m.mapping = nil
+	m.arena, m.offsets, m.postingOffsets, m.postings = nil, nil, nil, nil
+	return err
+}
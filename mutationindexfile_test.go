@@ -0,0 +1,188 @@
+package certificate_searcher
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMutationParamsCovers(t *testing.T) {
+	qwerty := TypoSquattingConfig{Enabled: true, KeyboardLayouts: []string{"qwerty"}, AdjacencyCutoff: DefaultAdjacencyCutoff}
+	qwertz := TypoSquattingConfig{Enabled: true, KeyboardLayouts: []string{"qwertz"}, AdjacencyCutoff: DefaultAdjacencyCutoff}
+	homograph := HomoGraphConfig{Enabled: true, MaxHomoglyphSubs: 2}
+	enabled := LabelerToggle{Enabled: true}
+
+	tests := []struct {
+		name     string
+		built    MutationParams
+		required MutationParams
+		want     bool
+	}{
+		{"same", MutationParams{qwerty, homograph, enabled}, MutationParams{qwerty, homograph, enabled}, true},
+		{"nothing required", MutationParams{}, MutationParams{}, true},
+		{"subset required", MutationParams{qwerty, homograph, enabled}, MutationParams{TypoSquatting: qwerty}, true},
+		{"disabled labelers' parameters ignored", MutationParams{TypoSquatting: qwerty},
+			MutationParams{TypoSquatting: qwerty, HomoGraph: HomoGraphConfig{MaxHomoglyphSubs: 5}}, true},
+		{"labeler missing", MutationParams{TypoSquatting: qwerty}, MutationParams{TypoSquatting: qwerty, BitSquatting: enabled}, false},
+		{"other keyboard layout", MutationParams{TypoSquatting: qwerty}, MutationParams{TypoSquatting: qwertz}, false},
+		{"other adjacency cutoff", MutationParams{TypoSquatting: qwerty},
+			MutationParams{TypoSquatting: TypoSquattingConfig{Enabled: true, KeyboardLayouts: []string{"qwerty"}, AdjacencyCutoff: 0.5}}, false},
+		{"fewer homoglyph substitutions", MutationParams{HomoGraph: HomoGraphConfig{Enabled: true, MaxHomoglyphSubs: 1}},
+			MutationParams{HomoGraph: homograph}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.built.Covers(test.required); got != test.want {
+			t.Errorf("%s: Covers = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Config for the typosquatting and bitsquatting mutations, which are quick to generate
+func testMutationConfig() *Config {
+	config := DefaultConfig()
+	config.Labelers.HomoGraph.Enabled = false
+	return config
+}
+
+func TestMutationIndexFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutationindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testMutationConfig()
+	baseDomains := []string{"google.com", "paypal.co.uk"}
+	_, built, err := config.BuildMutationLabelers(&baseDomains, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "mutations.idx")
+	inputs := config.MutationIndexInputs(baseDomains)
+	if err := WriteMutationIndexFile(path, built, inputs); err != nil {
+		t.Fatal(err)
+	}
+
+	opened, header, err := OpenMutationIndexFile(path, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+
+	if header.Mutations != built.Len() || opened.Len() != built.Len() || opened.PostingCount() != built.PostingCount() {
+		t.Errorf("opened %d mutations and %d postings, header says %d, built %d and %d",
+			opened.Len(), opened.PostingCount(), header.Mutations, built.Len(), built.PostingCount())
+	}
+	if !reflect.DeepEqual(opened.BaseDomains, built.BaseDomains) {
+		t.Errorf("base domains %v, want %v", opened.BaseDomains, built.BaseDomains)
+	}
+	for i := 0; i < built.Len(); i++ {
+		mutation := string(built.mutation(i))
+		if got, want := opened.Lookup(mutation), built.Lookup(mutation); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Lookup(%q) = %v, want %v", mutation, got, want)
+		}
+	}
+	if opened.Lookup("not-a-mutation.example") != nil {
+		t.Error("Lookup of an unknown name found postings")
+	}
+
+	// labelers over the mapped index label names like freshly generated ones
+	mappedLabelers, _, err := config.BuildMutationLabelers(&baseDomains, opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generatedLabelers, _, _ := config.BuildMutationLabelers(&baseDomains, nil)
+	for _, name := range []string{"gogle.com", "googel.com", "goog1e.com", "paypa1.co.uk", "pqypal.co.uk", "google.com"} {
+		for idx := range mappedLabelers {
+			if got, want := mappedLabelers[idx].LabelDomain(name), generatedLabelers[idx].LabelDomain(name); !reflect.DeepEqual(got, want) {
+				t.Errorf("labeler %d: LabelDomain(%q) = %v from the file, %v generated", idx, name, got, want)
+			}
+		}
+	}
+}
+
+func TestOpenMutationIndexFileStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutationindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testMutationConfig()
+	baseDomains := []string{"google.com"}
+	_, built, err := config.BuildMutationLabelers(&baseDomains, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := config.MutationIndexInputs(baseDomains)
+	path := filepath.Join(dir, "mutations.idx")
+	if err := WriteMutationIndexFile(path, built, inputs); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes data with old replaced by new of the same length, so the layout stays intact
+	tampered := func(name string, old, new string) string {
+		tamperedPath := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(tamperedPath, bytes.Replace(data, []byte(old), []byte(new), 1), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return tamperedPath
+	}
+	generatorField := []byte(`"generator_sha256":"`)
+	generatorStart := bytes.Index(data, generatorField) + len(generatorField)
+	generatorSHA256 := string(data[generatorStart : generatorStart+64])
+	otherGenerator := "0" + generatorSHA256[1:]
+	if generatorSHA256[0] == '0' {
+		otherGenerator = "1" + generatorSHA256[1:]
+	}
+	otherLayout := testMutationConfig()
+	otherLayout.Labelers.TypoSquatting.KeyboardLayouts = []string{"azerty"}
+	withHomograph := DefaultConfig()
+
+	tests := []struct {
+		name      string
+		path      string
+		inputs    MutationIndexInputs
+		wantStale bool
+	}{
+		{"other base domains", path, config.MutationIndexInputs([]string{"paypal.com"}), true},
+		{"other PSL", path, MutationIndexInputs{BaseDomainsSHA256: inputs.BaseDomainsSHA256, PSLSHA256: "0", Params: inputs.Params}, true},
+		{"other keyboard layout", path, otherLayout.MutationIndexInputs(baseDomains), true},
+		{"homograph mutations missing", path, withHomograph.MutationIndexInputs(baseDomains), true},
+		{"labels renumbered", tampered("labels.idx", `"TYPOSQUATTING_MISSING_DOT","TYPOSQUATTING_CHAR_OMISSION"`,
+			`"TYPOSQUATTING_CHAR_OMISSION","TYPOSQUATTING_MISSING_DOT"`), inputs, true},
+		{"generator changed", tampered("generator.idx", generatorSHA256, otherGenerator), inputs, true},
+		{"not an index", tampered("magic.idx", mutationIndexMagic, "NOTANIDX"), inputs, false},
+	}
+
+	for _, test := range tests {
+		index, _, err := OpenMutationIndexFile(test.path, test.inputs)
+		if err == nil {
+			index.Close()
+			t.Errorf("%s: opened the index, want an error", test.name)
+			continue
+		}
+		if errors.Is(err, ErrStaleMutationIndex) != test.wantStale {
+			t.Errorf("%s: error %v, want stale %v", test.name, err, test.wantStale)
+		}
+	}
+
+	truncatedPath := filepath.Join(dir, "truncated.idx")
+	if err := ioutil.WriteFile(truncatedPath, data[:len(data)-100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if index, _, err := OpenMutationIndexFile(truncatedPath, inputs); err == nil {
+		index.Close()
+		t.Error("opened a truncated index")
+	}
+}
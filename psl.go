@@ -0,0 +1,183 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/net/idna"
+	"io"
+	"os"
+	"strings"
+)
+
+// Snapshot of https://publicsuffix.org/list/public_suffix_list.dat, used unless a PSL file is given
+//
+//go:embed domainlists/public_suffix_list.dat
+var embeddedPublicSuffixList []byte
+
+type PSLRule struct {
+	// Punycode suffix, without any leading "*." or "!"
+	Suffix    string
+	Wildcard  bool
+	Exception bool
+	// From the PRIVATE DOMAINS section (github.io) rather than the ICANN section (co.uk)
+	Private bool
+}
+
+type PublicSuffixList struct {
+	Rules []PSLRule
+	// sha256 of the list's source text, logged so runs can be tied to the PSL they used
+	Checksum   string
+	exact      map[string]PSLRule
+	wildcards  map[string]PSLRule
+	exceptions map[string]PSLRule
+}
+
+// The PSL used by every labeler and eTLD+1 computation in this package
+var PSL *PublicSuffixList
+
+func init() {
+	psl, err := ParsePublicSuffixList(bytes.NewReader(embeddedPublicSuffixList))
+	if err != nil {
+		panic(err)
+	}
+	PSL = psl
+}
+
+// Replaces the package PSL. Must be called before any labelers are built.
+func SetPublicSuffixList(psl *PublicSuffixList) {
+	PSL = psl
+}
+
+func LoadPublicSuffixListFile(path string) (*PublicSuffixList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParsePublicSuffixList(file)
+}
+
+func ParsePublicSuffixList(r io.Reader) (*PublicSuffixList, error) {
+	psl := &PublicSuffixList{
+		Rules:      make([]PSLRule, 0),
+		exact:      make(map[string]PSLRule),
+		wildcards:  make(map[string]PSLRule),
+		exceptions: make(map[string]PSLRule),
+	}
+
+	hash := sha256.New()
+	private := false
+	scanner := bufio.NewScanner(io.TeeReader(r, hash))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "// ===BEGIN PRIVATE DOMAINS===") {
+			private = true
+		}
+		if strings.HasPrefix(line, "// ===END PRIVATE DOMAINS===") {
+			private = false
+		}
+		if len(line) == 0 || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// rules end at the first whitespace
+		line = strings.Fields(line)[0]
+
+		rule := PSLRule{Private: private}
+		if strings.HasPrefix(line, "!") {
+			rule.Exception = true
+			line = line[1:]
+		}
+		if strings.HasPrefix(line, "*.") {
+			rule.Wildcard = true
+			line = line[2:]
+		}
+
+		suffix, err := idna.ToASCII(strings.ToLower(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PSL rule %s: %s", line, err.Error())
+		}
+		rule.Suffix = suffix
+
+		switch {
+		case rule.Exception:
+			psl.exceptions[suffix] = rule
+		case rule.Wildcard:
+			psl.wildcards[suffix] = rule
+		default:
+			psl.exact[suffix] = rule
+		}
+		psl.Rules = append(psl.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(psl.Rules) == 0 {
+		return nil, fmt.Errorf("public suffix list contains no rules")
+	}
+
+	psl.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	return psl, nil
+}
+
+/*
+Returns the public suffix of domain following the publicsuffix.org algorithm, and whether it is
+ICANN-managed. As with golang.org/x/net/publicsuffix, a domain matching no rule gets its TLD.
+*/
+func (p *PublicSuffixList) PublicSuffix(domain string) (publicSuffix string, icann bool) {
+	publicSuffix = domain[strings.LastIndex(domain, ".")+1:]
+
+	// candidate suffixes from shortest to longest: the TLD, then one more label each iteration
+	labelStart := len(domain) + 1
+	for labelStart > 0 {
+		labelStart = strings.LastIndex(domain[:labelStart-1], ".") + 1
+		candidate := domain[labelStart:]
+
+		if rule, present := p.exceptions[candidate]; present {
+			return candidate[strings.Index(candidate, ".")+1:], !rule.Private
+		}
+		if rule, present := p.exact[candidate]; present {
+			publicSuffix, icann = candidate, !rule.Private
+		}
+		if rule, present := p.wildcards[candidate]; present && labelStart > 0 {
+			wildcardStart := strings.LastIndex(domain[:labelStart-1], ".") + 1
+			if _, excepted := p.exceptions[domain[wildcardStart:]]; excepted {
+				return candidate, !rule.Private
+			}
+			publicSuffix, icann = domain[wildcardStart:], !rule.Private
+		}
+	}
+
+	return publicSuffix, icann
+}
+
+// Same contract as golang.org/x/net/publicsuffix.EffectiveTLDPlusOne, against this list
+func (p *PublicSuffixList) EffectiveTLDPlusOne(domain string) (string, error) {
+	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", fmt.Errorf("publicsuffix: empty label in domain %q", domain)
+	}
+
+	suffix, _ := p.PublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return "", fmt.Errorf("publicsuffix: cannot derive eTLD+1 for domain %q", domain)
+	}
+	i := len(domain) - len(suffix) - 1
+	if domain[i] != '.' {
+		return "", fmt.Errorf("publicsuffix: invalid public suffix %q for domain %q", suffix, domain)
+	}
+
+	return domain[1+strings.LastIndex(domain[:i], "."):], nil
+}
+
+func PublicSuffix(domain string) (string, bool) {
+	return PSL.PublicSuffix(domain)
+}
+
+func EffectiveTLDPlusOne(domain string) (string, error) {
+	return PSL.EffectiveTLDPlusOne(domain)
+}
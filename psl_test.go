@@ -0,0 +1,120 @@
+package certificate_searcher
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPublicSuffixList = `// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+jp
+*.kawasaki.jp
+!city.kawasaki.jp
+*.ck
+!www.ck
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+github.io
+// ===END PRIVATE DOMAINS===
+`
+
+func TestPublicSuffixListEffectiveTLDPlusOne(t *testing.T) {
+	psl, err := ParsePublicSuffixList(strings.NewReader(testPublicSuffixList))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain     string
+		wantSuffix string
+		wantICANN  bool
+		// empty if the domain has no eTLD+1
+		wantETLD1 string
+	}{
+		{"example.com", "com", true, "example.com"},
+		{"www.example.com", "com", true, "example.com"},
+		{"com", "com", true, ""},
+		{"example.co.uk", "co.uk", true, "example.co.uk"},
+		{"a.b.example.co.uk", "co.uk", true, "example.co.uk"},
+		{"co.uk", "co.uk", true, ""},
+		{"example.uk", "uk", true, "example.uk"},
+		// wildcard rule
+		{"test.kawasaki.jp", "test.kawasaki.jp", true, ""},
+		{"a.test.kawasaki.jp", "test.kawasaki.jp", true, "a.test.kawasaki.jp"},
+		{"b.a.test.kawasaki.jp", "test.kawasaki.jp", true, "a.test.kawasaki.jp"},
+		// exception to the wildcard
+		{"city.kawasaki.jp", "kawasaki.jp", true, "city.kawasaki.jp"},
+		{"www.city.kawasaki.jp", "kawasaki.jp", true, "city.kawasaki.jp"},
+		{"test.ck", "test.ck", true, ""},
+		{"b.test.ck", "test.ck", true, "b.test.ck"},
+		{"www.ck", "ck", true, "www.ck"},
+		{"a.www.ck", "ck", true, "www.ck"},
+		// private section
+		{"github.io", "github.io", false, ""},
+		{"example.github.io", "github.io", false, "example.github.io"},
+		// no rule: the TLD is the suffix
+		{"example.unlisted", "unlisted", false, "example.unlisted"},
+		{"a.example.unlisted", "unlisted", false, "example.unlisted"},
+		// empty labels
+		{".example.com", "com", true, ""},
+		{"example.com.", "", false, ""},
+		{"a..example.com", "com", true, ""},
+	}
+
+	for _, test := range tests {
+		if test.wantSuffix != "" {
+			suffix, icann := psl.PublicSuffix(test.domain)
+			if suffix != test.wantSuffix || icann != test.wantICANN {
+				t.Errorf("PublicSuffix(%q) = %q, %v, want %q, %v", test.domain, suffix, icann, test.wantSuffix, test.wantICANN)
+			}
+		}
+
+		eTLD1, err := psl.EffectiveTLDPlusOne(test.domain)
+		if test.wantETLD1 == "" {
+			if err == nil {
+				t.Errorf("EffectiveTLDPlusOne(%q) = %q, want an error", test.domain, eTLD1)
+			}
+		} else if err != nil || eTLD1 != test.wantETLD1 {
+			t.Errorf("EffectiveTLDPlusOne(%q) = %q, %v, want %q", test.domain, eTLD1, err, test.wantETLD1)
+		}
+	}
+}
+
+func TestEmbeddedPublicSuffixList(t *testing.T) {
+	tests := []struct {
+		domain    string
+		wantETLD1 string
+	}{
+		{"mail.google.com", "google.com"},
+		{"www.bbc.co.uk", "bbc.co.uk"},
+		{"login.example.github.io", "example.github.io"},
+		{"shop.example.com.au", "example.com.au"},
+		{"xn--n3h.xn--p1ai", "xn--n3h.xn--p1ai"},
+	}
+
+	for _, test := range tests {
+		if eTLD1, err := EffectiveTLDPlusOne(test.domain); err != nil || eTLD1 != test.wantETLD1 {
+			t.Errorf("EffectiveTLDPlusOne(%q) = %q, %v, want %q", test.domain, eTLD1, err, test.wantETLD1)
+		}
+	}
+}
+
+func TestParsePublicSuffixList(t *testing.T) {
+	psl, err := ParsePublicSuffixList(strings.NewReader(testPublicSuffixList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(psl.Rules) != 9 {
+		t.Errorf("parsed %d rules, want 9", len(psl.Rules))
+	}
+	again, _ := ParsePublicSuffixList(strings.NewReader(testPublicSuffixList))
+	if psl.Checksum != again.Checksum || len(psl.Checksum) != 64 {
+		t.Errorf("checksums %q and %q of the same list differ", psl.Checksum, again.Checksum)
+	}
+
+	if _, err := ParsePublicSuffixList(strings.NewReader("// only comments\n\n")); err == nil {
+		t.Error("parsing a list without rules succeeded")
+	}
+}
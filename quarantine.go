@@ -0,0 +1,201 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Why a record could not be processed
+type ErrorCategory string
+
+const (
+	// A certificate is not valid base64
+	BASE64_INVALID ErrorCategory = "base64"
+	// A certificate is not a single well-formed DER structure
+	ASN1_INVALID ErrorCategory = "asn1"
+	// A certificate ends before its DER lengths say it does
+	TRUNCATED ErrorCategory = "truncated"
+	// A certificate is well-formed DER but the X.509 parser rejected it
+	PARSE_FAILED ErrorCategory = "parse"
+	// The input row does not hold a certificate chain at all
+	MALFORMED_ROW ErrorCategory = "row"
+	OTHER_ERROR   ErrorCategory = "other"
+)
+
+// A chain that could not be decoded, with the index of the failing certificate (-1 for the whole record)
+type ChainError struct {
+	Category   ErrorCategory
+	ChainIndex int
+	Err        error
+}
+
+func (e *ChainError) Error() string {
+	if e.ChainIndex < 0 {
+		return fmt.Sprintf("%s: %s", e.Category, e.Err.Error())
+	}
+	return fmt.Sprintf("certificate %d: %s: %s", e.ChainIndex, e.Category, e.Err.Error())
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+func CategorizeError(err error) ErrorCategory {
+	var chainErr *ChainError
+	if errors.As(err, &chainErr) {
+		return chainErr.Category
+	}
+	return OTHER_ERROR
+}
+
+// Tells truncated and otherwise malformed DER apart by checking the outermost tag and length
+func derCategory(certBytes []byte) (ErrorCategory, error) {
+	if len(certBytes) == 0 {
+		return TRUNCATED, errors.New("empty certificate")
+	}
+
+	tagAndLen, offset, err := parseTagAndLength(certBytes, 0)
+	if err != nil {
+		if strings.Contains(err.Error(), "truncated") {
+			return TRUNCATED, err
+		}
+		return ASN1_INVALID, err
+	}
+	if tagAndLen.tag != 16 || !tagAndLen.isCompound {
+		return ASN1_INVALID, fmt.Errorf("certificate is not a SEQUENCE (tag %d)", tagAndLen.tag)
+	}
+	if offset+tagAndLen.length > len(certBytes) {
+		return TRUNCATED, fmt.Errorf("certificate needs %d bytes, got %d", offset+tagAndLen.length, len(certBytes))
+	}
+	if offset+tagAndLen.length < len(certBytes) {
+		return ASN1_INVALID, fmt.Errorf("%d bytes of trailing data after certificate", len(certBytes)-offset-tagAndLen.length)
+	}
+	return "", nil
+}
+
+// Receives records a Searcher could not process. Called from worker goroutines, so implementations must be safe for concurrent use.
+type ErrorSink interface {
+	WriteError(record *CertificateRecord, err error) error
+}
+
+// One line of a quarantine file
+type QuarantinedRecord struct {
+	Origin     string        `json:"origin"`
+	Line       int           `json:"line"`
+	ChainIndex int           `json:"chain_index"`
+	Category   ErrorCategory `json:"category"`
+	Error      string        `json:"error"`
+	Raw        []string      `json:"raw"`
+}
+
+/*
+Dead-letter sink writing each failed record with its location, category and raw input row as a
+line of JSON, so failures can be inspected and re-processed later. With a nil writer it only
+counts failures per category.
+*/
+type QuarantineSink struct {
+	w      *bufio.Writer
+	closer io.Closer
+	counts map[ErrorCategory]uint64
+	mux    sync.Mutex
+}
+
+// The sink closes w on Close if it is an io.Closer
+func NewQuarantineSink(w io.Writer) *QuarantineSink {
+	sink := &QuarantineSink{counts: make(map[ErrorCategory]uint64)}
+	if w != nil {
+		sink.w = bufio.NewWriter(w)
+		if closer, ok := w.(io.Closer); ok {
+			sink.closer = closer
+		}
+	}
+	return sink
+}
+
+func (q *QuarantineSink) WriteError(record *CertificateRecord, err error) error {
+	quarantined := QuarantinedRecord{
+		Origin:     record.Origin,
+		Line:       record.Line,
+		ChainIndex: -1,
+		Category:   OTHER_ERROR,
+		Error:      err.Error(),
+		Raw:        record.Raw,
+	}
+	var chainErr *ChainError
+	if errors.As(err, &chainErr) {
+		quarantined.ChainIndex = chainErr.ChainIndex
+		quarantined.Category = chainErr.Category
+		quarantined.Error = chainErr.Err.Error()
+	}
+	if quarantined.Raw == nil {
+		quarantined.Raw = record.Chain
+	}
+
+	var recordJSON []byte
+	if q.w != nil {
+		if recordJSON, err = json.Marshal(quarantined); err != nil {
+			return err
+		}
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.counts[quarantined.Category] += 1
+	if q.w == nil {
+		return nil
+	}
+	if _, err := q.w.Write(recordJSON); err != nil {
+		return err
+	}
+	return q.w.WriteByte('\n')
+}
+
+func (q *QuarantineSink) Counts() map[ErrorCategory]uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	counts := make(map[ErrorCategory]uint64)
+	for category, count := range q.counts {
+		counts[category] = count
+	}
+	return counts
+}
+
+// Failures per category as category,count lines
+func (q *QuarantineSink) SummaryString() string {
+	var str strings.Builder
+	counts := q.Counts()
+
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		str.WriteString(fmt.Sprintf("%s,%d\n", category, counts[ErrorCategory(category)]))
+	}
+	return str.String()
+}
+
+func (q *QuarantineSink) Close() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.w == nil {
+		return nil
+	}
+	err := q.w.Flush()
+	if q.closer != nil {
+		if closeErr := q.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
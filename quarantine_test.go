@@ -0,0 +1,105 @@
+package certificate_searcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDERCategory(t *testing.T) {
+	// SEQUENCE { INTEGER 0 }
+	wellFormed := []byte{0x30, 0x03, 0x02, 0x01, 0x00}
+	// SEQUENCE of 300 bytes, with a two-byte length
+	long := append([]byte{0x30, 0x82, 0x01, 0x2c}, make([]byte, 300)...)
+
+	tests := []struct {
+		name string
+		der  []byte
+		want ErrorCategory
+	}{
+		{"well-formed", wellFormed, ""},
+		{"well-formed long length", long, ""},
+		{"empty", []byte{}, TRUNCATED},
+		{"tag only", []byte{0x30}, TRUNCATED},
+		{"length bytes cut off", []byte{0x30, 0x82, 0x01}, TRUNCATED},
+		{"contents cut off", wellFormed[:4], TRUNCATED},
+		{"long contents cut off", long[:200], TRUNCATED},
+		{"not a SEQUENCE", []byte{0x02, 0x01, 0x00}, ASN1_INVALID},
+		{"primitive SEQUENCE tag", []byte{0x10, 0x01, 0x00}, ASN1_INVALID},
+		{"trailing data", append(append([]byte{}, wellFormed...), 0x00), ASN1_INVALID},
+		{"indefinite length", []byte{0x30, 0x80, 0x02, 0x01, 0x00, 0x00, 0x00}, ASN1_INVALID},
+		{"non-minimal length", []byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x00}, ASN1_INVALID},
+	}
+
+	for _, test := range tests {
+		got, err := derCategory(test.der)
+		if got != test.want {
+			t.Errorf("%s: derCategory = %q (%v), want %q", test.name, got, err, test.want)
+		}
+		if (err == nil) != (test.want == "") {
+			t.Errorf("%s: derCategory error %v, want error %v", test.name, err, test.want != "")
+		}
+	}
+}
+
+func TestQuarantineSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewQuarantineSink(&out)
+
+	records := []struct {
+		record *CertificateRecord
+		err    error
+		want   QuarantinedRecord
+	}{
+		{
+			&CertificateRecord{Origin: "a.csv", Line: 3, Raw: []string{"3", "t", "AAAA"}},
+			&ChainError{Category: TRUNCATED, ChainIndex: 1, Err: errors.New("certificate needs 7 bytes, got 4")},
+			QuarantinedRecord{Origin: "a.csv", Line: 3, ChainIndex: 1, Category: TRUNCATED, Error: "certificate needs 7 bytes, got 4", Raw: []string{"3", "t", "AAAA"}},
+		},
+		{
+			&CertificateRecord{Origin: "a.csv", Line: 4, Chain: []string{"!!"}},
+			&ChainError{Category: BASE64_INVALID, ChainIndex: 0, Err: errors.New("illegal base64 data")},
+			QuarantinedRecord{Origin: "a.csv", Line: 4, ChainIndex: 0, Category: BASE64_INVALID, Error: "illegal base64 data", Raw: []string{"!!"}},
+		},
+		{
+			&CertificateRecord{Origin: "b.csv", Line: 1, Raw: []string{"1"}},
+			errors.New("labeler failed"),
+			QuarantinedRecord{Origin: "b.csv", Line: 1, ChainIndex: -1, Category: OTHER_ERROR, Error: "labeler failed", Raw: []string{"1"}},
+		},
+		{
+			&CertificateRecord{Origin: "b.csv", Line: 2, Raw: []string{"2"}},
+			&ChainError{Category: TRUNCATED, ChainIndex: 0, Err: errors.New("empty certificate")},
+			QuarantinedRecord{Origin: "b.csv", Line: 2, ChainIndex: 0, Category: TRUNCATED, Error: "empty certificate", Raw: []string{"2"}},
+		},
+	}
+
+	for _, r := range records {
+		if err := sink.WriteError(r.record, r.err); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(records) {
+		t.Fatalf("wrote %d lines, want %d", len(lines), len(records))
+	}
+	for idx, line := range lines {
+		var got QuarantinedRecord
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d: %s", idx+1, err)
+		}
+		if !reflect.DeepEqual(got, records[idx].want) {
+			t.Errorf("line %d = %+v, want %+v", idx+1, got, records[idx].want)
+		}
+	}
+
+	if summary := sink.SummaryString(); summary != "base64,1\nother,1\ntruncated,2\n" {
+		t.Errorf("SummaryString() = %q", summary)
+	}
+}
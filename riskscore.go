@@ -0,0 +1,241 @@
+package certificate_searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/teamnsrg/zcrypto/x509"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Points contributed by the strongest name-similarity label on a certificate
+var DefaultLabelWeights = map[DomainLabel]float64{
+	TYPOSQUATTING_MISSING_DOT:           35,
+	TYPOSQUATTING_MISSING_DOT_SUBDOMAIN: 30,
+	TYPOSQUATTING_MISSING_DOT_SUFFIX:    30,
+	TYPOSQUATTING_CHAR_OMISSION:         30,
+	TYPOSQUATTING_CHAR_PERMUTATION:      30,
+	TYPOSQUATTING_CHAR_SUBSTITUTION:     30,
+	TYPOSQUATTING_CHAR_DUPLICATION:      30,
+	TYPOSQUATTING_CHAR_INSERTION:        25,
+	TYPOSQUATTING_VOWEL_SWAP:            25,
+	TYPOSQUATTING_HYPHEN_INSERTION:      20,
+	TYPOSQUATTING_HYPHEN_REMOVAL:        20,
+	TYPOSQUATTING_NUMERAL_SWAP:          20,
+	TYPOSQUATTING_DOT_INSERTION:         20,
+	TARGET_EMBEDDING:                    25,
+	COMBOSQUATTING:                      20,
+	HOMOGRAPH:                           40,
+	IDN_CONFUSABLE:                      40,
+	WRONGTLD:                            15,
+	WRONGTLD_PRIVATE:                    20,
+	BITSQUATTING:                        10,
+	EDIT_DISTANCE:                       15,
+}
+
+// Labels that come from abuse feeds rather than name similarity
+var BlocklistLabels = map[DomainLabel]bool{
+	PHISHTANK:           true,
+	SSL_BLACKLIST:       true,
+	GOOGLE_SAFEBROWSING: true,
+}
+
+// Issuing CAs handing out certificates for free and without human review, matched against the issuer O
+var DefaultAutomatedIssuers = []string{
+	"Let's Encrypt",
+	"ZeroSSL",
+	"cPanel, Inc.",
+	"Cloudflare, Inc.",
+	"Google Trust Services LLC",
+	"Buypass AS-983163327",
+}
+
+const (
+	maxRiskScore float64 = 100
+	// Points for targeting the top-ranked base domain, decaying with log10 of the rank
+	targetRankPoints float64 = 20
+	// Points per targeted brand beyond the first, up to maxAdditionalBrandPoints
+	additionalBrandPoints    float64 = 5
+	maxAdditionalBrandPoints float64 = 15
+	blocklistPoints          float64 = 40
+	// Points for leaves valid for at most shortLifetime, typical of automated DV issuance
+	shortLifetimePoints   float64 = 10
+	shortLifetime                 = 90 * 24 * time.Hour
+	automatedIssuerPoints float64 = 10
+	// Points for OV/EV leaves, whose subject was vetted by the CA
+	vettedSubjectPoints float64 = -15
+)
+
+// One term of a risk score, kept so analysts can see why a certificate scored as it did
+type RiskComponent struct {
+	Factor string  `json:"factor"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+type RiskScore struct {
+	Score      float64         `json:"score"`
+	Components []RiskComponent `json:"components"`
+}
+
+func (r *RiskScore) add(factor string, points float64, detail string) {
+	if points == 0 {
+		return
+	}
+	r.Components = append(r.Components, RiskComponent{Factor: factor, Points: points, Detail: detail})
+	r.Score += points
+}
+
+func (r *RiskScore) String() string {
+	parts := make([]string, 0, len(r.Components))
+	for _, c := range r.Components {
+		parts = append(parts, fmt.Sprintf("%s=%+.1f (%s)", c.Factor, c.Points, c.Detail))
+	}
+	return fmt.Sprintf("%.1f: %s", r.Score, strings.Join(parts, ", "))
+}
+
+func (r *RiskScore) MarshalJSON() ([]byte, error) {
+	type riskScore RiskScore
+	rounded := riskScore(*r)
+	rounded.Score = math.Round(r.Score*10) / 10
+	return json.Marshal(rounded)
+}
+
+/*
+Combines the labels on a certificate with properties of the certificate itself into a 0-100 risk
+score. Additive terms cover the strongest label, the rank of the most popular targeted domain,
+the number of distinct brands targeted, blocklist hits, leaf lifetime and the issuing CA.
+*/
+type RiskScorer struct {
+	LabelWeights map[DomainLabel]float64
+	// Base domain -> popularity rank (1 is most popular)
+	DomainRanks      map[string]int
+	AutomatedIssuers []string
+}
+
+// Ranks base domains by their position in the list, which is expected to be sorted by popularity
+func NewRiskScorer(baseDomains *[]string) *RiskScorer {
+	rs := &RiskScorer{
+		LabelWeights:     DefaultLabelWeights,
+		DomainRanks:      make(map[string]int),
+		AutomatedIssuers: DefaultAutomatedIssuers,
+	}
+
+	for idx, domain := range *baseDomains {
+		if _, present := rs.DomainRanks[domain]; !present {
+			rs.DomainRanks[domain] = idx + 1
+		}
+	}
+
+	return rs
+}
+
+// Brand key of a base domain: the first label of its eTLD+1, so google.com and google.de are one brand
+func brandOf(baseDomain string) string {
+	eTLDplus1, err := EffectiveTLDPlusOne(baseDomain)
+	if err != nil {
+		return baseDomain
+	}
+	return strings.SplitN(eTLDplus1, ".", 2)[0]
+}
+
+// The labels of sources in a fixed order, so equal weights always resolve to the same label
+func sortedLabels(sources LabelsSources) []DomainLabel {
+	labels := make([]DomainLabel, 0, len(sources))
+	for label := range sources {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+	return labels
+}
+
+// Scores a chain from its per-name labels, its certificate-level labels and its leaf
+func (rs *RiskScorer) Score(labels map[string]LabelsSources, certLabels LabelsSources, leaf *x509.Certificate) *RiskScore {
+	score := &RiskScore{Components: make([]RiskComponent, 0)}
+
+	var strongestWeight float64
+	var strongestDetail string
+	var onBlocklist []string
+	bestRank := 0
+	var bestRanked string
+	brands := make(map[string]struct{})
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, label := range sortedLabels(labels[name]) {
+			baseDomains := labels[name][label]
+			if BlocklistLabels[label] {
+				onBlocklist = append(onBlocklist, name+":"+label.String())
+				continue
+			}
+
+			if weight := rs.LabelWeights[label]; weight > strongestWeight {
+				strongestWeight = weight
+				strongestDetail = name + ":" + label.String()
+			}
+
+			for _, baseDomain := range baseDomains {
+				rank, present := rs.DomainRanks[baseDomain]
+				if !present {
+					continue
+				}
+				brands[brandOf(baseDomain)] = struct{}{}
+				if bestRank == 0 || rank < bestRank {
+					bestRank, bestRanked = rank, baseDomain
+				}
+			}
+		}
+	}
+
+	if bestRank > 0 {
+		score.add("target_rank", targetRankPoints/(1+math.Log10(float64(bestRank))), fmt.Sprintf("%s ranked %d", bestRanked, bestRank))
+	}
+	if len(brands) > 1 {
+		score.add("brands", math.Min(additionalBrandPoints*float64(len(brands)-1), maxAdditionalBrandPoints), fmt.Sprintf("%d brands targeted", len(brands)))
+	}
+	for _, label := range sortedLabels(certLabels) {
+		if BlocklistLabels[label] {
+			onBlocklist = append(onBlocklist, label.String()+":"+strings.Join(certLabels[label], " "))
+		} else if weight := rs.LabelWeights[label]; weight > strongestWeight {
+			strongestWeight = weight
+			strongestDetail = "certificate:" + label.String()
+		}
+	}
+	if strongestWeight > 0 {
+		score.add("label", strongestWeight, strongestDetail)
+	}
+	if len(onBlocklist) > 0 {
+		sort.Strings(onBlocklist)
+		score.add("blocklist", blocklistPoints, strings.Join(onBlocklist, " "))
+	}
+
+	if leaf != nil {
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		if lifetime > 0 && lifetime <= shortLifetime {
+			score.add("lifetime", shortLifetimePoints, fmt.Sprintf("valid for %d days", int(lifetime.Hours()/24)))
+		}
+
+		for _, issuer := range rs.AutomatedIssuers {
+			if containsFold(leaf.Issuer.Organization, issuer) {
+				score.add("issuer", automatedIssuerPoints, issuer)
+				break
+			}
+		}
+
+		switch level := leaf.ValidationLevel.String(); level {
+		case "OV", "EV":
+			score.add("validation_level", vettedSubjectPoints, level)
+		}
+	}
+
+	score.Score = math.Max(0, math.Min(score.Score, maxRiskScore))
+
+	return score
+}
@@ -0,0 +1,254 @@
+package certificate_searcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	stdpkix "crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"github.com/teamnsrg/zcrypto/x509"
+	"github.com/teamnsrg/zcrypto/x509/pkix"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// google.com ranked 1, paypal.com 10 and apple.com 100
+func testRiskScorer() *RiskScorer {
+	baseDomains := []string{"google.com", "google.de"}
+	for len(baseDomains) < 99 {
+		baseDomains = append(baseDomains, fmt.Sprintf("filler%d.com", len(baseDomains)))
+	}
+	baseDomains[9] = "paypal.com"
+	baseDomains = append(baseDomains, "apple.com")
+	return NewRiskScorer(&baseDomains)
+}
+
+func TestRiskScorerScore(t *testing.T) {
+	yearLeaf := &x509.Certificate{NotBefore: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), NotAfter: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	shortLeaf := &x509.Certificate{NotBefore: yearLeaf.NotBefore, NotAfter: yearLeaf.NotBefore.Add(90 * 24 * time.Hour)}
+	longerLeaf := &x509.Certificate{NotBefore: yearLeaf.NotBefore, NotAfter: yearLeaf.NotBefore.Add(91 * 24 * time.Hour)}
+	automatedLeaf := &x509.Certificate{NotBefore: yearLeaf.NotBefore, NotAfter: yearLeaf.NotAfter, Issuer: pkix.Name{Organization: []string{"let's encrypt"}}}
+
+	tests := []struct {
+		name       string
+		labels     map[string]LabelsSources
+		certLabels LabelsSources
+		leaf       *x509.Certificate
+		want       []RiskComponent
+	}{
+		{
+			"label weight and top rank",
+			map[string]LabelsSources{"gogle.com": {TYPOSQUATTING_CHAR_OMISSION: {"google.com"}}},
+			nil, nil,
+			[]RiskComponent{{"target_rank", 20, "google.com ranked 1"}, {"label", 30, "gogle.com:TYPOSQUATTING_CHAR_OMISSION"}},
+		},
+		{
+			"rank decays with log10",
+			map[string]LabelsSources{"paypal.net": {WRONGTLD: {"paypal.com"}}, "apple.net": {WRONGTLD: {"apple.com"}}},
+			nil, nil,
+			[]RiskComponent{{"target_rank", 10, "paypal.com ranked 10"}, {"brands", 5, "2 brands targeted"}, {"label", 15, "apple.net:WRONGTLD"}},
+		},
+		{
+			"unranked source",
+			map[string]LabelsSources{"gogle.com": {EDIT_DISTANCE: {"example.com"}}},
+			nil, nil,
+			[]RiskComponent{{"label", 15, "gogle.com:EDIT_DISTANCE"}},
+		},
+		{
+			"one brand across suffixes",
+			map[string]LabelsSources{"gogle.com": {TYPOSQUATTING_CHAR_OMISSION: {"google.com", "google.de"}}},
+			nil, nil,
+			[]RiskComponent{{"target_rank", 20, "google.com ranked 1"}, {"label", 30, "gogle.com:TYPOSQUATTING_CHAR_OMISSION"}},
+		},
+		{
+			"brands capped",
+			map[string]LabelsSources{"x.com": {COMBOSQUATTING: {"google.com", "paypal.com", "apple.com", "filler2.com", "filler3.com", "filler4.com"}}},
+			nil, nil,
+			[]RiskComponent{{"target_rank", 20, "google.com ranked 1"}, {"brands", 15, "6 brands targeted"}, {"label", 20, "x.com:COMBOSQUATTING"}},
+		},
+		{
+			// equal weights resolve to the first name, then the lowest label
+			"tied weights",
+			map[string]LabelsSources{
+				"gogle.com":   {EDIT_DISTANCE: {"google.com"}},
+				"goolge.com":  {TYPOSQUATTING_CHAR_SUBSTITUTION: {"google.com"}, TYPOSQUATTING_CHAR_PERMUTATION: {"google.com"}, TYPOSQUATTING_CHAR_DUPLICATION: {"google.com"}},
+				"zgoogle.com": {TYPOSQUATTING_CHAR_OMISSION: {"google.com"}},
+			},
+			nil, nil,
+			[]RiskComponent{{"target_rank", 20, "google.com ranked 1"}, {"label", 30, "goolge.com:TYPOSQUATTING_CHAR_PERMUTATION"}},
+		},
+		{
+			"certificate label stronger than name labels",
+			map[string]LabelsSources{"paypal.net": {WRONGTLD: {"paypal.com"}}},
+			LabelsSources{HOMOGRAPH: {"paypal.com"}}, nil,
+			[]RiskComponent{{"target_rank", 10, "paypal.com ranked 10"}, {"label", 40, "certificate:HOMOGRAPH"}},
+		},
+		{
+			"blocklists",
+			map[string]LabelsSources{"evil.net": {PHISHTANK: nil}},
+			LabelsSources{SSL_BLACKLIST: {"abusive"}}, nil,
+			[]RiskComponent{{"blocklist", 40, "SSL_BLACKLIST:abusive evil.net:PHISHTANK"}},
+		},
+		{
+			"year-long leaf",
+			map[string]LabelsSources{"evil.net": {PHISHTANK: nil}},
+			nil, yearLeaf,
+			[]RiskComponent{{"blocklist", 40, "evil.net:PHISHTANK"}},
+		},
+		{
+			"short-lived leaf",
+			map[string]LabelsSources{"evil.net": {PHISHTANK: nil}},
+			nil, shortLeaf,
+			[]RiskComponent{{"blocklist", 40, "evil.net:PHISHTANK"}, {"lifetime", 10, "valid for 90 days"}},
+		},
+		{
+			"just over the short lifetime",
+			map[string]LabelsSources{"evil.net": {PHISHTANK: nil}},
+			nil, longerLeaf,
+			[]RiskComponent{{"blocklist", 40, "evil.net:PHISHTANK"}},
+		},
+		{
+			"automated issuer",
+			map[string]LabelsSources{"evil.net": {PHISHTANK: nil}},
+			nil, automatedLeaf,
+			[]RiskComponent{{"blocklist", 40, "evil.net:PHISHTANK"}, {"issuer", 10, "Let's Encrypt"}},
+		},
+	}
+
+	scorer := testRiskScorer()
+	for _, test := range tests {
+		// map order must not show through, so score each case a few times
+		for run := 0; run < 10; run++ {
+			score := scorer.Score(test.labels, test.certLabels, test.leaf)
+
+			got := make([]RiskComponent, len(score.Components))
+			for idx, component := range score.Components {
+				component.Points = math.Round(component.Points*100) / 100
+				got[idx] = component
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("%s: components = %v, want %v", test.name, got, test.want)
+			}
+
+			var sum float64
+			for _, component := range score.Components {
+				sum += component.Points
+			}
+			if want := math.Min(sum, maxRiskScore); math.Abs(score.Score-want) > 1e-9 {
+				t.Fatalf("%s: score = %f, want %f", test.name, score.Score, want)
+			}
+		}
+	}
+}
+
+func TestRiskScorerScoreClamped(t *testing.T) {
+	labels := map[string]LabelsSources{"xn--ggle-55da.com": {HOMOGRAPH: {"google.com"}, PHISHTANK: nil}}
+	certLabels := LabelsSources{COMBOSQUATTING: {"paypal.com", "apple.com", "filler2.com", "filler3.com"}}
+	shortLeaf := &x509.Certificate{NotBefore: time.Now(), NotAfter: time.Now().Add(30 * 24 * time.Hour)}
+
+	if score := testRiskScorer().Score(labels, certLabels, shortLeaf); score.Score != maxRiskScore {
+		t.Errorf("Score = %s, want %.0f", score, maxRiskScore)
+	}
+	if score := testRiskScorer().Score(nil, nil, nil); score.Score != 0 || len(score.Components) != 0 {
+		t.Errorf("Score of nothing = %s, want 0 with no components", score)
+	}
+}
+
+// Base64 DER of a self-signed certificate for names, valid for a year
+func testCertificate(t *testing.T, names ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &stdx509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      stdpkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := stdx509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// Sends a fixed list of records
+type recordsSource []*CertificateRecord
+
+func (r recordsSource) Read(ctx context.Context, records chan<- *CertificateRecord) error {
+	for _, record := range r {
+		select {
+		case records <- record:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Collects the origins of the results written to it
+type originsSink struct {
+	mux     sync.Mutex
+	origins []string
+}
+
+func (o *originsSink) Write(result *SearchResult) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.origins = append(o.origins, result.Record.Origin)
+	return nil
+}
+
+func TestSearcherMinScore(t *testing.T) {
+	scorer := testRiskScorer()
+	baseDomains := []string{"google.com", "paypal.com"}
+
+	source := recordsSource{
+		// TARGET_EMBEDDING 25 + rank 1 20
+		{Origin: "google", Chain: []string{testCertificate(t, "google.com.evil.net")}},
+		// TARGET_EMBEDDING 25 + rank 10 10
+		{Origin: "paypal", Chain: []string{testCertificate(t, "paypal.com-login.net")}},
+		{Origin: "unlabeled", Chain: []string{testCertificate(t, "example.com")}},
+	}
+
+	tests := []struct {
+		minScore float64
+		want     []string
+	}{
+		{0, []string{"google", "paypal"}},
+		{35, []string{"google", "paypal"}},
+		{35.1, []string{"google"}},
+		{45, []string{"google"}},
+		{45.1, nil},
+	}
+
+	for _, test := range tests {
+		searcher := NewSearcher(SearcherOptions{
+			Labelers:   []DomainLabeler{NewTargetEmbeddingLabeler(&baseDomains)},
+			RiskScorer: scorer,
+			MinScore:   test.minScore,
+		})
+		sink := &originsSink{}
+		if err := searcher.Run(context.Background(), source, sink); err != nil {
+			t.Fatalf("Run: %s", err)
+		}
+
+		sort.Strings(sink.origins)
+		if !reflect.DeepEqual(sink.origins, test.want) {
+			t.Errorf("min score %.1f: wrote %v, want %v", test.minScore, sink.origins, test.want)
+		}
+		if stats := searcher.Stats(); stats.Records != 3 || stats.Labeled != 2 || stats.Errors != 0 {
+			t.Errorf("min score %.1f: stats %+v, want 3 records and 2 labeled", test.minScore, stats)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package certificate_searcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/teamnsrg/zcrypto/x509"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// One certificate to search, as read from a Source
+type CertificateRecord struct {
+	// Base64 DER certificates, leaf first
+	Chain []string
+	// Where the record came from, for error reports
+	Origin string
+	Line   int
+	// The input row as read, for quarantining records that cannot be processed
+	Raw []string
+}
+
+// Produces certificate records for a Searcher
+type Source interface {
+	// Sends records until the source is exhausted or ctx is done, in which case it returns ctx.Err()
+	Read(ctx context.Context, records chan<- *CertificateRecord) error
+}
+
+// Receives search results. Write is called from the worker goroutines and must be safe for concurrent use.
+type Sink interface {
+	Write(result *SearchResult) error
+}
+
+type SearchResult struct {
+	Record *CertificateRecord
+	// Parsed chain, leaf first; only names and raw bytes are set with SearcherOptions.NamesOnly
+	Chain []*x509.Certificate
+	// The labeled chain, nil with SearcherOptions.StatsOnly
+	Labeled *LabeledCertChain
+	// Set instead of Labeled with SearcherOptions.StatsOnly
+	Info *CertInfo
+}
+
+type SearcherOptions struct {
+	Labelers            []DomainLabeler
+	CertificateLabelers []CertificateLabeler
+	// Optional, suppresses matches on names brands own
+	Allowlist *Allowlist
+	// Optional, scores labeled chains; chains scoring below MinScore are dropped
+	RiskScorer *RiskScorer
+	MinScore   float64
+	// Parallel parsers and labelers, runtime.NumCPU() if 0
+	Workers int
+	// Only parse names from certificates until a chain is labeled (faster)
+	NamesOnly bool
+	// Skip labeling and produce a CertInfo for every certificate, for collecting statistics
+	StatsOnly bool
+	// Called from worker goroutines with every result before it is written to the sink
+	OnResult func(result *SearchResult)
+	// Called from worker goroutines with every record that could not be processed
+	OnError func(record *CertificateRecord, err error)
+	// Optional, receives every record that could not be processed along with its error
+	ErrorSink ErrorSink
+	// Optional, called from worker goroutines with the time each record spent in each SearchStage
+	OnStage func(stage SearchStage, elapsed time.Duration)
+	// Optional, called from worker goroutines with the time each domain labeler took for one name
+	OnLabeler func(labeler string, elapsed time.Duration)
+}
+
+// A step of processing one record, for latency reporting
+type SearchStage string
+
+const (
+	// Base64 decoding and certificate parsing
+	DECODE_STAGE SearchStage = "decode"
+	// Domain and certificate labeling, allowlist filtering and risk scoring
+	LABEL_STAGE SearchStage = "label"
+	// Writing the result to the sink
+	WRITE_STAGE SearchStage = "write"
+)
+
+func (s *Searcher) observeStage(stage SearchStage, start time.Time) {
+	if s.Options.OnStage != nil {
+		s.Options.OnStage(stage, time.Since(start))
+	}
+}
+
+// Counts of what a Searcher has processed so far
+type SearchStats struct {
+	Records uint64 `json:"records"`
+	Errors  uint64 `json:"errors"`
+	Labeled uint64 `json:"labeled"`
+	Written uint64 `json:"written"`
+}
+
+/*
+Parses certificate chains from a Source in parallel, labels the names on each leaf and passes
+labeled chains to a Sink. A Searcher holds no global state and can be run any number of times,
+but Run must not be called concurrently.
+*/
+type Searcher struct {
+	Options      SearcherOptions
+	stats        SearchStats
+	labelerNames []string
+}
+
+func NewSearcher(options SearcherOptions) *Searcher {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	labelerNames := make([]string, 0, len(options.Labelers))
+	for _, labeler := range options.Labelers {
+		labelerNames = append(labelerNames, labelerName(labeler))
+	}
+	return &Searcher{Options: options, labelerNames: labelerNames}
+}
+
+func (s *Searcher) Stats() SearchStats {
+	return SearchStats{
+		Records: atomic.LoadUint64(&s.stats.Records),
+		Errors:  atomic.LoadUint64(&s.stats.Errors),
+		Labeled: atomic.LoadUint64(&s.stats.Labeled),
+		Written: atomic.LoadUint64(&s.stats.Written),
+	}
+}
+
+/*
+Searches every record source produces, writing results to sink (which may be nil when only
+OnResult is used). Cancelling ctx stops the source; records it already sent are still processed,
+so when Run returns every record the source produced has reached the sink. Returns the source's
+error, which is ctx.Err() for a cancelled run.
+*/
+func (s *Searcher) Run(ctx context.Context, source Source, sink Sink) error {
+	records := make(chan *CertificateRecord, 100)
+
+	var sourceErr error
+	go func() {
+		sourceErr = source.Read(ctx, records)
+		close(records)
+	}()
+
+	workerWG := &sync.WaitGroup{}
+	for i := 0; i < s.Options.Workers; i++ {
+		workerWG.Add(1)
+		go s.processRecords(records, sink, workerWG)
+	}
+	workerWG.Wait()
+
+	return sourceErr
+}
+
+func (s *Searcher) reportError(record *CertificateRecord, err error) {
+	atomic.AddUint64(&s.stats.Errors, 1)
+	if s.Options.OnError != nil {
+		s.Options.OnError(record, err)
+	}
+	if s.Options.ErrorSink != nil {
+		if sinkErr := s.Options.ErrorSink.WriteError(record, err); sinkErr != nil && s.Options.OnError != nil {
+			s.Options.OnError(record, sinkErr)
+		}
+	}
+}
+
+// Workers keep going until the source closes records, so everything the source sent gets processed
+func (s *Searcher) processRecords(records <-chan *CertificateRecord, sink Sink, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	parser := x509.NewCertParser()
+	for record := range records {
+		atomic.AddUint64(&s.stats.Records, 1)
+
+		result, err := s.processRecord(record, parser)
+		if err != nil {
+			s.reportError(record, err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+
+		if s.Options.OnResult != nil {
+			s.Options.OnResult(result)
+		}
+		if sink != nil {
+			start := time.Now()
+			if err := sink.Write(result); err != nil {
+				s.reportError(record, err)
+				continue
+			}
+			s.observeStage(WRITE_STAGE, start)
+			atomic.AddUint64(&s.stats.Written, 1)
+		}
+	}
+}
+
+// Errors are *ChainError, categorized and naming the failing certificate's index in the chain
+func DecodeCertificateChain(encodedCertChain []string, parser *x509.CertParser, onlyParseName bool) ([]*x509.Certificate, error) {
+	certChain := make([]*x509.Certificate, 0)
+	for idx, encodedCert := range encodedCertChain {
+		certBytes, err := base64.StdEncoding.DecodeString(encodedCert)
+		if err != nil {
+			return nil, &ChainError{Category: BASE64_INVALID, ChainIndex: idx, Err: err}
+		}
+
+		var cert *x509.Certificate
+		if onlyParseName {
+			cert, err = ParseCertificateNamesOnly(certBytes)
+		} else {
+			cert, err = parser.ParseCertificate(certBytes)
+		}
+
+		if err != nil {
+			if category, derErr := derCategory(certBytes); derErr != nil {
+				return nil, &ChainError{Category: category, ChainIndex: idx, Err: derErr}
+			}
+			category := PARSE_FAILED
+			switch err.(type) {
+			case SyntaxError, StructuralError:
+				category = ASN1_INVALID
+			}
+			return nil, &ChainError{Category: category, ChainIndex: idx, Err: fmt.Errorf("unable to parse certificate: %s", err.Error())}
+		}
+		certChain = append(certChain, cert)
+	}
+
+	if len(certChain) == 0 {
+		return nil, &ChainError{Category: MALFORMED_ROW, ChainIndex: -1, Err: errors.New("empty chain")}
+	}
+	return certChain, nil
+}
+
+// Drops details whose base domain was suppressed by the allowlist
+func keptDetails(details []MatchDetail, keptBaseDomains []string) []MatchDetail {
+	kept := make([]MatchDetail, 0, len(details))
+	for _, detail := range details {
+		// matches without a base domain have nothing an allowlist could suppress
+		if detail.BaseDomain == "" {
+			kept = append(kept, detail)
+			continue
+		}
+		for _, baseDomain := range keptBaseDomains {
+			if detail.BaseDomain == baseDomain {
+				kept = append(kept, detail)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// The result for record, or nil if nothing on it was labeled
+func (s *Searcher) processRecord(record *CertificateRecord, parser *x509.CertParser) (*SearchResult, error) {
+	if record.Chain == nil {
+		return nil, &ChainError{Category: MALFORMED_ROW, ChainIndex: -1, Err: fmt.Errorf("no certificate chain in %d-column row", len(record.Raw))}
+	}
+
+	start := time.Now()
+	certChain, err := DecodeCertificateChain(record.Chain, parser, s.Options.NamesOnly)
+	if err != nil {
+		return nil, err
+	}
+	s.observeStage(DECODE_STAGE, start)
+
+	leafCert := certChain[0]
+	result := &SearchResult{Record: record, Chain: certChain}
+
+	if s.Options.StatsOnly {
+		if len(certChain) >= 2 {
+			parentCert := certChain[1]
+			result.Info = NewCertInfo(leafCert.ValidationLevel.String(), leafCert.NotBefore, leafCert.FingerprintNoCT, parentCert.SPKISubjectFingerprint)
+		} else {
+			result.Info = NewCertInfo(leafCert.ValidationLevel.String(), leafCert.NotBefore, leafCert.FingerprintNoCT, []byte("No parent"))
+		}
+		return result, nil
+	}
+
+	start = time.Now()
+	maldomainLabels := make(map[string]LabelsSources)
+	maldomainDetails := make(map[string]LabelsDetails)
+	maldomainSuppressed := make(map[string]LabelsSources)
+	for _, name := range append([]string{leafCert.Subject.CommonName}, leafCert.DNSNames...) {
+		for idx, labeler := range s.Options.Labelers {
+			labelerStart := time.Now()
+			// detailed labelers run once, their labels taken from the details
+			var labels map[DomainLabel][]string
+			var details map[DomainLabel][]MatchDetail
+			if detailedLabeler, ok := labeler.(DetailedDomainLabeler); ok {
+				details = detailedLabeler.LabelDomainDetails(name)
+				labels = DetailLabels(details)
+			} else {
+				labels = labeler.LabelDomain(name)
+			}
+			if s.Options.OnLabeler != nil {
+				s.Options.OnLabeler(s.labelerNames[idx], time.Since(labelerStart))
+			}
+			if len(labels) > 0 && s.Options.Allowlist != nil {
+				var suppressed map[DomainLabel][]string
+				labels, suppressed = s.Options.Allowlist.Filter(name, labels, leafCert)
+				if len(suppressed) > 0 {
+					if _, present := maldomainSuppressed[name]; !present {
+						maldomainSuppressed[name] = make(LabelsSources)
+					}
+					for label, originDomains := range suppressed {
+						maldomainSuppressed[name][label] = originDomains
+					}
+				}
+			}
+
+			if len(labels) > 0 {
+				if _, present := maldomainLabels[name]; !present {
+					maldomainLabels[name] = make(LabelsSources)
+				}
+
+				for label, originDomains := range labels {
+					maldomainLabels[name][label] = originDomains
+				}
+
+				for label, labelDetails := range details {
+					// labels the allowlist suppressed entirely have no details left
+					originDomains, present := labels[label]
+					if !present {
+						continue
+					}
+					if _, present := maldomainDetails[name]; !present {
+						maldomainDetails[name] = make(LabelsDetails)
+					}
+					maldomainDetails[name][label] = keptDetails(labelDetails, originDomains)
+				}
+			}
+		}
+	}
+
+	certLabels := make(LabelsSources)
+	for _, certLabeler := range s.Options.CertificateLabelers {
+		for label, sources := range certLabeler.LabelCertificate(certChain, maldomainLabels) {
+			certLabels[label] = append(certLabels[label], sources...)
+		}
+	}
+
+	if len(maldomainLabels) == 0 && len(certLabels) == 0 {
+		s.observeStage(LABEL_STAGE, start)
+		return nil, nil
+	}
+	atomic.AddUint64(&s.stats.Labeled, 1)
+
+	// output carries the fully parsed chain
+	if s.Options.NamesOnly {
+		if certChain, err = DecodeCertificateChain(record.Chain, parser, false); err != nil {
+			return nil, err
+		}
+		result.Chain = certChain
+	}
+
+	result.Labeled = s.labelChain(certChain, maldomainLabels, certLabels, maldomainDetails, maldomainSuppressed)
+	s.observeStage(LABEL_STAGE, start)
+	if result.Labeled.RiskScore != nil && result.Labeled.RiskScore.Score < s.Options.MinScore {
+		return nil, nil
+	}
+
+	return result, nil
+}
+
+func (s *Searcher) labelChain(chain []*x509.Certificate, labels map[string]LabelsSources, certLabels LabelsSources, details map[string]LabelsDetails, suppressed map[string]LabelsSources) *LabeledCertChain {
+	var leafParent *x509.Certificate
+	leaf := chain[0]
+	if len(chain) > 1 {
+		leafParent = chain[1]
+	}
+
+	certChain := &LabeledCertChain{
+		AbuseDomains: labels,
+		Leaf:         leaf,
+		LeafParent:   leafParent,
+		Root:         chain[len(chain)-1],
+		ChainDepth:   len(chain),
+		MatchDetails: details,
+	}
+	if len(certLabels) > 0 {
+		certChain.CertificateLabels = certLabels
+	}
+	if len(suppressed) > 0 {
+		certChain.SuppressedDomains = suppressed
+	}
+	if s.Options.RiskScorer != nil {
+		certChain.RiskScore = s.Options.RiskScorer.Score(labels, certLabels, leaf)
+	}
+
+	return certChain
+}
+
+/*
+Reads CT log CSV exports, taking the base64 leaf from column 2 and the |-separated base64 chain
+from column 4. Files that cannot be read are reported to OnError and skipped. Rows with too few
+columns are sent without a chain, so the Searcher reports them as MALFORMED_ROW errors.
+*/
+type CSVFileSource struct {
+	Paths []string
+	// Lines of the first path up to and including this one are skipped, to resume from a checkpoint
+	StartLine int
+	// Called before each file is read
+	OnOpen  func(path string)
+	OnError func(path string, err error)
+
+	// file and line of the last record sent
+	path string
+	line int
+	mux  sync.Mutex
+
+	filesDone  uint64
+	bytesRead  uint64
+	bytesTotal uint64
+}
+
+// How much of its input a CSVFileSource has read
+type CSVProgress struct {
+	FilesDone  uint64
+	FilesTotal uint64
+	BytesRead  uint64
+	// Combined size of all paths, 0 until Read starts
+	BytesTotal uint64
+}
+
+func (c *CSVFileSource) Progress() CSVProgress {
+	return CSVProgress{
+		FilesDone:  atomic.LoadUint64(&c.filesDone),
+		FilesTotal: uint64(len(c.Paths)),
+		BytesRead:  atomic.LoadUint64(&c.bytesRead),
+		BytesTotal: atomic.LoadUint64(&c.bytesTotal),
+	}
+}
+
+// Counts bytes read from a file into CSVFileSource.bytesRead
+type countingReader struct {
+	r     io.Reader
+	count *uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(c.count, uint64(n))
+	return n, err
+}
+
+func NewCSVFileSource(paths []string) *CSVFileSource {
+	return &CSVFileSource{Paths: paths}
+}
+
+const (
+	csvCertIndex      int    = 2
+	csvChainIndex     int    = 4
+	csvChainDelimiter string = "|"
+)
+
+func (c *CSVFileSource) reportError(path string, err error) {
+	if c.OnError != nil {
+		c.OnError(path, err)
+	}
+}
+
+// File and line of the last record sent, everything up to which a finished Run has processed
+func (c *CSVFileSource) Position() (string, int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.path, c.line
+}
+
+func (c *CSVFileSource) setPosition(path string, line int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.path, c.line = path, line
+}
+
+func (c *CSVFileSource) Read(ctx context.Context, records chan<- *CertificateRecord) error {
+	var bytesTotal uint64
+	for _, path := range c.Paths {
+		if info, err := os.Stat(path); err == nil {
+			bytesTotal += uint64(info.Size())
+		}
+	}
+	atomic.StoreUint64(&c.bytesTotal, bytesTotal)
+
+	for idx, path := range c.Paths {
+		startLine := 0
+		if idx == 0 {
+			startLine = c.StartLine
+		}
+		if err := c.readFile(ctx, path, startLine, records); err != nil {
+			return err
+		}
+		atomic.AddUint64(&c.filesDone, 1)
+	}
+	return nil
+}
+
+func (c *CSVFileSource) readFile(ctx context.Context, path string, startLine int, records chan<- *CertificateRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.OnOpen != nil {
+		c.OnOpen(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.reportError(path, err)
+		return nil
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(&countingReader{r: f, count: &c.bytesRead})
+	reader.FieldsPerRecord = -1
+
+	var row []string
+	line := 0
+	for row, err = reader.Read(); err == nil; row, err = reader.Read() {
+		line++
+		if line <= startLine {
+			continue
+		}
+		record := &CertificateRecord{Origin: path, Line: line, Raw: row}
+		if len(row) > csvChainIndex {
+			certB64 := row[csvCertIndex]
+			record.Chain = strings.Split(strings.TrimSpace(row[csvChainIndex]), csvChainDelimiter)
+			if row[csvChainIndex] == "" {
+				record.Chain = []string{certB64}
+			} else if record.Chain[0] != certB64 {
+				record.Chain = append([]string{certB64}, record.Chain...)
+			}
+		}
+
+		select {
+		case records <- record:
+			c.setPosition(path, line)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err != io.EOF {
+		c.reportError(path, err)
+	}
+	c.setPosition(path, line)
+
+	return nil
+}
+
+// Writes each labeled chain as a line of JSON
+type JSONLinesSink struct {
+	w      *bufio.Writer
+	closer io.Closer
+	mux    sync.Mutex
+}
+
+// The sink closes w on Close if it is an io.Closer
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	sink := &JSONLinesSink{w: bufio.NewWriterSize(w, 4096*1000)}
+	if closer, ok := w.(io.Closer); ok {
+		sink.closer = closer
+	}
+	return sink
+}
+
+func (j *JSONLinesSink) Write(result *SearchResult) error {
+	if result.Labeled == nil {
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(result.Labeled)
+	if err != nil {
+		return err
+	}
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if _, err := j.w.Write(jsonBytes); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *JSONLinesSink) Close() error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	err := j.w.Flush()
+	if j.closer != nil {
+		if closeErr := j.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package certificate_searcher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Writes CSV files of the given row counts to dir, returning their paths
+func writeCSVFiles(t *testing.T, dir string, rowCounts ...int) []string {
+	paths := make([]string, 0, len(rowCounts))
+	for idx, rows := range rowCounts {
+		contents := ""
+		for row := 1; row <= rows; row++ {
+			contents += fmt.Sprintf("%d,2020-05-26,leaf%d,x,leaf%d|issuer\n", row, row, row)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d.csv", idx))
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+type recordPosition struct {
+	file int
+	line int
+}
+
+func TestCSVFileSourceResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	paths := writeCSVFiles(t, dir, 3, 2)
+
+	tests := []struct {
+		name      string
+		startLine int
+		// records to read before the context is cancelled, -1 for all
+		readLimit    int
+		want         []recordPosition
+		wantPosition recordPosition
+	}{
+		{"from the start", 0, -1, []recordPosition{{0, 1}, {0, 2}, {0, 3}, {1, 1}, {1, 2}}, recordPosition{1, 2}},
+		{"after a checkpoint", 2, -1, []recordPosition{{0, 3}, {1, 1}, {1, 2}}, recordPosition{1, 2}},
+		{"after the end of the first file", 3, -1, []recordPosition{{1, 1}, {1, 2}}, recordPosition{1, 2}},
+		{"interrupted", 0, 2, []recordPosition{{0, 1}, {0, 2}}, recordPosition{0, 2}},
+		{"interrupted after a checkpoint", 2, 2, []recordPosition{{0, 3}, {1, 1}}, recordPosition{1, 1}},
+	}
+
+	fileIndex := map[string]int{paths[0]: 0, paths[1]: 1}
+	for _, test := range tests {
+		source := NewCSVFileSource(paths)
+		source.StartLine = test.startLine
+
+		ctx, cancel := context.WithCancel(context.Background())
+		records := make(chan *CertificateRecord)
+		done := make(chan error)
+		go func() {
+			done <- source.Read(ctx, records)
+		}()
+
+		got := make([]recordPosition, 0)
+		for running := true; running; {
+			select {
+			case record := <-records:
+				got = append(got, recordPosition{fileIndex[record.Origin], record.Line})
+				if len(got) == test.readLimit {
+					cancel()
+					running = false
+				}
+			case <-done:
+				running = false
+			}
+		}
+		if test.readLimit >= 0 {
+			<-done
+		}
+		cancel()
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: read records %v, want %v", test.name, got, test.want)
+		}
+		path, line := source.Position()
+		if gotPosition := (recordPosition{fileIndex[path], line}); gotPosition != test.wantPosition {
+			t.Errorf("%s: Position() = %v, want %v", test.name, gotPosition, test.wantPosition)
+		}
+	}
+}
+
+func TestCSVFileSourceChains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "chains.csv")
+	contents := "1,t,leaf,x,leaf|issuer\n2,t,leaf,x,issuer|root\n3,t,leaf,x,\n4,t,leaf\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"leaf", "issuer"}, {"leaf", "issuer", "root"}, {"leaf"}, nil}
+
+	missing := filepath.Join(dir, "missing.csv")
+	var reportedErrors []string
+	source := NewCSVFileSource([]string{missing, path})
+	source.OnError = func(path string, err error) {
+		reportedErrors = append(reportedErrors, path)
+	}
+
+	records := make(chan *CertificateRecord, len(want)+1)
+	if err := source.Read(context.Background(), records); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	close(records)
+
+	got := make([][]string, 0)
+	for record := range records {
+		got = append(got, record.Chain)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chains = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(reportedErrors, []string{missing}) {
+		t.Errorf("reported errors for %v, want only %s", reportedErrors, missing)
+	}
+}
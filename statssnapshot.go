@@ -0,0 +1,234 @@
+package certificate_searcher
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statsSnapshotVersion int = 2
+
+type ParentStats struct {
+	Certificates uint64 `json:"certificates"`
+	// Whether Certificates was estimated from the sketches of several shards rather than counted
+	Estimated bool `json:"estimated,omitempty"`
+	// Sketch of the certificates counted
+	Sketch *HyperLogLog `json:"sketch"`
+}
+
+/*
+Serialized CertStats of one stats run, or of several merged with MergeStatsSnapshots. Shards of a
+corpus can count the same certificate, so merged counts come from the union of the per-parent
+sketches rather than from adding the counts up, and the total from the union of the sketches of
+all certificates.
+*/
+type StatsSnapshot struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Runs merged into this snapshot
+	Shards int `json:"shards"`
+	// Certificates counted under any parent
+	Certificates uint64 `json:"certificates"`
+	// Whether Certificates was estimated from the sketches of several shards rather than counted
+	Estimated bool         `json:"estimated,omitempty"`
+	Sketch    *HyperLogLog `json:"sketch"`
+	// Parent SPKI subject fingerprint (hex) -> certificates issued by it
+	Parents map[string]*ParentStats `json:"parents"`
+}
+
+func (c *CertStats) Snapshot() *StatsSnapshot {
+	snapshot := &StatsSnapshot{
+		Version:      statsSnapshotVersion,
+		Created:      time.Now().UTC(),
+		Shards:       1,
+		Certificates: c.Certificates,
+		Sketch:       c.Sketch,
+		Parents:      make(map[string]*ParentStats),
+	}
+	for parent, count := range c.ParentSPKISubjectCounts {
+		sketch := c.ParentSPKISubjectSketches[parent]
+		if sketch == nil {
+			sketch = NewHyperLogLog()
+		}
+		snapshot.Parents[parent] = &ParentStats{Certificates: count, Sketch: sketch}
+	}
+	return snapshot
+}
+
+// Continues counting from snapshot, as a resumed run does from the snapshot of the interrupted one
+func (c *CertStats) Restore(snapshot *StatsSnapshot) {
+	c.Certificates = snapshot.Certificates
+	c.Sketch = snapshot.Sketch
+	for parent, stats := range snapshot.Parents {
+		c.ParentSPKISubjectCounts[parent] = stats.Certificates
+		c.ParentSPKISubjectSketches[parent] = stats.Sketch
+	}
+}
+
+// Writes the snapshot as gzipped JSON to a temporary file and renames it over path
+func WriteStatsSnapshot(path string, snapshot *StatsSnapshot) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := gzip.NewWriter(tempFile)
+	err = json.NewEncoder(w).Encode(snapshot)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+func LoadStatsSnapshot(path string) (*StatsSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a stats snapshot: %s", path, err.Error())
+	}
+	defer r.Close()
+
+	snapshot := &StatsSnapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, fmt.Errorf("%s is not a stats snapshot: %s", path, err.Error())
+	}
+	if snapshot.Version != statsSnapshotVersion {
+		return nil, fmt.Errorf("%s has stats snapshot version %d, expected %d", path, snapshot.Version, statsSnapshotVersion)
+	}
+	if snapshot.Sketch == nil {
+		return nil, fmt.Errorf("%s has no sketch of all certificates", path)
+	}
+	if err := snapshot.Sketch.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	for parent, stats := range snapshot.Parents {
+		if stats.Sketch == nil {
+			return nil, fmt.Errorf("%s: parent %s has no sketch", path, parent)
+		}
+		if err := stats.Sketch.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: parent %s: %s", path, parent, err.Error())
+		}
+	}
+	return snapshot, nil
+}
+
+/*
+Combines shard snapshots into one. A parent only one shard saw keeps its exact count; for a parent
+several shards saw, the count is the estimated size of the union of their sketches, kept between
+the largest shard count and the sum of all of them. The total is estimated the same way from the
+sketches of all certificates, so certificates under several parents are not counted twice.
+*/
+func MergeStatsSnapshots(snapshots []*StatsSnapshot) (*StatsSnapshot, error) {
+	merged := &StatsSnapshot{
+		Version: statsSnapshotVersion,
+		Created: time.Now().UTC(),
+		Parents: make(map[string]*ParentStats),
+	}
+
+	totals := make([]*ParentStats, 0, len(snapshots))
+	contributors := make(map[string][]*ParentStats)
+	for _, snapshot := range snapshots {
+		merged.Shards += snapshot.Shards
+		totals = append(totals, &ParentStats{Certificates: snapshot.Certificates, Estimated: snapshot.Estimated, Sketch: snapshot.Sketch})
+		for parent, stats := range snapshot.Parents {
+			contributors[parent] = append(contributors[parent], stats)
+		}
+	}
+
+	total, err := mergeParentStats(totals)
+	if err != nil {
+		return nil, fmt.Errorf("all certificates: %s", err.Error())
+	}
+	merged.Certificates, merged.Estimated, merged.Sketch = total.Certificates, total.Estimated, total.Sketch
+
+	for parent, parentStats := range contributors {
+		if merged.Parents[parent], err = mergeParentStats(parentStats); err != nil {
+			return nil, fmt.Errorf("parent %s: %s", parent, err.Error())
+		}
+	}
+
+	return merged, nil
+}
+
+// Counts of the same certificates from several shards, combined through the union of their sketches
+func mergeParentStats(parentStats []*ParentStats) (*ParentStats, error) {
+	if len(parentStats) == 1 {
+		return parentStats[0], nil
+	}
+
+	sketch := NewHyperLogLog()
+	var largest, sum uint64
+	for _, stats := range parentStats {
+		if err := sketch.Merge(stats.Sketch); err != nil {
+			return nil, err
+		}
+		if stats.Certificates > largest {
+			largest = stats.Certificates
+		}
+		sum += stats.Certificates
+	}
+
+	return &ParentStats{
+		Certificates: clampEstimate(sketch.Estimate(), largest, sum),
+		Estimated:    true,
+		Sketch:       sketch,
+	}, nil
+}
+
+// A union holds at least as many certificates as its largest part and at most all of them
+func clampEstimate(estimate, largest, sum uint64) uint64 {
+	if estimate < largest {
+		return largest
+	} else if estimate > sum {
+		return sum
+	}
+	return estimate
+}
+
+// The same report as CertStats.String, with parents sorted
+func (s *StatsSnapshot) String() string {
+	var str strings.Builder
+	estimated := 0
+	parents := make([]string, 0, len(s.Parents))
+	for parent, stats := range s.Parents {
+		if stats.Estimated {
+			estimated++
+		}
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+
+	str.WriteString(fmt.Sprintf("%d total parent SPKI subjects, %d total certificates (TBSNoCT)", len(s.Parents), s.Certificates))
+	if s.Estimated {
+		str.WriteString(", total estimated")
+	}
+	if estimated > 0 {
+		str.WriteString(fmt.Sprintf(", counts of %d parents estimated across %d shards", estimated, s.Shards))
+	}
+	str.WriteString("\n")
+
+	for _, parent := range parents {
+		str.WriteString(fmt.Sprintf("%s,%d\n", parent, s.Parents[parent].Certificates))
+	}
+
+	return str.String()
+}
@@ -0,0 +1,137 @@
+package certificate_searcher
+
+import (
+	"encoding/hex"
+	"github.com/steakknife/bloomfilter"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeStatsSnapshotsClamping(t *testing.T) {
+	tests := []struct {
+		name string
+		// count and sketched key range of each shard
+		counts        []uint64
+		ranges        [][2]int
+		want          uint64
+		maxError      float64
+		wantEstimated bool
+	}{
+		{"one shard keeps its count", []uint64{1234}, [][2]int{{0, 1000}}, 1234, 0, false},
+		{"disjoint shards", []uint64{1000, 1000}, [][2]int{{0, 1000}, {1000, 2000}}, 2000, 0.04, true},
+		{"overlapping shards", []uint64{1000, 1000}, [][2]int{{0, 1000}, {500, 1500}}, 1500, 0.04, true},
+		{"estimate below the largest count", []uint64{500, 20}, [][2]int{{0, 10}, {0, 10}}, 500, 0, true},
+		{"estimate above the sum of counts", []uint64{5, 5}, [][2]int{{0, 1000}, {1000, 2000}}, 10, 0, true},
+	}
+
+	for _, test := range tests {
+		snapshots := make([]*StatsSnapshot, 0, len(test.counts))
+		for idx, count := range test.counts {
+			sketch := sketchOfRange(test.ranges[idx][0], test.ranges[idx][1])
+			snapshots = append(snapshots, &StatsSnapshot{
+				Shards:       1,
+				Certificates: count,
+				Sketch:       sketch,
+				Parents:      map[string]*ParentStats{"parent": {Certificates: count, Sketch: sketch}},
+			})
+		}
+
+		merged, err := MergeStatsSnapshots(snapshots)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if merged.Shards != len(test.counts) {
+			t.Errorf("%s: %d shards, want %d", test.name, merged.Shards, len(test.counts))
+		}
+		for _, got := range []*ParentStats{merged.Parents["parent"], {Certificates: merged.Certificates, Estimated: merged.Estimated}} {
+			if err := relativeError(got.Certificates, int(test.want)); err > test.maxError {
+				t.Errorf("%s: %d certificates, want %d", test.name, got.Certificates, test.want)
+			}
+			if got.Estimated != test.wantEstimated {
+				t.Errorf("%s: estimated = %v, want %v", test.name, got.Estimated, test.wantEstimated)
+			}
+		}
+	}
+}
+
+// A certificate counted under different parents by two shards is one certificate in the total
+func TestMergeStatsSnapshotsTotal(t *testing.T) {
+	shards := make([]*CertStats, 2)
+	for idx := range shards {
+		shards[idx] = NewCertStats(&BloomDedupStore{Filter: mustBloomFilter(t)})
+	}
+	child := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		child[0], child[1] = byte(i>>8), byte(i)
+		shards[0].AddParentChild([]byte{0xaa}, child)
+		shards[1].AddParentChild([]byte{0xbb}, child)
+	}
+
+	merged, err := MergeStatsSnapshots([]*StatsSnapshot{shards[0].Snapshot(), shards[1].Snapshot()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Parents["aa"].Certificates != 1000 || merged.Parents["bb"].Certificates != 1000 {
+		t.Errorf("parent counts %d and %d, want 1000 each", merged.Parents["aa"].Certificates, merged.Parents["bb"].Certificates)
+	}
+	if err := relativeError(merged.Certificates, 1000); err > 0.04 {
+		t.Errorf("total %d certificates, want about 1000", merged.Certificates)
+	}
+}
+
+func mustBloomFilter(t *testing.T) *bloomfilter.Filter {
+	store, err := NewBloomDedupStore(10000, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.Filter
+}
+
+func TestStatsSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statssnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stats := NewCertStats(&BloomDedupStore{Filter: mustBloomFilter(t)})
+	parents := [][]byte{{0x01}, {0x02}, {0x03}}
+	for i := 0; i < 5000; i++ {
+		stats.AddParentChild(parents[i%len(parents)], []byte(hex.EncodeToString([]byte{byte(i >> 8), byte(i)})))
+	}
+	// already counted, so the parent is reported without a sketch
+	stats.AddParentChild([]byte{0x04}, []byte(hex.EncodeToString([]byte{0, 0})))
+
+	path := filepath.Join(dir, "stats.snapshot")
+	if err := WriteStatsSnapshot(path, stats.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStatsSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCertStats(&BloomDedupStore{Filter: mustBloomFilter(t)})
+	restored.Restore(loaded)
+	if restored.Certificates != 5000 || !reflect.DeepEqual(restored.ParentSPKISubjectCounts, stats.ParentSPKISubjectCounts) {
+		t.Errorf("restored %d certificates %v, want 5000 %v", restored.Certificates, restored.ParentSPKISubjectCounts, stats.ParentSPKISubjectCounts)
+	}
+	if restored.Sketch.Estimate() != stats.Sketch.Estimate() {
+		t.Errorf("restored total sketch estimates %d, want %d", restored.Sketch.Estimate(), stats.Sketch.Estimate())
+	}
+	for parent, sketch := range stats.ParentSPKISubjectSketches {
+		if restored.ParentSPKISubjectSketches[parent].Estimate() != sketch.Estimate() {
+			t.Errorf("restored sketch of parent %s differs", parent)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStatsSnapshot(path); err == nil {
+		t.Error("LoadStatsSnapshot of a corrupt file succeeded")
+	}
+}
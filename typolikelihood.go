@@ -0,0 +1,143 @@
+package certificate_searcher
+
+import "math"
+
+// Prior likelihood of each typo type, keyed by the label it produces
+type TypoPriors map[DomainLabel]float64
+
+/*
+Relative weight of each typo type: adjacent-key substitutions and omissions are assumed to be the
+most common slips and deliberate-looking edits like numeral swaps the rarest. These are
+hand-picked estimates rather than frequencies measured in a study; the typo_priors config section
+overrides them.
+*/
+func DefaultTypoPriors() TypoPriors {
+	return TypoPriors{
+		TYPOSQUATTING_CHAR_SUBSTITUTION:     0.30,
+		TYPOSQUATTING_CHAR_OMISSION:         0.25,
+		TYPOSQUATTING_CHAR_PERMUTATION:      0.20,
+		TYPOSQUATTING_CHAR_DUPLICATION:      0.15,
+		TYPOSQUATTING_CHAR_INSERTION:        0.10,
+		TYPOSQUATTING_MISSING_DOT:           0.10,
+		TYPOSQUATTING_MISSING_DOT_SUBDOMAIN: 0.08,
+		TYPOSQUATTING_MISSING_DOT_SUFFIX:    0.05,
+		TYPOSQUATTING_VOWEL_SWAP:            0.05,
+		TYPOSQUATTING_HYPHEN_REMOVAL:        0.05,
+		TYPOSQUATTING_HYPHEN_INSERTION:      0.03,
+		TYPOSQUATTING_NUMERAL_SWAP:          0.03,
+		TYPOSQUATTING_DOT_INSERTION:         0.02,
+		EDIT_DISTANCE:                       0.10,
+	}
+}
+
+var defaultTypoPriors = DefaultTypoPriors()
+
+// The prior of label, with nil priors meaning DefaultTypoPriors
+func (p TypoPriors) Prior(label DomainLabel) float64 {
+	if p == nil {
+		p = defaultTypoPriors
+	}
+	if prior, present := p[label]; present {
+		return prior
+	}
+	return defaultTypoPrior
+}
+
+const (
+	defaultTypoPrior float64 = 0.01
+	// Typos in the first character of a label are rarer, people look at what they type first
+	firstCharacterFactor float64 = 0.25
+	// Likelihood decays by this rate per key width beyond directly adjacent keys
+	keyDistanceDecay float64 = 0.5
+	// Applied when no layout has both keys, e.g. a substitution only explained by a custom layout
+	unknownKeyFactor float64 = 0.1
+	// Each additional edit beyond the first makes an edit-distance match this much less likely
+	additionalEditFactor float64 = 0.1
+)
+
+func firstDifference(a, b []rune) int {
+	idx := 0
+	for idx < len(a) && idx < len(b) && a[idx] == b[idx] {
+		idx++
+	}
+	return idx
+}
+
+// Offset of idx from the start of the label containing it
+func labelPosition(runes []rune, idx int) int {
+	start := 0
+	for i := 0; i < idx && i < len(runes); i++ {
+		if runes[i] == '.' {
+			start = i + 1
+		}
+	}
+	return idx - start
+}
+
+// Best likelihood factor over layouts for hitting typed when meaning intended
+func keyDistanceFactor(layouts []*KeyboardLayout, intended, typed rune) float64 {
+	best := unknownKeyFactor
+	for _, layout := range layouts {
+		dist, err := layout.Distance(intended, typed)
+		if err != nil || dist == 0 {
+			continue
+		}
+
+		factor := math.Exp(-keyDistanceDecay * math.Max(dist-1, 0))
+		if factor > best {
+			best = factor
+		}
+	}
+	return best
+}
+
+/*
+Estimates how likely a user meaning to type baseDomain lands on mutation through a single typo
+of the given type. The score combines the prior of the typo type, whether the edit falls on the
+first character of a label, and for keyboard typos the distance between the intended and typed keys.
+*/
+func TypoLikelihood(label DomainLabel, baseDomain, mutation string, layouts []*KeyboardLayout, priors TypoPriors) float64 {
+	likelihood := priors.Prior(label)
+
+	base, mutated := []rune(baseDomain), []rune(mutation)
+	idx := firstDifference(base, mutated)
+	if labelPosition(mutated, idx) == 0 {
+		likelihood *= firstCharacterFactor
+	}
+
+	switch label {
+	case TYPOSQUATTING_CHAR_SUBSTITUTION:
+		if idx < len(base) && idx < len(mutated) {
+			likelihood *= keyDistanceFactor(layouts, base[idx], mutated[idx])
+		}
+	case TYPOSQUATTING_CHAR_INSERTION:
+		if idx < len(mutated) {
+			// the extra key was hit next to either of its neighbours
+			best := 0.0
+			if idx > 0 {
+				best = keyDistanceFactor(layouts, base[idx-1], mutated[idx])
+			}
+			if idx < len(base) {
+				best = math.Max(best, keyDistanceFactor(layouts, base[idx], mutated[idx]))
+			}
+			likelihood *= best
+		}
+	}
+
+	return likelihood
+}
+
+// Likelihood of reaching mutation from baseDomain through editDistance independent edits
+func EditDistanceLikelihood(baseDomain, mutation string, editDistance int, priors TypoPriors) float64 {
+	if editDistance <= 0 {
+		return 0
+	}
+
+	likelihood := priors.Prior(EDIT_DISTANCE) * math.Pow(additionalEditFactor, float64(editDistance-1))
+	mutated := []rune(mutation)
+	if labelPosition(mutated, firstDifference([]rune(baseDomain), mutated)) == 0 {
+		likelihood *= firstCharacterFactor
+	}
+
+	return likelihood
+}
@@ -0,0 +1,102 @@
+package certificate_searcher
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTypoPriorsPrior(t *testing.T) {
+	custom := TypoPriors{TYPOSQUATTING_CHAR_OMISSION: 0.5}
+
+	tests := []struct {
+		priors TypoPriors
+		label  DomainLabel
+		want   float64
+	}{
+		{nil, TYPOSQUATTING_CHAR_SUBSTITUTION, 0.30},
+		{nil, EDIT_DISTANCE, 0.10},
+		// labels without a prior, like those of other labelers
+		{nil, WRONGTLD, defaultTypoPrior},
+		{custom, TYPOSQUATTING_CHAR_OMISSION, 0.5},
+		{custom, TYPOSQUATTING_CHAR_SUBSTITUTION, defaultTypoPrior},
+	}
+
+	for _, test := range tests {
+		if got := test.priors.Prior(test.label); got != test.want {
+			t.Errorf("%v.Prior(%s) = %f, want %f", test.priors, test.label, got, test.want)
+		}
+	}
+
+	// callers changing the priors they got must not change the defaults
+	priors := DefaultTypoPriors()
+	priors[TYPOSQUATTING_CHAR_SUBSTITUTION] = 1
+	if got := TypoPriors(nil).Prior(TYPOSQUATTING_CHAR_SUBSTITUTION); got != 0.30 {
+		t.Errorf("default substitution prior = %f after changing a copy, want 0.30", got)
+	}
+}
+
+func TestTypoLikelihood(t *testing.T) {
+	layouts := []*KeyboardLayout{QWERTY}
+	// one key width further than adjacent
+	oneKeyFurther := math.Exp(-keyDistanceDecay)
+
+	tests := []struct {
+		label               DomainLabel
+		baseDomain, mutated string
+		want                float64
+	}{
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", "goofle.com", 0.30},
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", "goohle.com", 0.30},
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", "goojle.com", 0.30 * oneKeyFurther},
+		// rarer at the start of any label
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", "foogle.com", 0.30 * firstCharacterFactor},
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "mail.google.com", "mail.foogle.com", 0.30 * firstCharacterFactor},
+		// keys no layout has
+		{TYPOSQUATTING_CHAR_SUBSTITUTION, "google.com", "goo_le.com", 0.30 * unknownKeyFactor},
+		// the extra key next to the one before it or the one after it
+		{TYPOSQUATTING_CHAR_INSERTION, "google.com", "gooigle.com", 0.10},
+		{TYPOSQUATTING_CHAR_INSERTION, "google.com", "goohgle.com", 0.10},
+		{TYPOSQUATTING_CHAR_INSERTION, "google.com", "goobgle.com", 0.10 * math.Exp(-keyDistanceDecay*(math.Sqrt(1.25)-1))},
+		{TYPOSQUATTING_CHAR_INSERTION, "google.com", "goozgle.com", 0.10 * math.Exp(-keyDistanceDecay*(math.Sqrt(13.25)-1))},
+		{TYPOSQUATTING_CHAR_OMISSION, "google.com", "gogle.com", 0.25},
+		{TYPOSQUATTING_CHAR_OMISSION, "google.com", "oogle.com", 0.25 * firstCharacterFactor},
+		{TYPOSQUATTING_NUMERAL_SWAP, "google.com", "go0gle.com", 0.03},
+		{WRONGTLD, "google.com", "google.co", defaultTypoPrior},
+	}
+
+	for _, test := range tests {
+		got := TypoLikelihood(test.label, test.baseDomain, test.mutated, layouts, nil)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("TypoLikelihood(%s, %q, %q) = %f, want %f", test.label, test.baseDomain, test.mutated, got, test.want)
+		}
+	}
+
+	// the closest of several layouts counts
+	got := TypoLikelihood(TYPOSQUATTING_CHAR_SUBSTITUTION, "amazon.com", "amaeon.com", []*KeyboardLayout{QWERTY, AZERTY}, nil)
+	if math.Abs(got-0.30) > 1e-9 {
+		t.Errorf("TypoLikelihood(amaeon.com) with QWERTY and AZERTY = %f, want 0.30", got)
+	}
+	if got := TypoLikelihood(TYPOSQUATTING_CHAR_OMISSION, "google.com", "gogle.com", layouts, TypoPriors{TYPOSQUATTING_CHAR_OMISSION: 0.9}); got != 0.9 {
+		t.Errorf("TypoLikelihood with custom priors = %f, want 0.9", got)
+	}
+}
+
+func TestEditDistanceLikelihood(t *testing.T) {
+	tests := []struct {
+		baseDomain, mutated string
+		distance            int
+		want                float64
+	}{
+		{"google.com", "gogle.net", 1, 0.10},
+		{"google.com", "gogel.net", 2, 0.10 * additionalEditFactor},
+		{"google.com", "foogle.net", 1, 0.10 * firstCharacterFactor},
+		{"google.com", "google.com", 0, 0},
+	}
+
+	for _, test := range tests {
+		got := EditDistanceLikelihood(test.baseDomain, test.mutated, test.distance, nil)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("EditDistanceLikelihood(%q, %q, %d) = %f, want %f", test.baseDomain, test.mutated, test.distance, got, test.want)
+		}
+	}
+}